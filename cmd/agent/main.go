@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"calculator/internal/agent"
 	calculator "calculator/internal/grpc/calculator"
@@ -25,11 +26,31 @@ func main() {
 	}
 	defer conn.Close()
 
+	// AGENT_OPERATIONS и AGENT_LABELS - списки через запятую, например "^,sin,cos" и "region=eu,gpu=yes".
+	opts := agent.Options{
+		Name:       os.Getenv("AGENT_NAME"),
+		Operations: readList("AGENT_OPERATIONS"),
+		Labels:     readList("AGENT_LABELS"),
+	}
+	if opts.Name == "" {
+		opts.Name = fmt.Sprintf("agent-%d", os.Getpid())
+	}
+
 	client := calculator.NewCalculatorAgentServiceClient(conn)
 	for i := 0; i < computingPower; i++ {
-		go agent.Worker(i, client)
+		go agent.Worker(i, client, opts)
 	}
 
 	fmt.Printf("Agent started with %d workers\n", computingPower)
 	select {}
 }
+
+func readList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
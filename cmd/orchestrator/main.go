@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"google.golang.org/grpc"
 )
@@ -40,9 +41,12 @@ func main() {
 	}
 	authService := orchestrator.NewAuthService(dbStore, jwtSecret)
 	schedulerService := orchestrator.NewScheduler(dbStore)
-	grpcServerInstance := orchestrator.NewCalculatorGRPCServer(dbStore, schedulerService.GetOperationTimes(), schedulerService)
+	grpcServerInstance := orchestrator.NewCalculatorGRPCServer(dbStore, schedulerService)
 
 	httpHandlers := orchestrator.NewHTTPHandlers(authService, dbStore, schedulerService)
+	go schedulerService.WatchDeadlines(time.Second)
+	go schedulerService.WatchSchedules(time.Second)
+	go schedulerService.WatchAgents(time.Second)
 
 	go func() {
 		lis, err := net.Listen("tcp", grpcPort)
@@ -66,6 +70,20 @@ func main() {
 	router.Handle("/api/v1/calculate", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.CalculateHandler)))
 	router.Handle("/api/v1/expressions", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.ExpressionsHandler)))
 	router.Handle("/api/v1/expressions/", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.ExpressionsHandler))) // Для путей с ID
+	router.Handle("/api/v1/derive", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.DeriveHandler)))
+	router.Handle("/api/v1/parse", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.ParseHandler)))
+	router.Handle("/api/v1/cache/stats", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.CacheStatsHandler)))
+	router.Handle("/api/v1/sweep", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.SweepHandler)))
+	router.Handle("/api/v1/sweep/", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.SweepHandler)))
+	router.Handle("/api/v1/solve", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.SolveHandler)))
+	router.Handle("/api/v1/solve/", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.SolveHandler)))
+	router.Handle("/api/v1/functions", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.FunctionsHandler)))
+	router.Handle("/api/v1/functions/", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.FunctionsHandler)))
+	router.Handle("/api/v1/schedules", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.SchedulesHandler)))
+	router.Handle("/api/v1/schedules/", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.SchedulesHandler)))
+	router.Handle("/api/v1/admin/users", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.AdminUsersHandler)))
+	router.Handle("/api/v1/admin/users/", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.AdminUsersHandler)))
+	router.Handle("/api/v1/admin/settings/", authService.JWTMiddleware(http.HandlerFunc(httpHandlers.AdminSettingsHandler)))
 
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
@@ -2,16 +2,27 @@ package agent
 
 import (
 	pb "calculator/internal/grpc/calculator" // Обновленный импорт gRPC кода
+	"calculator/internal/interval"
+	"calculator/internal/tensor"
 	"context"
 	"fmt"
 	"log"
+	"math"
+	"math/cmplx"
 	"time"
 )
 
-func Worker(workerID int, grpcClient pb.CalculatorAgentServiceClient) {
+// Options описывает агента для оркестратора: задачи выдаются только подходящим агентам.
+type Options struct {
+	Name       string   // Префикс ID воркеров; у разных агентов должен различаться
+	Operations []string // Операции, которые агент готов выполнять; пусто - любые
+	Labels     []string // Метки вида key=value, например region=eu
+}
+
+func Worker(workerID int, grpcClient pb.CalculatorAgentServiceClient, opts Options) {
 	log.Printf("Воркер %d запущен.", workerID)
 	ctx := context.Background() // Основной контекст для gRPC вызовов
-	agentID := fmt.Sprintf("agent-%d", workerID)
+	agentID := fmt.Sprintf("%s-%d", opts.Name, workerID)
 
 	for {
 		log.Printf("Воркер %d: Запрос задачи...", workerID)
@@ -19,7 +30,7 @@ func Worker(workerID int, grpcClient pb.CalculatorAgentServiceClient) {
 		var err error
 		var retryAfter time.Duration = 1 * time.Second // Задержка по умолчанию
 
-		getTaskReq := &pb.GetTaskRequest{AgentId: agentID}
+		getTaskReq := &pb.GetTaskRequest{AgentId: agentID, Operations: opts.Operations, Labels: opts.Labels}
 		getTaskResp, err := grpcClient.GetTask(ctx, getTaskReq)
 
 		if err != nil {
@@ -47,7 +58,49 @@ func Worker(workerID int, grpcClient pb.CalculatorAgentServiceClient) {
 		}
 
 		startTime := time.Now()
-		result, computeErr := compute(task.Arg1, task.Arg2, task.Operation)
+		var result float64
+		var resultTensor *tensor.Tensor
+		var resultComplex *pb.Complex
+		var resultInterval *pb.Interval
+		var computeErr error
+		permanent := false // Ошибка определяется аргументами: повторять задачу бессмысленно
+		if a, ok := interval.FromProto(task.Arg1Interval); ok {
+			// Режим интервалов: у функций одного аргумента второго интервала нет.
+			b, _ := interval.FromProto(task.Arg2Interval)
+			var r interval.Interval
+			r, computeErr = interval.Apply(task.Operation, a, b)
+			permanent = computeErr != nil
+			resultInterval = r.ToProto()
+		} else if task.Arg1Tensor != nil || task.Arg2Tensor != nil || task.Operation == "merge" {
+			resultTensor, computeErr = computeTensor(task)
+			if computeErr == nil && resultTensor.IsScalar() { // dot и median возвращают число
+				result, resultTensor = resultTensor.Data[0], nil
+			}
+		} else if task.Arg1Complex != nil || task.Arg2Complex != nil || complexDomain(task.Operation, task.Arg1, task.Arg2) {
+			var z complex128
+			z, computeErr = computeComplex(complexArg(task.Arg1, task.Arg1Complex), complexArg(task.Arg2, task.Arg2Complex), task.Operation)
+			if imag(z) == 0 {
+				result = real(z)
+			} else {
+				resultComplex = &pb.Complex{Re: real(z), Im: imag(z)}
+			}
+		} else {
+			result, computeErr = compute(task.Arg1, task.Arg2, task.Operation)
+		}
+		if computeErr == nil && !task.AllowSpecialValues {
+			values := []float64{result}
+			if resultTensor != nil {
+				values = append(values, resultTensor.Data...)
+			}
+			if resultComplex != nil {
+				values = append(values, resultComplex.Re, resultComplex.Im)
+			}
+			if resultInterval != nil {
+				values = append(values, resultInterval.Lo, resultInterval.Hi)
+			}
+			computeErr = checkSpecial(task.Operation, values...)
+			permanent = computeErr != nil
+		}
 		computationDuration := time.Since(startTime)
 
 		if task.OperationTimeMs > 0 {
@@ -64,8 +117,17 @@ func Worker(workerID int, grpcClient pb.CalculatorAgentServiceClient) {
 		if computeErr != nil {
 			log.Printf("Воркер %d: Ошибка вычисления задачи ID %d: %v", workerID, task.Id, computeErr)
 			submitReq.ResultStatus = &pb.SubmitResultRequest_Error{
-				Error: &pb.TaskError{Message: computeErr.Error()},
+				Error: &pb.TaskError{Message: computeErr.Error(), Permanent: permanent},
 			}
+		} else if resultInterval != nil {
+			log.Printf("Воркер %d: Завершено вычисление задачи ID %d. Результат: [%v, %v]", workerID, task.Id, resultInterval.Lo, resultInterval.Hi)
+			submitReq.ResultStatus = &pb.SubmitResultRequest_IntervalResult{IntervalResult: resultInterval}
+		} else if resultTensor != nil {
+			log.Printf("Воркер %d: Завершено вычисление задачи ID %d. Результат: %s", workerID, task.Id, resultTensor)
+			submitReq.ResultStatus = &pb.SubmitResultRequest_TensorResult{TensorResult: resultTensor.ToProto()}
+		} else if resultComplex != nil {
+			log.Printf("Воркер %d: Завершено вычисление задачи ID %d. Результат: %v%+vi", workerID, task.Id, resultComplex.Re, resultComplex.Im)
+			submitReq.ResultStatus = &pb.SubmitResultRequest_ComplexResult{ComplexResult: resultComplex}
 		} else {
 			log.Printf("Воркер %d: Завершено вычисление задачи ID %d. Результат: %f", workerID, task.Id, result)
 			submitReq.ResultStatus = &pb.SubmitResultRequest_Result{Result: result}
@@ -81,6 +143,38 @@ func Worker(workerID int, grpcClient pb.CalculatorAgentServiceClient) {
 	}
 }
 
+// unaryOps - операции одного аргумента: у них arg2 не используется.
+var unaryOps = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "exp": true, "ln": true, "sqrt": true, "abs": true,
+	"median": true,
+}
+
+// computeTensor выполняет задачу, у которой хотя бы один аргумент - вектор или матрица.
+// Числовой аргумент участвует как скаляр, поэлементные операции считает compute.
+func computeTensor(task *pb.Task) (*tensor.Tensor, error) {
+	if task.Arg1Complex != nil || task.Arg2Complex != nil {
+		return nil, fmt.Errorf("комплексные числа в операциях с векторами и матрицами не поддерживаются")
+	}
+	a, err := tensor.FromProto(task.Arg1Tensor)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		a = tensor.Scalar(task.Arg1)
+	}
+	if unaryOps[task.Operation] {
+		return tensor.Apply(task.Operation, a, nil, compute)
+	}
+	b, err := tensor.FromProto(task.Arg2Tensor)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		b = tensor.Scalar(task.Arg2)
+	}
+	return tensor.Apply(task.Operation, a, b, compute)
+}
+
 func compute(arg1, arg2 float64, op string) (float64, error) {
 	switch op {
 	case "+":
@@ -94,6 +188,115 @@ func compute(arg1, arg2 float64, op string) (float64, error) {
 			return 0, fmt.Errorf("деление на ноль")
 		}
 		return arg1 / arg2, nil
+	case "^":
+		if arg1 == 0 && arg2 < 0 {
+			return 0, fmt.Errorf("возведение нуля в отрицательную степень")
+		}
+		if arg1 < 0 && arg2 != math.Trunc(arg2) {
+			return 0, fmt.Errorf("дробная степень отрицательного числа")
+		}
+		return math.Pow(arg1, arg2), nil
+	// Функции одного аргумента: arg2 не используется.
+	case "sin":
+		return math.Sin(arg1), nil
+	case "cos":
+		return math.Cos(arg1), nil
+	case "tan":
+		return math.Tan(arg1), nil
+	case "exp":
+		return math.Exp(arg1), nil
+	case "ln":
+		if arg1 <= 0 {
+			return 0, fmt.Errorf("логарифм неположительного числа")
+		}
+		return math.Log(arg1), nil
+	case "sqrt":
+		if arg1 < 0 {
+			return 0, fmt.Errorf("корень из отрицательного числа")
+		}
+		return math.Sqrt(arg1), nil
+	case "abs":
+		return math.Abs(arg1), nil
+	case "median": // Медиана одного числа
+		return arg1, nil
+	default:
+		return 0, fmt.Errorf("неизвестная операция: %s", op)
+	}
+}
+
+// checkSpecial применяет политику strict: бесконечность в результате - переполнение,
+// NaN (0*Inf, Inf-Inf) - неопределенный результат. В политике ieee значения отправляются как есть.
+func checkSpecial(op string, values ...float64) error {
+	for _, v := range values {
+		if math.IsInf(v, 0) {
+			return fmt.Errorf("переполнение: результат операции '%s' равен %v", op, v)
+		}
+		if math.IsNaN(v) {
+			return fmt.Errorf("результат операции '%s' не определен (NaN)", op)
+		}
+	}
+	return nil
+}
+
+// complexDomain сообщает, что у операции над действительными числами результат комплексный:
+// корень и логарифм отрицательного числа, дробная степень отрицательного числа.
+func complexDomain(op string, arg1, arg2 float64) bool {
+	switch op {
+	case "sqrt", "ln":
+		return arg1 < 0
+	case "^":
+		return arg1 < 0 && arg2 != math.Trunc(arg2)
+	}
+	return false
+}
+
+func complexArg(re float64, c *pb.Complex) complex128 {
+	if c == nil {
+		return complex(re, 0)
+	}
+	return complex(c.Re, c.Im)
+}
+
+// computeComplex выполняет операцию над комплексными числами; берутся главные значения
+// корня, логарифма и степени.
+func computeComplex(arg1, arg2 complex128, op string) (complex128, error) {
+	switch op {
+	case "+":
+		return arg1 + arg2, nil
+	case "-":
+		return arg1 - arg2, nil
+	case "*":
+		return arg1 * arg2, nil
+	case "/":
+		if arg2 == 0 {
+			return 0, fmt.Errorf("деление на ноль")
+		}
+		return arg1 / arg2, nil
+	case "^":
+		if arg1 == 0 && real(arg2) < 0 {
+			return 0, fmt.Errorf("возведение нуля в отрицательную степень")
+		}
+		return cmplx.Pow(arg1, arg2), nil
+	// Функции одного аргумента: arg2 не используется.
+	case "sin":
+		return cmplx.Sin(arg1), nil
+	case "cos":
+		return cmplx.Cos(arg1), nil
+	case "tan":
+		return cmplx.Tan(arg1), nil
+	case "exp":
+		return cmplx.Exp(arg1), nil
+	case "ln":
+		if arg1 == 0 {
+			return 0, fmt.Errorf("логарифм нуля")
+		}
+		return cmplx.Log(arg1), nil
+	case "sqrt":
+		return cmplx.Sqrt(arg1), nil
+	case "abs":
+		return complex(cmplx.Abs(arg1), 0), nil
+	case "median":
+		return arg1, nil
 	default:
 		return 0, fmt.Errorf("неизвестная операция: %s", op)
 	}
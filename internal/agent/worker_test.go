@@ -1,6 +1,11 @@
 package agent
 
-import "testing"
+import (
+	pb "calculator/internal/grpc/calculator"
+	"math"
+	"math/cmplx"
+	"testing"
+)
 
 func TestCompute(t *testing.T) {
 	tests := []struct {
@@ -16,6 +21,13 @@ func TestCompute(t *testing.T) {
 		{"Multiplication", 3, 4, "*", 12, false},
 		{"Division", 12, 3, "/", 4, false},
 		{"DivideByZero", 10, 0, "/", 0, true},
+		{"Power", 2, 10, "^", 1024, false},
+		{"NegativeFractionalPower", -8, 0.5, "^", 0, true},
+		{"Sqrt", 16, 0, "sqrt", 4, false},
+		{"SqrtNegative", -1, 0, "sqrt", 0, true},
+		{"Ln", 1, 0, "ln", 0, false},
+		{"LnZero", 0, 0, "ln", 0, true},
+		{"Abs", -3, 0, "abs", 3, false},
 		{"UnknownOp", 2, 3, "%", 0, true},
 	}
 	for _, tc := range tests {
@@ -29,3 +41,97 @@ func TestCompute(t *testing.T) {
 		}
 	}
 }
+
+func TestComputeTensor(t *testing.T) {
+	matrix := &pb.Tensor{Shape: []int32{2, 2}, Data: []float64{1, 2, 3, 4}}
+	tests := []struct {
+		name    string
+		task    *pb.Task
+		want    string
+		wantErr bool
+	}{
+		{"ScaleVector", &pb.Task{Operation: "*", Arg1Tensor: &pb.Tensor{Shape: []int32{3}, Data: []float64{1, 2, 3}}, Arg2: 2}, "[2,4,6]", false},
+		{"MatMul", &pb.Task{Operation: "*", Arg1Tensor: matrix, Arg2Tensor: matrix}, "[[7,10],[15,22]]", false},
+		{"Sqrt", &pb.Task{Operation: "sqrt", Arg1Tensor: &pb.Tensor{Shape: []int32{2}, Data: []float64{4, 9}}}, "[2,3]", false},
+		{"DivideByZero", &pb.Task{Operation: "/", Arg1Tensor: matrix, Arg2: 0}, "", true},
+	}
+	for _, tc := range tests {
+		got, err := computeTensor(tc.task)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && got.String() != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestComputeComplex(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg1    complex128
+		arg2    complex128
+		op      string
+		want    complex128
+		wantErr bool
+	}{
+		{"Multiplication", 3 + 4i, 1 - 2i, "*", 11 - 2i, false},
+		{"Division", 11 - 2i, 1 - 2i, "/", 3 + 4i, false},
+		{"DivideByZero", 1i, 0, "/", 0, true},
+		{"SqrtNegative", -1, 0, "sqrt", 1i, false},
+		{"Abs", 3 + 4i, 0, "abs", 5, false},
+		{"LnNegative", -1, 0, "ln", complex(0, math.Pi), false},
+		{"LnZero", 0, 0, "ln", 0, true},
+	}
+	for _, tc := range tests {
+		got, err := computeComplex(tc.arg1, tc.arg2, tc.op)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: computeComplex(%v, %v, %q) error = %v, wantErr %v", tc.name, tc.arg1, tc.arg2, tc.op, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && cmplx.Abs(got-tc.want) > 1e-12 {
+			t.Errorf("%s: computeComplex(%v, %v, %q) = %v, want %v", tc.name, tc.arg1, tc.arg2, tc.op, got, tc.want)
+		}
+	}
+
+	// Действительные аргументы уходят в комплексную арифметику, только если результат не действительный.
+	domain := []struct {
+		arg1, arg2 float64
+		op         string
+		want       bool
+	}{
+		{-1, 0, "sqrt", true},
+		{4, 0, "sqrt", false},
+		{-8, 0.5, "^", true},
+		{-8, 3, "^", false},
+		{-1, 0, "abs", false},
+	}
+	for _, tc := range domain {
+		if got := complexDomain(tc.op, tc.arg1, tc.arg2); got != tc.want {
+			t.Errorf("complexDomain(%q, %v, %v) = %v, want %v", tc.op, tc.arg1, tc.arg2, got, tc.want)
+		}
+	}
+}
+
+func TestCheckSpecial(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []float64
+		wantErr bool
+	}{
+		{"Finite", []float64{1, -2.5, math.MaxFloat64}, false},
+		{"Overflow", []float64{math.Inf(1)}, true},
+		{"NegativeOverflow", []float64{1, math.Inf(-1)}, true},
+		{"NaN", []float64{math.NaN()}, true},
+	}
+	for _, tc := range tests {
+		if err := checkSpecial("*", tc.values...); (err != nil) != tc.wantErr {
+			t.Errorf("%s: checkSpecial(%v) error = %v, wantErr %v", tc.name, tc.values, err, tc.wantErr)
+		}
+	}
+	// compute сам не ошибается на переполнении - политику применяет checkSpecial.
+	if v, err := compute(1e308, 10, "*"); err != nil || !math.IsInf(v, 1) {
+		t.Errorf("compute(1e308, 10, \"*\") = %v, %v, want +Inf", v, err)
+	}
+}
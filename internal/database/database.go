@@ -2,8 +2,10 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +17,7 @@ type Store struct {
 	db   *sql.DB
 	path string
 	mu   sync.RWMutex
+	fair fairShare // Очередь пользователей для GetAndLeasePendingTask, защищена mu
 }
 
 func NewStore(dbPath string) (*Store, error) {
@@ -26,6 +29,11 @@ func NewStore(dbPath string) (*Store, error) {
 	db.SetMaxOpenConns(25)
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(5 * time.Minute)
+	if dbPath == ":memory:" {
+		// У каждого соединения своя база в памяти: второе соединение увидело бы пустую БД.
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
+	}
 
 	if err = db.Ping(); err != nil {
 		db.Close()
@@ -35,6 +43,7 @@ func NewStore(dbPath string) (*Store, error) {
 	store := &Store{
 		db:   db,
 		path: dbPath,
+		fair: fairShare{pass: make(map[int64]float64)},
 	}
 
 	log.Printf("Успешное подключение к базе данных: %s", dbPath)
@@ -60,6 +69,11 @@ func (s *Store) InitDB() error {
 			status TEXT NOT NULL,
 			result REAL,
 			steps TEXT,
+			no_cache INTEGER NOT NULL DEFAULT 0,
+			canonical TEXT NOT NULL DEFAULT '',
+			job_id INTEGER NOT NULL DEFAULT 0,
+			bindings TEXT NOT NULL DEFAULT '',
+			equation INTEGER NOT NULL DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY(user_id) REFERENCES users(id)
@@ -77,12 +91,163 @@ func (s *Store) InitDB() error {
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY(expression_id) REFERENCES expressions(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS task_edges (
+			parent_task_id INTEGER NOT NULL,
+			child_task_id INTEGER NOT NULL,
+			arg_index INTEGER NOT NULL,
+			resolved INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(parent_task_id, arg_index),
+			FOREIGN KEY(parent_task_id) REFERENCES tasks(id),
+			FOREIGN KEY(child_task_id) REFERENCES tasks(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_task_edges_child ON task_edges(child_task_id)`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			expression TEXT NOT NULL,
+			params TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			total INTEGER NOT NULL DEFAULT 0,
+			completed INTEGER NOT NULL DEFAULT 0,
+			failed INTEGER NOT NULL DEFAULT 0,
+			result TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS functions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			params TEXT NOT NULL,
+			body TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, name),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS expression_deps (
+			expression_id INTEGER NOT NULL,
+			depends_on INTEGER NOT NULL,
+			PRIMARY KEY(expression_id, depends_on),
+			FOREIGN KEY(expression_id) REFERENCES expressions(id),
+			FOREIGN KEY(depends_on) REFERENCES expressions(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_expression_deps_depends_on ON expression_deps(depends_on)`,
+		`CREATE TABLE IF NOT EXISTS schedules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			cron TEXT NOT NULL,
+			expression TEXT NOT NULL,
+			options TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			next_run INTEGER NOT NULL,
+			last_run INTEGER NOT NULL DEFAULT 0,
+			runs INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS settings_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			old_value TEXT NOT NULL DEFAULT '',
+			new_value TEXT NOT NULL,
+			changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_settings_audit_key ON settings_audit(key, id)`,
 	}
 	for _, stmt := range stmts {
 		if _, err := s.db.Exec(stmt); err != nil {
 			return fmt.Errorf("database migration error: %w", err)
 		}
 	}
+
+	columns := []struct{ table, column, definition string }{
+		{"tasks", "node_key", "TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "error", "TEXT NOT NULL DEFAULT ''"},
+		{"expressions", "no_cache", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "canonical", "TEXT NOT NULL DEFAULT ''"},
+		{"expressions", "job_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "bindings", "TEXT NOT NULL DEFAULT ''"},
+		{"expressions", "equation", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "result_tensor", "TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "arg1_tensor", "TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "arg2_tensor", "TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "result_tensor", "TEXT NOT NULL DEFAULT ''"},
+		{"expressions", "unit", "TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "unit", "TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "arg1_imag", "REAL NOT NULL DEFAULT 0"},
+		{"tasks", "arg2_imag", "REAL NOT NULL DEFAULT 0"},
+		{"tasks", "result_imag", "REAL NOT NULL DEFAULT 0"},
+		{"expressions", "result_imag", "REAL"},
+		{"expressions", "mode", "TEXT NOT NULL DEFAULT ''"},
+		{"expressions", "result_lo", "REAL"},
+		{"expressions", "result_hi", "REAL"},
+		{"tasks", "mode", "TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "arg1_hi", "REAL NOT NULL DEFAULT 0"},
+		{"tasks", "arg2_hi", "REAL NOT NULL DEFAULT 0"},
+		{"tasks", "result_hi", "REAL NOT NULL DEFAULT 0"},
+		{"expressions", "special_values", "TEXT NOT NULL DEFAULT ''"},
+		{"tasks", "special_values", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+		{"users", "weight", "INTEGER NOT NULL DEFAULT 1"},
+		{"expressions", "priority", "INTEGER NOT NULL DEFAULT 0"},
+		{"tasks", "critical_path", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "deadline", "INTEGER NOT NULL DEFAULT 0"}, // Unix-время в мс, 0 - без срока
+		{"expressions", "schedule_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"expressions", "labels", "TEXT NOT NULL DEFAULT '[]'"}, // JSON-список key=value
+		{"expressions", "ast", "TEXT NOT NULL DEFAULT ''"},      // JSON дерева, по которому созданы задачи
+	}
+	for _, c := range columns {
+		if err := s.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("database migration error: %w", err)
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing добавляет колонку в уже существующую таблицу (CREATE TABLE IF NOT EXISTS
+// не меняет схему базы, созданной старой версией).
+func (s *Store) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("ошибка чтения схемы таблицы %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("ошибка чтения схемы таблицы %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения схемы таблицы %s: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("ошибка добавления колонки %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
@@ -108,15 +273,21 @@ func (s *Store) CreateUser(login, passwordHash string) (int64, error) {
 	return id, nil
 }
 
+const userColumns = `id, login, password_hash, created_at, role, weight`
+
+func scanUser(row rowScanner, user *User) error {
+	return row.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.CreatedAt, &user.Role, &user.Weight)
+}
+
 func (s *Store) GetUserByLogin(login string) (*User, error) {
 	s.mu.RLock() // Используем RLock для чтения
 	defer s.mu.RUnlock()
 
-	query := `SELECT id, login, password_hash, created_at FROM users WHERE login = ?`
+	query := `SELECT ` + userColumns + ` FROM users WHERE login = ?`
 	row := s.db.QueryRow(query, login)
 
 	user := &User{}
-	err := row.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.CreatedAt)
+	err := scanUser(row, user)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Пользователь не найден - это не ошибка для этой функции
@@ -127,12 +298,132 @@ func (s *Store) GetUserByLogin(login string) (*User, error) {
 	return user, nil
 }
 
-func (s *Store) CreateExpression(userID int64, expression string) (int64, error) {
+func (s *Store) GetUserByID(id int64) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user := &User{}
+	if err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id), user); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения пользователя ID %d: %w", id, err)
+	}
+	return user, nil
+}
+
+func (s *Store) GetUsers() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := scanUser(rows, &user); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования пользователя: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по пользователям: %w", err)
+	}
+	return users, nil
+}
+
+// UpdateUserScheduling меняет роль и вес пользователя в очереди задач. Возвращает false,
+// если пользователь не найден.
+func (s *Store) UpdateUserScheduling(id int64, role string, weight int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.Exec(`UPDATE users SET role = ?, weight = ? WHERE id = ?`, role, weight, id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления пользователя ID %d: %w", id, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+const expressionColumns = `id, user_id, expression, status, result, steps, created_at, updated_at, no_cache, canonical, job_id, bindings, equation, result_tensor, unit, result_imag,
+	mode, result_lo, result_hi, special_values, priority, deadline, schedule_id, labels`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanExpression(row rowScanner, expr *Expression) error {
+	var resultTensor string
+	var resultImag, resultLo, resultHi sql.NullFloat64
+	var deadline int64
+	var labels string
+	err := row.Scan(
+		&expr.ID, &expr.UserID, &expr.Expression, &expr.Status,
+		&expr.Result, &expr.Steps, &expr.CreatedAt, &expr.UpdatedAt,
+		&expr.NoCache, &expr.Canonical, &expr.JobID, &expr.Bindings, &expr.Equation, &resultTensor, &expr.Unit, &resultImag,
+		&expr.Mode, &resultLo, &resultHi, &expr.SpecialValues, &expr.Priority, &deadline,
+		&expr.ScheduleID, &labels,
+	)
+	if deadline != 0 {
+		t := time.UnixMilli(deadline).UTC()
+		expr.Deadline = &t
+	}
+	expr.Labels = labelMap(labels)
+	if resultTensor != "" {
+		expr.ResultTensor = json.RawMessage(resultTensor)
+	}
+	// Комплексный результат хранится как result + result_imag, а клиенту отдается парой.
+	if resultImag.Valid {
+		expr.ResultComplex = &Complex{Re: expr.Result.Float64, Im: resultImag.Float64}
+		expr.Result = Number{}
+	}
+	// У интервального результата в result хранится середина, границы - в result_lo и result_hi.
+	if resultLo.Valid && resultHi.Valid {
+		expr.ResultInterval = &Interval{Lo: resultLo.Float64, Hi: resultHi.Float64, Mid: expr.Result.Float64}
+	}
+	return err
+}
+
+// sqlFloat готовит число к записи в REAL-колонку. SQLite сохраняет NaN как NULL, поэтому NaN
+// пишется текстом "NaN" - database/sql разбирает его обратно в float64. Бесконечности SQLite хранит сам.
+func sqlFloat(v float64) any {
+	if math.IsNaN(v) {
+		return "NaN"
+	}
+	return v
+}
+
+// CreateExpression сохраняет новое выражение в статусе pending. Используются поля UserID,
+// Expression, Canonical, JobID, ScheduleID, Bindings, Equation и ExpressionOptions.
+func (s *Store) CreateExpression(expr *Expression) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return insertExpression(s.db, expr)
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
 
-	query := `INSERT INTO expressions (user_id, expression, status) VALUES (?, ?, ?)`
-	res, err := s.db.Exec(query, userID, expression, StatusPending)
+func insertExpression(db execer, expr *Expression) (int64, error) {
+	var deadline int64
+	if expr.Deadline != nil {
+		deadline = expr.Deadline.UnixMilli()
+	}
+	labels, err := json.Marshal(LabelList(expr.Labels))
+	if err != nil {
+		return 0, fmt.Errorf("ошибка сериализации меток выражения: %w", err)
+	}
+	query := `INSERT INTO expressions (user_id, expression, canonical, job_id, schedule_id, bindings, equation, status, no_cache, mode,
+		special_values, priority, deadline, labels)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := db.Exec(query, expr.UserID, expr.Expression, expr.Canonical, expr.JobID, expr.ScheduleID, expr.Bindings, expr.Equation, StatusPending,
+		expr.NoCache, expr.Mode, expr.SpecialValues, expr.Priority, deadline, string(labels))
 	if err != nil {
 		return 0, fmt.Errorf("ошибка создания выражения: %w", err)
 	}
@@ -142,7 +433,7 @@ func (s *Store) CreateExpression(userID int64, expression string) (int64, error)
 		return 0, fmt.Errorf("ошибка получения ID нового выражения: %w", err)
 	}
 
-	log.Printf("Создано выражение ID %d для пользователя ID %d: %s", id, userID, expression)
+	log.Printf("Создано выражение ID %d для пользователя ID %d: %s", id, expr.UserID, expr.Expression)
 	return id, nil
 }
 
@@ -150,15 +441,12 @@ func (s *Store) GetExpressionByID(id, userID int64) (*Expression, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	query := `SELECT id, user_id, expression, status, result, steps, created_at, updated_at
+	query := `SELECT ` + expressionColumns + `
 	         FROM expressions WHERE id = ? AND user_id = ?`
 	row := s.db.QueryRow(query, id, userID)
 
 	expr := &Expression{}
-	err := row.Scan(
-		&expr.ID, &expr.UserID, &expr.Expression, &expr.Status,
-		&expr.Result, &expr.Steps, &expr.CreatedAt, &expr.UpdatedAt,
-	)
+	err := scanExpression(row, expr)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // Не найдено
@@ -168,11 +456,34 @@ func (s *Store) GetExpressionByID(id, userID int64) (*Expression, error) {
 	return expr, nil
 }
 
+// FindExpressionByCanonical ищет самое позднее выражение пользователя с той же канонической формой
+// и тем же числовым режимом: в режиме интервалов [1,2] - не вектор.
+func (s *Store) FindExpressionByCanonical(userID int64, canonical, mode string) (*Expression, error) {
+	if canonical == "" {
+		return nil, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := `SELECT ` + expressionColumns + `
+	         FROM expressions WHERE user_id = ? AND canonical = ? AND mode = ? ORDER BY id DESC LIMIT 1`
+	row := s.db.QueryRow(query, userID, canonical, mode)
+
+	expr := &Expression{}
+	if err := scanExpression(row, expr); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка поиска повторного выражения: %w", err)
+	}
+	return expr, nil
+}
+
 func (s *Store) GetExpressionsByUserID(userID int64) ([]Expression, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	query := `SELECT id, user_id, expression, status, result, steps, created_at, updated_at
+	query := `SELECT ` + expressionColumns + `
 	         FROM expressions WHERE user_id = ? ORDER BY created_at DESC`
 	rows, err := s.db.Query(query, userID)
 	if err != nil {
@@ -183,10 +494,7 @@ func (s *Store) GetExpressionsByUserID(userID int64) ([]Expression, error) {
 	var expressions []Expression
 	for rows.Next() {
 		expr := Expression{}
-		err := rows.Scan(
-			&expr.ID, &expr.UserID, &expr.Expression, &expr.Status,
-			&expr.Result, &expr.Steps, &expr.CreatedAt, &expr.UpdatedAt,
-		)
+		err := scanExpression(rows, &expr)
 		if err != nil {
 			log.Printf("Ошибка сканирования строки выражения: %v", err)
 			continue
@@ -205,9 +513,14 @@ func (s *Store) UpdateExpressionStatusResult(id int64, status string, result sql
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	query := `UPDATE expressions SET status = ?, result = ?, steps = ?, updated_at = CURRENT_TIMESTAMP
+	query := `UPDATE expressions SET status = ?, result = ?, result_imag = NULL, result_lo = NULL, result_hi = NULL,
+	         steps = ?, updated_at = CURRENT_TIMESTAMP
 	         WHERE id = ?`
-	_, err := s.db.Exec(query, status, result, stepsJSON, id)
+	var value any // NULL, если результата нет
+	if result.Valid {
+		value = sqlFloat(result.Float64)
+	}
+	_, err := s.db.Exec(query, status, value, stepsJSON, id)
 	if err != nil {
 		return fmt.Errorf("ошибка обновления выражения ID %d: %w", id, err)
 	}
@@ -215,167 +528,769 @@ func (s *Store) UpdateExpressionStatusResult(id int64, status string, result sql
 	return nil
 }
 
-func (s *Store) CreateTask(expressionID int64, operation string, arg1, arg2 float64) (int64, error) {
+// UpdateExpressionUnit сохраняет единицу измерения результата выражения.
+func (s *Store) UpdateExpressionUnit(id int64, unit string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	query := `INSERT INTO tasks (expression_id, operation, arg1, arg2, status) VALUES (?, ?, ?, ?, ?)`
-	res, err := s.db.Exec(query, expressionID, operation, arg1, arg2, StatusPending)
-	if err != nil {
-		return 0, fmt.Errorf("ошибка создания задачи для выражения ID %d: %w", expressionID, err)
-	}
-
-	id, err := res.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("ошибка получения ID новой задачи: %w", err)
+	if _, err := s.db.Exec(`UPDATE expressions SET unit = ? WHERE id = ?`, unit, id); err != nil {
+		return fmt.Errorf("ошибка сохранения единицы выражения ID %d: %w", id, err)
 	}
-
-	log.Printf("Создана задача ID %d для выражения ID %d: %f %s %f", id, expressionID, arg1, operation, arg2)
-	return id, nil
+	return nil
 }
 
-func (s *Store) GetAndLeasePendingTask() (*Task, error) {
-	s.mu.Lock() // Используем полную блокировку, так как чтение и запись
+// SetExpressionAST сохраняет дерево, по которому запланированы задачи выражения (JSON).
+func (s *Store) SetExpressionAST(id int64, ast string) error {
+	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	tx, err := s.db.Begin()
-	if err != nil {
-		return nil, fmt.Errorf("ошибка начала транзакции для получения задачи: %w", err)
+	if _, err := s.db.Exec(`UPDATE expressions SET ast = ? WHERE id = ?`, ast, id); err != nil {
+		return fmt.Errorf("ошибка сохранения дерева выражения ID %d: %w", id, err)
 	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-			panic(r) // Восстанавливаем панику
-		} else if err != nil {
-			tx.Rollback()
-		} else {
-			err = tx.Commit()
-			if err != nil {
-				log.Printf("Ошибка коммита транзакции при получении задачи: %v", err)
-			}
-		}
-	}()
-
-	querySelect := `SELECT id, expression_id, operation, arg1, arg2, status, retries, created_at, updated_at
-	                FROM tasks WHERE status = ? ORDER BY created_at ASC LIMIT 1`
-	row := tx.QueryRow(querySelect, StatusPending)
+	return nil
+}
 
-	task := &Task{}
-	err = row.Scan(
-		&task.ID, &task.ExpressionID, &task.Operation, &task.Arg1, &task.Arg2,
-		&task.Status, &task.Retries, &task.CreatedAt, &task.UpdatedAt,
-	)
+// GetExpressionAST возвращает дерево, сохраненное при планировании, или пустую строку,
+// если выражение еще не планировалось.
+func (s *Store) GetExpressionAST(id int64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Нет ожидающих задач
-		}
-		return nil, fmt.Errorf("ошибка поиска ожидающей задачи: %w", err)
+	var ast string
+	err := s.db.QueryRow(`SELECT ast FROM expressions WHERE id = ?`, id).Scan(&ast)
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
-
-	queryUpdate := `UPDATE tasks SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
-	_, err = tx.Exec(queryUpdate, StatusInProgress, task.ID)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка обновления статуса задачи ID %d: %w", task.ID, err)
+		return "", fmt.Errorf("ошибка получения дерева выражения ID %d: %w", id, err)
 	}
-
-	task.Status = StatusInProgress // Обновляем статус в возвращаемом объекте
-	log.Printf("Задача ID %d взята в обработку (Expression ID: %d)", task.ID, task.ExpressionID)
-	return task, nil // err будет nil здесь, defer обработает Commit
+	return ast, nil
 }
 
-func (s *Store) CompleteTask(taskID int64, result float64) error {
+// CompleteExpressionTensor завершает выражение, результат которого - вектор или матрица.
+func (s *Store) CompleteExpressionTensor(id int64, resultTensor string, stepsJSON sql.NullString) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	query := `UPDATE tasks SET status = ?, result = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
-	res, err := s.db.Exec(query, StatusDone, result, taskID, StatusInProgress)
-	if err != nil {
-		return fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+	query := `UPDATE expressions SET status = ?, result = NULL, result_tensor = ?, steps = ?, updated_at = CURRENT_TIMESTAMP
+	         WHERE id = ?`
+	if _, err := s.db.Exec(query, StatusDone, resultTensor, stepsJSON, id); err != nil {
+		return fmt.Errorf("ошибка обновления выражения ID %d: %w", id, err)
 	}
+	log.Printf("Выражение ID %d завершено с результатом %s", id, resultTensor)
+	return nil
+}
 
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		log.Printf("Предупреждение: Попытка завершить задачу ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+// CompleteExpressionComplex завершает выражение с комплексным результатом.
+func (s *Store) CompleteExpressionComplex(id int64, result Complex, stepsJSON sql.NullString) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE expressions SET status = ?, result = ?, result_imag = ?, steps = ?, updated_at = CURRENT_TIMESTAMP
+	         WHERE id = ?`
+	if _, err := s.db.Exec(query, StatusDone, sqlFloat(result.Re), sqlFloat(result.Im), stepsJSON, id); err != nil {
+		return fmt.Errorf("ошибка обновления выражения ID %d: %w", id, err)
 	}
+	log.Printf("Выражение ID %d завершено с результатом %v%+vi", id, result.Re, result.Im)
+	return nil
+}
 
-	log.Printf("Задача ID %d завершена с результатом: %f", taskID, result)
+// CompleteExpressionInterval завершает выражение, вычисленное в режиме интервалов. В result
+// сохраняется середина интервала.
+func (s *Store) CompleteExpressionInterval(id int64, result Interval, stepsJSON sql.NullString) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE expressions SET status = ?, result = ?, result_lo = ?, result_hi = ?, steps = ?, updated_at = CURRENT_TIMESTAMP
+	         WHERE id = ?`
+	if _, err := s.db.Exec(query, StatusDone, sqlFloat(result.Mid), result.Lo, result.Hi, stepsJSON, id); err != nil {
+		return fmt.Errorf("ошибка обновления выражения ID %d: %w", id, err)
+	}
+	log.Printf("Выражение ID %d завершено с результатом [%v, %v]", id, result.Lo, result.Hi)
 	return nil
 }
 
-func (s *Store) FailTask(taskID int64) error {
+// WaitForExpressions переводит выражение в статус waiting до завершения выражений dependsOn,
+// на результаты которых оно ссылается.
+func (s *Store) WaitForExpressions(id int64, dependsOn []int64, stepsJSON sql.NullString) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	query := `UPDATE tasks SET status = ?, retries = retries + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
-	res, err := s.db.Exec(query, StatusPending, taskID, StatusInProgress)
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("ошибка отметки задачи ID %d как ошибочной: %w", taskID, err)
-	}
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		log.Printf("Предупреждение: Попытка отметить ошибку для задачи ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+		return fmt.Errorf("ошибка начала транзакции для ожидания выражения ID %d: %w", id, err)
 	}
+	defer tx.Rollback()
 
-	log.Printf("Ошибка выполнения задачи ID %d, возвращена в очередь.", taskID)
+	for _, dep := range dependsOn {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO expression_deps (expression_id, depends_on) VALUES (?, ?)`, id, dep); err != nil {
+			return fmt.Errorf("ошибка сохранения зависимости выражения ID %d от ID %d: %w", id, dep, err)
+		}
+	}
+	query := `UPDATE expressions SET status = ?, result = NULL, steps = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := tx.Exec(query, StatusWaiting, stepsJSON, id); err != nil {
+		return fmt.Errorf("ошибка обновления выражения ID %d: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка коммита ожидания выражения ID %d: %w", id, err)
+	}
+	log.Printf("Выражение ID %d ожидает выражения %v", id, dependsOn)
 	return nil
 }
 
-func (s *Store) GetTaskByID(taskID int64) (*Task, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// TakeDependentExpressions возвращает выражения, ожидавшие завершения выражения dependsOn,
+// и снимает все их ожидания: каждое разбирается заново и при необходимости снова ждет.
+func (s *Store) TakeDependentExpressions(dependsOn int64) ([]Expression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	query := `SELECT id, expression_id, operation, arg1, arg2, result, status, retries, created_at, updated_at
-	         FROM tasks WHERE id = ?`
-	row := s.db.QueryRow(query, taskID)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка начала транзакции для зависимых выражений: %w", err)
+	}
+	defer tx.Rollback()
 
-	task := &Task{}
-	err := row.Scan(
-		&task.ID, &task.ExpressionID, &task.Operation, &task.Arg1, &task.Arg2,
-		&task.Result, &task.Status, &task.Retries, &task.CreatedAt, &task.UpdatedAt,
-	)
+	query := `SELECT ` + expressionColumns + ` FROM expressions
+		WHERE id IN (SELECT expression_id FROM expression_deps WHERE depends_on = ?) AND status = ?
+		ORDER BY id ASC`
+	rows, err := tx.Query(query, dependsOn, StatusWaiting)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Не найдено
+		return nil, fmt.Errorf("ошибка получения выражений, ожидающих ID %d: %w", dependsOn, err)
+	}
+	var expressions []Expression
+	for rows.Next() {
+		expr := Expression{}
+		if err := scanExpression(rows, &expr); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("ошибка сканирования выражения, ожидающего ID %d: %w", dependsOn, err)
 		}
-		return nil, fmt.Errorf("ошибка получения задачи ID %d: %w", taskID, err)
+		expressions = append(expressions, expr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по выражениям, ожидающим ID %d: %w", dependsOn, err)
 	}
-	return task, nil
-}
-
-func (s *Store) HasPendingTasks(expressionID int64) (bool, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
 
-	query := `SELECT 1 FROM tasks WHERE expression_id = ? AND status IN (?, ?) LIMIT 1`
-	var exists int
-	err := s.db.QueryRow(query, expressionID, StatusPending, StatusInProgress).Scan(&exists)
+	_, err = tx.Exec(`DELETE FROM expression_deps
+		WHERE expression_id IN (SELECT expression_id FROM expression_deps WHERE depends_on = ?)`, dependsOn)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil // Нет незавершенных задач
-		}
-		return false, fmt.Errorf("ошибка проверки незавершенных задач для выражения ID %d: %w", expressionID, err)
+		return nil, fmt.Errorf("ошибка удаления зависимостей от выражения ID %d: %w", dependsOn, err)
 	}
-	return true, nil // Найдена хотя бы одна незавершенная задача
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ошибка коммита зависимых выражений: %w", err)
+	}
+	return expressions, nil
 }
 
-func (s *Store) GetExpressionByIDInternal(id int64) (*Expression, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	query := `SELECT id, user_id, expression, status, result, steps, created_at, updated_at
-	         FROM expressions WHERE id = ?`
-	row := s.db.QueryRow(query, id)
+const taskColumns = `id, expression_id, operation, node_key, arg1, arg2, arg1_tensor, arg2_tensor,
+	arg1_imag, arg2_imag, result, result_tensor, result_imag, unit, status, error, retries, created_at, updated_at,
+	mode, arg1_hi, arg2_hi, result_hi, special_values, critical_path`
 
-	expr := &Expression{}
-	err := row.Scan(
-		&expr.ID, &expr.UserID, &expr.Expression, &expr.Status,
-		&expr.Result, &expr.Steps, &expr.CreatedAt, &expr.UpdatedAt,
+func scanTask(row rowScanner, task *Task) error {
+	return row.Scan(
+		&task.ID, &task.ExpressionID, &task.Operation, &task.NodeKey, &task.Arg1, &task.Arg2,
+		&task.Arg1Tensor, &task.Arg2Tensor, &task.Arg1Imag, &task.Arg2Imag,
+		&task.Result, &task.ResultTensor, &task.ResultImag, &task.Unit,
+		&task.Status, &task.Error, &task.Retries, &task.CreatedAt, &task.UpdatedAt,
+		&task.Mode, &task.Arg1Hi, &task.Arg2Hi, &task.ResultHi, &task.SpecialValues,
+		&task.CriticalPath,
 	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Не найдено
-		}
+}
+
+// CreateTask создает задачу для узла дерева. Используются поля ExpressionID, Operation, NodeKey,
+// Unit, Mode, SpecialValues и аргументы. children[i] - ID задачи, результат которой станет аргументом i+1 (0, если аргумент
+// уже известен). Пока есть невычисленные аргументы, задача находится в статусе waiting и не выдается агентам.
+func (s *Store) CreateTask(task *Task, children [2]int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := StatusPending
+	if children[0] != 0 || children[1] != 0 {
+		status = StatusWaiting
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции для создания задачи: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO tasks (expression_id, operation, node_key, arg1, arg2, arg1_tensor, arg2_tensor, arg1_imag, arg2_imag,
+		mode, arg1_hi, arg2_hi, special_values, unit, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := tx.Exec(query, task.ExpressionID, task.Operation, task.NodeKey, sqlFloat(task.Arg1), sqlFloat(task.Arg2),
+		task.Arg1Tensor, task.Arg2Tensor, sqlFloat(task.Arg1Imag), sqlFloat(task.Arg2Imag), task.Mode, task.Arg1Hi, task.Arg2Hi,
+		task.SpecialValues, task.Unit, status)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания задачи для выражения ID %d: %w", task.ExpressionID, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения ID новой задачи: %w", err)
+	}
+
+	for i, childID := range children {
+		if childID == 0 {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO task_edges (parent_task_id, child_task_id, arg_index) VALUES (?, ?, ?)`, id, childID, i+1)
+		if err != nil {
+			return 0, fmt.Errorf("ошибка сохранения связи задач %d -> %d: %w", childID, id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("ошибка коммита создания задачи: %w", err)
+	}
+
+	log.Printf("Создана задача ID %d (%s) для выражения ID %d: %s", id, status, task.ExpressionID, task.NodeKey)
+	return id, nil
+}
+
+// ExpiredExpressions возвращает незавершенные выражения, срок которых к моменту now истек.
+func (s *Store) ExpiredExpressions(now time.Time) ([]Expression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := `SELECT ` + expressionColumns + ` FROM expressions
+		WHERE deadline != 0 AND deadline <= ? AND status IN (?, ?, ?) ORDER BY id ASC`
+	rows, err := s.db.Query(query, now.UnixMilli(), StatusPending, StatusWaiting, StatusInProgress)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска просроченных выражений: %w", err)
+	}
+	defer rows.Close()
+
+	var expressions []Expression
+	for rows.Next() {
+		expr := Expression{}
+		if err := scanExpression(rows, &expr); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования просроченного выражения: %w", err)
+		}
+		expressions = append(expressions, expr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по просроченным выражениям: %w", err)
+	}
+	return expressions, nil
+}
+
+// TimeoutExpression переводит незавершенное выражение и все его невыполненные задачи в статус
+// timeout: результаты агентов по таким задачам больше не принимаются. Возвращает false, если
+// выражение уже завершилось.
+func (s *Store) TimeoutExpression(id int64, stepsJSON sql.NullString) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала транзакции для истечения срока выражения ID %d: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE expressions SET status = ?, steps = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status IN (?, ?, ?)`
+	res, err := tx.Exec(query, StatusTimeout, stepsJSON, id, StatusPending, StatusWaiting, StatusInProgress)
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления выражения ID %d: %w", id, err)
+	}
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		return false, nil
+	}
+	_, err = tx.Exec(`UPDATE tasks SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE expression_id = ? AND status IN (?, ?, ?)`,
+		StatusTimeout, id, StatusWaiting, StatusPending, StatusInProgress)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отмены задач выражения ID %d: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("ошибка коммита истечения срока выражения ID %d: %w", id, err)
+	}
+	log.Printf("Срок выражения ID %d истек", id)
+	return true, nil
+}
+
+// SetTaskCriticalPaths сохраняет длины оставшихся критических путей задач (в мс), по которым
+// GetAndLeasePendingTask выбирает задачу внутри выражения.
+func (s *Store) SetTaskCriticalPaths(paths map[int64]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции для сохранения критических путей: %w", err)
+	}
+	defer tx.Rollback()
+
+	for id, path := range paths {
+		if _, err := tx.Exec(`UPDATE tasks SET critical_path = ? WHERE id = ?`, path, id); err != nil {
+			return fmt.Errorf("ошибка сохранения критического пути задачи ID %d: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка коммита сохранения критических путей: %w", err)
+	}
+	return nil
+}
+
+// ResolveTaskConsumers подставляет результат выполненной задачи во все задачи, которые от нее
+// зависят, и переводит в pending те, у которых больше не осталось невычисленных аргументов.
+// Возвращает ID задач-потребителей; пустой список означает, что задача корневая.
+// Передаются все части результата child: число, мнимая часть, верхняя граница интервала и вектор/матрица.
+func (s *Store) ResolveTaskConsumers(child *Task) ([]int64, error) {
+	childTaskID := child.ID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка начала транзакции для разрешения зависимостей: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT parent_task_id, arg_index FROM task_edges WHERE child_task_id = ? AND resolved = 0`, childTaskID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения потребителей задачи ID %d: %w", childTaskID, err)
+	}
+	var edges []TaskEdge
+	for rows.Next() {
+		e := TaskEdge{ChildTaskID: childTaskID}
+		if err := rows.Scan(&e.ParentTaskID, &e.ArgIndex); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("ошибка сканирования связи задач: %w", err)
+		}
+		edges = append(edges, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по связям задач: %w", err)
+	}
+
+	var parents []int64
+	for _, e := range edges {
+		column := "arg1"
+		if e.ArgIndex == 2 {
+			column = "arg2"
+		}
+		query := `UPDATE tasks SET ` + column + ` = ?, ` + column + `_imag = ?, ` + column + `_hi = ?, ` + column + `_tensor = ?,
+			updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+		if _, err := tx.Exec(query, sqlFloat(child.Result.Float64), sqlFloat(child.ResultImag), child.ResultHi, child.ResultTensor, e.ParentTaskID); err != nil {
+			return nil, fmt.Errorf("ошибка подстановки аргумента в задачу ID %d: %w", e.ParentTaskID, err)
+		}
+		if _, err := tx.Exec(`UPDATE task_edges SET resolved = 1 WHERE parent_task_id = ? AND arg_index = ?`, e.ParentTaskID, e.ArgIndex); err != nil {
+			return nil, fmt.Errorf("ошибка обновления связи задач: %w", err)
+		}
+		_, err := tx.Exec(`UPDATE tasks SET status = ? WHERE id = ? AND status = ?
+			AND NOT EXISTS (SELECT 1 FROM task_edges WHERE parent_task_id = ? AND resolved = 0)`,
+			StatusPending, e.ParentTaskID, StatusWaiting, e.ParentTaskID)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка перевода задачи ID %d в очередь: %w", e.ParentTaskID, err)
+		}
+		parents = append(parents, e.ParentTaskID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ошибка коммита разрешения зависимостей: %w", err)
+	}
+	return parents, nil
+}
+
+// GetTaskEdges возвращает все связи между задачами выражения.
+func (s *Store) GetTaskEdges(expressionID int64) ([]TaskEdge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := `SELECT e.parent_task_id, e.child_task_id, e.arg_index, e.resolved
+		FROM task_edges e JOIN tasks t ON t.id = e.parent_task_id WHERE t.expression_id = ?`
+	rows, err := s.db.Query(query, expressionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса связей задач для выражения ID %d: %w", expressionID, err)
+	}
+	defer rows.Close()
+
+	var edges []TaskEdge
+	for rows.Next() {
+		var e TaskEdge
+		if err := rows.Scan(&e.ParentTaskID, &e.ChildTaskID, &e.ArgIndex, &e.Resolved); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования связи задач: %w", err)
+		}
+		edges = append(edges, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по связям задач: %w", err)
+	}
+	return edges, nil
+}
+
+// operationFilter - условие на колонку операции задачи: агент без списка операций выполняет любые.
+func (a AgentCapabilities) operationFilter(column string) (string, []any) {
+	if len(a.Operations) == 0 {
+		return "", nil
+	}
+	args := make([]any, len(a.Operations))
+	for i, op := range a.Operations {
+		args[i] = op
+	}
+	return fmt.Sprintf(" AND %s IN (%s)", column, placeholders(len(args))), args
+}
+
+// labelFilter - условие на JSON-список меток выражения: каждая метка должна быть у агента.
+func (a AgentCapabilities) labelFilter(column string) (string, []any) {
+	if len(a.Labels) == 0 {
+		return fmt.Sprintf(" AND %s = '[]'", column), nil
+	}
+	args := make([]any, len(a.Labels))
+	for i, label := range a.Labels {
+		args[i] = label
+	}
+	return fmt.Sprintf(" AND NOT EXISTS (SELECT 1 FROM json_each(%s) WHERE value NOT IN (%s))", column, placeholders(len(args))), args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// GetAndLeasePendingTask выдает агенту следующую задачу. Сначала обслуживаются выражения с наибольшим
+// приоритетом; среди пользователей с такими задачами выбирается тот, кто с учетом веса получил
+// меньше всех (взвешенный round-robin), а у него - задача самого старого выражения. Внутри выражения
+// первой выдается задача с самым длинным оставшимся критическим путем: цепочка, от которой
+// зависит время получения результата, начинает выполняться раньше независимых листьев.
+// Задачи выражений с истекшим сроком не выдаются, а агент получает только задачи с операциями
+// из agent.Operations и выражений, все метки которых есть в agent.Labels.
+func (s *Store) GetAndLeasePendingTask(agent AgentCapabilities) (*Task, error) {
+	s.mu.Lock() // Используем полную блокировку, так как чтение и запись
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка начала транзакции для получения задачи: %w", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r) // Восстанавливаем панику
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+			if err != nil {
+				log.Printf("Ошибка коммита транзакции при получении задачи: %v", err)
+			}
+		}
+	}()
+
+	now := time.Now().UnixMilli()
+	next, found, err := s.fair.next(tx, now, agent)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil // Нет ожидающих задач
+	}
+
+	labelFilter, labelArgs := agent.labelFilter("e.labels")
+	opFilter, opArgs := agent.operationFilter("operation")
+	querySelect := `SELECT ` + taskColumns + ` FROM tasks WHERE status = ?
+		AND expression_id IN (SELECT e.id FROM expressions e WHERE e.user_id = ? AND e.priority = ? AND (e.deadline = 0 OR e.deadline > ?)` +
+		labelFilter + `)` + opFilter + ` ORDER BY expression_id ASC, critical_path DESC, created_at ASC, id ASC LIMIT 1`
+	args := append([]any{StatusPending, next.userID, next.priority, now}, labelArgs...)
+	row := tx.QueryRow(querySelect, append(args, opArgs...)...)
+
+	task := &Task{}
+	err = scanTask(row, task)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Нет ожидающих задач
+		}
+		return nil, fmt.Errorf("ошибка поиска ожидающей задачи: %w", err)
+	}
+
+	queryUpdate := `UPDATE tasks SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err = tx.Exec(queryUpdate, StatusInProgress, task.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка обновления статуса задачи ID %d: %w", task.ID, err)
+	}
+
+	task.Status = StatusInProgress // Обновляем статус в возвращаемом объекте
+	s.fair.charge(next)
+	log.Printf("Задача ID %d взята в обработку (Expression ID: %d, пользователь ID %d)", task.ID, task.ExpressionID, next.userID)
+	return task, nil // err будет nil здесь, defer обработает Commit
+}
+
+// fairShare распределяет задачи между пользователями по схеме stride scheduling: у каждого
+// пользователя есть счетчик pass, каждая выданная задача увеличивает его на 1/weight, и следующую
+// задачу получает пользователь с наименьшим pass. Пользователь с весом 2 получает вдвое больше
+// задач, чем с весом 1, а пакет из тысяч выражений одного пользователя не задерживает остальных.
+// Состояние хранится в памяти и после перезапуска начинается заново.
+type fairShare struct {
+	pass  map[int64]float64
+	clock float64 // pass последнего выбранного пользователя
+}
+
+type fairCandidate struct {
+	userID   int64
+	priority int
+	weight   int
+	pass     float64
+	oldest   int64 // ID самой старой ожидающей задачи пользователя
+}
+
+// next выбирает пользователя, чья задача будет выдана следующей. Пользователь, у которого
+// долго не было задач, начинает с текущего clock и не получает накопленного за простой запаса.
+// Задачи выражений, срок которых к моменту now (Unix-время в мс) истек, и задачи, которые
+// агент не может выполнить, не учитываются.
+func (f *fairShare) next(tx *sql.Tx, now int64, agent AgentCapabilities) (fairCandidate, bool, error) {
+	labelFilter, labelArgs := agent.labelFilter("e.labels")
+	opFilter, opArgs := agent.operationFilter("t.operation")
+	args := append([]any{StatusPending, now}, labelArgs...)
+	rows, err := tx.Query(`SELECT e.user_id, MAX(e.priority), COALESCE(MAX(u.weight), 1), MIN(t.id)
+		FROM tasks t JOIN expressions e ON e.id = t.expression_id LEFT JOIN users u ON u.id = e.user_id
+		WHERE t.status = ? AND (e.deadline = 0 OR e.deadline > ?)`+labelFilter+opFilter+` GROUP BY e.user_id`,
+		append(args, opArgs...)...)
+	if err != nil {
+		return fairCandidate{}, false, fmt.Errorf("ошибка поиска пользователей с ожидающими задачами: %w", err)
+	}
+	defer rows.Close()
+
+	var best fairCandidate
+	found := false
+	for rows.Next() {
+		var c fairCandidate
+		if err := rows.Scan(&c.userID, &c.priority, &c.weight, &c.oldest); err != nil {
+			return fairCandidate{}, false, fmt.Errorf("ошибка сканирования очереди пользователей: %w", err)
+		}
+		c.pass = math.Max(f.pass[c.userID], f.clock)
+		if !found || c.priority > best.priority ||
+			(c.priority == best.priority && (c.pass < best.pass || (c.pass == best.pass && c.oldest < best.oldest))) {
+			best, found = c, true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fairCandidate{}, false, fmt.Errorf("ошибка итерации по очереди пользователей: %w", err)
+	}
+	return best, found, nil
+}
+
+// charge учитывает выданную пользователю задачу.
+func (f *fairShare) charge(c fairCandidate) {
+	weight := c.weight
+	if weight < 1 {
+		weight = 1
+	}
+	f.clock = c.pass
+	f.pass[c.userID] = c.pass + 1/float64(weight)
+}
+
+func (s *Store) CompleteTask(taskID int64, result float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE tasks SET status = ?, result = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	res, err := s.db.Exec(query, StatusDone, sqlFloat(result), taskID, StatusInProgress)
+	if err != nil {
+		return fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		log.Printf("Предупреждение: Попытка завершить задачу ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+	}
+
+	log.Printf("Задача ID %d завершена с результатом: %f", taskID, result)
+	return nil
+}
+
+// CompleteTensorTask сохраняет результат-вектор/матрицу задачи (JSON вложенными списками).
+func (s *Store) CompleteTensorTask(taskID int64, resultTensor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE tasks SET status = ?, result = 0, result_tensor = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	res, err := s.db.Exec(query, StatusDone, resultTensor, taskID, StatusInProgress)
+	if err != nil {
+		return fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		log.Printf("Предупреждение: Попытка завершить задачу ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+	}
+
+	log.Printf("Задача ID %d завершена с результатом: %s", taskID, resultTensor)
+	return nil
+}
+
+// CompleteComplexTask сохраняет комплексный результат задачи.
+func (s *Store) CompleteComplexTask(taskID int64, re, im float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE tasks SET status = ?, result = ?, result_imag = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	res, err := s.db.Exec(query, StatusDone, sqlFloat(re), sqlFloat(im), taskID, StatusInProgress)
+	if err != nil {
+		return fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		log.Printf("Предупреждение: Попытка завершить задачу ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+	}
+
+	log.Printf("Задача ID %d завершена с результатом: %v%+vi", taskID, re, im)
+	return nil
+}
+
+// CompleteIntervalTask сохраняет результат задачи в режиме интервалов: result - нижняя граница.
+func (s *Store) CompleteIntervalTask(taskID int64, lo, hi float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE tasks SET status = ?, result = ?, result_hi = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	res, err := s.db.Exec(query, StatusDone, lo, hi, taskID, StatusInProgress)
+	if err != nil {
+		return fmt.Errorf("ошибка завершения задачи ID %d: %w", taskID, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		log.Printf("Предупреждение: Попытка завершить задачу ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+	}
+
+	log.Printf("Задача ID %d завершена с результатом: [%v, %v]", taskID, lo, hi)
+	return nil
+}
+
+// CompleteCachedTask завершает ожидающую задачу результатом из кэша, не отдавая ее агенту.
+func (s *Store) CompleteCachedTask(taskID int64, result float64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE tasks SET status = ?, result = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	res, err := s.db.Exec(query, StatusDone, result, taskID, StatusPending)
+	if err != nil {
+		return false, fmt.Errorf("ошибка завершения задачи ID %d из кэша: %w", taskID, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// FailTask записывает ошибку агента. Задача возвращается в очередь, а постоянная ошибка
+// (результат определяется самими аргументами) переводит ее в статус error.
+func (s *Store) FailTask(taskID int64, message string, permanent bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE tasks SET status = CASE WHEN ? THEN ? ELSE ? END,
+		retries = retries + 1, error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	res, err := s.db.Exec(query, permanent, StatusError, StatusPending, message, taskID, StatusInProgress)
+	if err != nil {
+		return fmt.Errorf("ошибка отметки задачи ID %d как ошибочной: %w", taskID, err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		log.Printf("Предупреждение: Попытка отметить ошибку для задачи ID %d, которая не найдена или уже не в статусе '%s'", taskID, StatusInProgress)
+	}
+
+	log.Printf("Ошибка выполнения задачи ID %d: %s", taskID, message)
+	return nil
+}
+
+// TaskRoute - операция ожидающей задачи и метки ее выражения: по ним подбирается агент.
+type TaskRoute struct {
+	TaskID       int64
+	ExpressionID int64
+	Operation    string
+	Labels       []string
+}
+
+// PendingTaskRoutes возвращает по одной ожидающей задаче на каждую операцию незавершенных выражений.
+func (s *Store) PendingTaskRoutes() ([]TaskRoute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT MIN(t.id), t.expression_id, t.operation, e.labels
+		FROM tasks t JOIN expressions e ON e.id = t.expression_id
+		WHERE t.status = ? AND e.status IN (?, ?) GROUP BY t.expression_id, t.operation`, StatusPending, StatusPending, StatusInProgress)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения операций ожидающих задач: %w", err)
+	}
+	defer rows.Close()
+
+	var routes []TaskRoute
+	for rows.Next() {
+		var r TaskRoute
+		var labels string
+		if err := rows.Scan(&r.TaskID, &r.ExpressionID, &r.Operation, &labels); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования ожидающей задачи: %w", err)
+		}
+		r.Labels = LabelList(labelMap(labels))
+		routes = append(routes, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по ожидающим задачам: %w", err)
+	}
+	return routes, nil
+}
+
+// RejectTask переводит ожидающую задачу в статус error без попыток выполнения: например, если ее
+// не может взять ни один агент. Возвращает false, если задача уже не ожидает.
+func (s *Store) RejectTask(taskID int64, message string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.Exec(`UPDATE tasks SET status = ?, error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		StatusError, message, taskID, StatusPending)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отклонения задачи ID %d: %w", taskID, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *Store) GetTaskByID(taskID int64) (*Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE id = ?`
+	row := s.db.QueryRow(query, taskID)
+
+	task := &Task{}
+	err := scanTask(row, task)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Не найдено
+		}
+		return nil, fmt.Errorf("ошибка получения задачи ID %d: %w", taskID, err)
+	}
+	return task, nil
+}
+
+func (s *Store) HasPendingTasks(expressionID int64) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := `SELECT 1 FROM tasks WHERE expression_id = ? AND status IN (?, ?, ?) LIMIT 1`
+	var exists int
+	err := s.db.QueryRow(query, expressionID, StatusWaiting, StatusPending, StatusInProgress).Scan(&exists)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil // Нет незавершенных задач
+		}
+		return false, fmt.Errorf("ошибка проверки незавершенных задач для выражения ID %d: %w", expressionID, err)
+	}
+	return true, nil // Найдена хотя бы одна незавершенная задача
+}
+
+func (s *Store) GetExpressionByIDInternal(id int64) (*Expression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := `SELECT ` + expressionColumns + `
+	         FROM expressions WHERE id = ?`
+	row := s.db.QueryRow(query, id)
+
+	expr := &Expression{}
+	err := scanExpression(row, expr)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Не найдено
+		}
 		return nil, fmt.Errorf("ошибка получения выражения ID %d (внутр.): %w", id, err)
 	}
 	return expr, nil
@@ -386,8 +1301,7 @@ func (s *Store) GetAllTasksForExpression(expressionID int64) ([]Task, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	query := `SELECT id, expression_id, operation, arg1, arg2, result, status, retries, created_at, updated_at
-		FROM tasks WHERE expression_id = ?`
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE expression_id = ? ORDER BY id ASC`
 	rows, err := s.db.Query(query, expressionID)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка запроса задач для выражения ID %d: %w", expressionID, err)
@@ -397,11 +1311,7 @@ func (s *Store) GetAllTasksForExpression(expressionID int64) ([]Task, error) {
 	var tasks []Task
 	for rows.Next() {
 		var task Task
-		if err := rows.Scan(
-			&task.ID, &task.ExpressionID, &task.Operation,
-			&task.Arg1, &task.Arg2, &task.Result,
-			&task.Status, &task.Retries, &task.CreatedAt, &task.UpdatedAt,
-		); err != nil {
+		if err := scanTask(rows, &task); err != nil {
 			log.Printf("Ошибка сканирования строки задачи при GetAllTasksForExpression: %v", err)
 			continue // Пропускаем ошибочную строку, но продолжаем с остальными
 		}
@@ -414,3 +1324,460 @@ func (s *Store) GetAllTasksForExpression(expressionID int64) ([]Task, error) {
 
 	return tasks, nil
 }
+
+const jobColumns = `id, user_id, kind, expression, params, status, total, completed, failed, result, created_at, updated_at`
+
+func scanJob(row rowScanner, job *Job) error {
+	return row.Scan(
+		&job.ID, &job.UserID, &job.Kind, &job.Expression, &job.Params, &job.Status,
+		&job.Total, &job.Completed, &job.Failed, &job.Result, &job.CreatedAt, &job.UpdatedAt,
+	)
+}
+
+func (s *Store) CreateJob(job *Job) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `INSERT INTO jobs (user_id, kind, expression, params, status, total) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := s.db.Exec(query, job.UserID, job.Kind, job.Expression, job.Params, StatusPending, job.Total)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания задачи %s: %w", job.Kind, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения ID новой задачи %s: %w", job.Kind, err)
+	}
+
+	log.Printf("Создана задача %s ID %d для пользователя ID %d: %s", job.Kind, id, job.UserID, job.Expression)
+	return id, nil
+}
+
+// GetJobByID возвращает задачу пользователя; userID = 0 снимает проверку владельца.
+func (s *Store) GetJobByID(id, userID int64) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ? AND (? = 0 OR user_id = ?)`
+	row := s.db.QueryRow(query, id, userID, userID)
+
+	job := &Job{}
+	if err := scanJob(row, job); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения задачи ID %d: %w", id, err)
+	}
+	return job, nil
+}
+
+// RefreshJobProgress пересчитывает число завершенных выражений задачи и возвращает ее состояние.
+func (s *Store) RefreshJobProgress(jobID int64) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE jobs SET
+			completed = (SELECT COUNT(*) FROM expressions WHERE job_id = jobs.id AND status IN (?, ?)),
+			failed = (SELECT COUNT(*) FROM expressions WHERE job_id = jobs.id AND status = ?),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+	if _, err := s.db.Exec(query, StatusDone, StatusError, StatusError, jobID); err != nil {
+		return nil, fmt.Errorf("ошибка обновления прогресса задачи ID %d: %w", jobID, err)
+	}
+
+	job := &Job{}
+	row := s.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, jobID)
+	if err := scanJob(row, job); err != nil {
+		return nil, fmt.Errorf("ошибка получения задачи ID %d: %w", jobID, err)
+	}
+	return job, nil
+}
+
+func (s *Store) UpdateJobStatusResult(id int64, status string, resultJSON sql.NullString) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE jobs SET status = ?, result = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := s.db.Exec(query, status, resultJSON, id); err != nil {
+		return fmt.Errorf("ошибка обновления задачи ID %d: %w", id, err)
+	}
+	log.Printf("Обновлен статус задачи ID %d: Статус=%s", id, status)
+	return nil
+}
+
+// UpdateJobParams сохраняет состояние задачи и число порожденных ею выражений.
+func (s *Store) UpdateJobParams(id int64, params string, total int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE jobs SET params = ?, total = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if _, err := s.db.Exec(query, params, total, id); err != nil {
+		return fmt.Errorf("ошибка обновления параметров задачи ID %d: %w", id, err)
+	}
+	return nil
+}
+
+// GetJobExpressions возвращает выражения, порожденные задачей, в порядке создания.
+func (s *Store) GetJobExpressions(jobID int64) ([]Expression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT `+expressionColumns+` FROM expressions WHERE job_id = ? ORDER BY id ASC`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения выражений задачи ID %d: %w", jobID, err)
+	}
+	defer rows.Close()
+
+	var expressions []Expression
+	for rows.Next() {
+		expr := Expression{}
+		if err := scanExpression(rows, &expr); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования выражения задачи ID %d: %w", jobID, err)
+		}
+		expressions = append(expressions, expr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по выражениям задачи ID %d: %w", jobID, err)
+	}
+	return expressions, nil
+}
+
+const functionColumns = `id, user_id, name, params, body, created_at, updated_at`
+
+func scanFunction(row rowScanner, fn *Function) error {
+	var params string
+	if err := row.Scan(&fn.ID, &fn.UserID, &fn.Name, &params, &fn.Body, &fn.CreatedAt, &fn.UpdatedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(params), &fn.Params)
+}
+
+// SaveFunction создает функцию пользователя или заменяет существующую с тем же именем.
+func (s *Store) SaveFunction(fn *Function) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	params, err := json.Marshal(fn.Params)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации параметров функции '%s': %w", fn.Name, err)
+	}
+	query := `INSERT INTO functions (user_id, name, params, body) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, name) DO UPDATE SET params = excluded.params, body = excluded.body, updated_at = CURRENT_TIMESTAMP`
+	if _, err := s.db.Exec(query, fn.UserID, fn.Name, string(params), fn.Body); err != nil {
+		return fmt.Errorf("ошибка сохранения функции '%s' пользователя ID %d: %w", fn.Name, fn.UserID, err)
+	}
+	log.Printf("Сохранена функция %s(%s) пользователя ID %d", fn.Name, strings.Join(fn.Params, ", "), fn.UserID)
+	return nil
+}
+
+// GetFunctions возвращает функции пользователя, упорядоченные по имени.
+func (s *Store) GetFunctions(userID int64) ([]Function, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT `+functionColumns+` FROM functions WHERE user_id = ? ORDER BY name ASC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения функций пользователя ID %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var functions []Function
+	for rows.Next() {
+		fn := Function{}
+		if err := scanFunction(rows, &fn); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования функции пользователя ID %d: %w", userID, err)
+		}
+		functions = append(functions, fn)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по функциям пользователя ID %d: %w", userID, err)
+	}
+	return functions, nil
+}
+
+func (s *Store) GetFunction(userID int64, name string) (*Function, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	row := s.db.QueryRow(`SELECT `+functionColumns+` FROM functions WHERE user_id = ? AND name = ?`, userID, name)
+	fn := &Function{}
+	if err := scanFunction(row, fn); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения функции '%s' пользователя ID %d: %w", name, userID, err)
+	}
+	return fn, nil
+}
+
+// DeleteFunction удаляет функцию пользователя; false, если такой функции не было.
+func (s *Store) DeleteFunction(userID int64, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.Exec(`DELETE FROM functions WHERE user_id = ? AND name = ?`, userID, name)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления функции '%s' пользователя ID %d: %w", name, userID, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+const scheduleColumns = `id, user_id, cron, expression, options, status, next_run, last_run, runs, created_at, updated_at`
+
+func scanSchedule(row rowScanner, sch *Schedule) error {
+	var options string
+	var nextRun, lastRun int64
+	if err := row.Scan(&sch.ID, &sch.UserID, &sch.Cron, &sch.Expression, &options, &sch.Status,
+		&nextRun, &lastRun, &sch.Runs, &sch.CreatedAt, &sch.UpdatedAt); err != nil {
+		return err
+	}
+	sch.NextRun = time.UnixMilli(nextRun).UTC()
+	if lastRun != 0 {
+		t := time.UnixMilli(lastRun).UTC()
+		sch.LastRun = &t
+	}
+	if options == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(options), &sch.ExpressionOptions)
+}
+
+// CreateSchedule сохраняет активное расписание. Используются поля UserID, Cron, Expression,
+// NextRun и ExpressionOptions.
+func (s *Store) CreateSchedule(sch *Schedule) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	options, err := json.Marshal(sch.ExpressionOptions)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка сериализации параметров расписания: %w", err)
+	}
+	query := `INSERT INTO schedules (user_id, cron, expression, options, status, next_run) VALUES (?, ?, ?, ?, ?, ?)`
+	res, err := s.db.Exec(query, sch.UserID, sch.Cron, sch.Expression, string(options), ScheduleActive, sch.NextRun.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания расписания для пользователя ID %d: %w", sch.UserID, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения ID нового расписания: %w", err)
+	}
+	log.Printf("Создано расписание ID %d (%s) для пользователя ID %d: %s", id, sch.Cron, sch.UserID, sch.Expression)
+	return id, nil
+}
+
+func (s *Store) querySchedules(query string, args ...any) ([]Schedule, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения расписаний: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		sch := Schedule{}
+		if err := scanSchedule(rows, &sch); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования расписания: %w", err)
+		}
+		schedules = append(schedules, sch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по расписаниям: %w", err)
+	}
+	return schedules, nil
+}
+
+// GetSchedules возвращает расписания пользователя в порядке создания.
+func (s *Store) GetSchedules(userID int64) ([]Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.querySchedules(`SELECT `+scheduleColumns+` FROM schedules WHERE user_id = ? ORDER BY id ASC`, userID)
+}
+
+func (s *Store) GetSchedule(id, userID int64) (*Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	row := s.db.QueryRow(`SELECT `+scheduleColumns+` FROM schedules WHERE id = ? AND user_id = ?`, id, userID)
+	sch := &Schedule{}
+	if err := scanSchedule(row, sch); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения расписания ID %d: %w", id, err)
+	}
+	return sch, nil
+}
+
+// DueSchedules возвращает активные расписания, время срабатывания которых наступило к now.
+func (s *Store) DueSchedules(now time.Time) ([]Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.querySchedules(`SELECT `+scheduleColumns+` FROM schedules WHERE status = ? AND next_run <= ? ORDER BY next_run ASC, id ASC`,
+		ScheduleActive, now.UnixMilli())
+}
+
+// SetScheduleStatus приостанавливает или возобновляет расписание пользователя. nextRun
+// сохраняется при возобновлении: пропущенные за паузу срабатывания не наверстываются.
+// Возвращает false, если расписание не найдено.
+func (s *Store) SetScheduleStatus(id, userID int64, status string, nextRun time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := `UPDATE schedules SET status = ?, next_run = CASE WHEN ? = ? THEN ? ELSE next_run END, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?`
+	res, err := s.db.Exec(query, status, status, ScheduleActive, nextRun.UnixMilli(), id, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка изменения статуса расписания ID %d: %w", id, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// DeleteSchedule удаляет расписание пользователя; созданные им выражения остаются.
+func (s *Store) DeleteSchedule(id, userID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.Exec(`DELETE FROM schedules WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления расписания ID %d: %w", id, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// FireSchedule создает выражение очередного срабатывания расписания и переносит next_run на
+// nextRun в одной транзакции. Срабатывание засчитывается, только если расписание все еще
+// активно и его next_run не изменился с момента чтения sch, поэтому одно время срабатывания
+// не создает двух выражений, в том числе после перезапуска. Возвращает ID выражения или 0,
+// если срабатывание уже обработано, расписание приостановлено или удалено.
+func (s *Store) FireSchedule(sch *Schedule, nextRun time.Time, expr *Expression) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции для расписания ID %d: %w", sch.ID, err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE schedules SET next_run = ?, last_run = ?, runs = runs + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ? AND next_run = ?`
+	res, err := tx.Exec(query, nextRun.UnixMilli(), sch.NextRun.UnixMilli(), sch.ID, ScheduleActive, sch.NextRun.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("ошибка обновления расписания ID %d: %w", sch.ID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	expr.ScheduleID = sch.ID
+	id, err := insertExpression(tx, expr)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("ошибка коммита срабатывания расписания ID %d: %w", sch.ID, err)
+	}
+	return id, nil
+}
+
+// GetScheduleRuns возвращает не больше limit последних выражений, созданных расписанием.
+func (s *Store) GetScheduleRuns(scheduleID int64, limit int) ([]Expression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT `+expressionColumns+` FROM expressions WHERE schedule_id = ? ORDER BY id DESC LIMIT ?`, scheduleID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения истории расписания ID %d: %w", scheduleID, err)
+	}
+	defer rows.Close()
+
+	var expressions []Expression
+	for rows.Next() {
+		expr := Expression{}
+		if err := scanExpression(rows, &expr); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования выражения расписания ID %d: %w", scheduleID, err)
+		}
+		expressions = append(expressions, expr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по истории расписания ID %d: %w", scheduleID, err)
+	}
+	return expressions, nil
+}
+
+// GetSetting возвращает значение настройки key. Пустая строка - настройка не сохранялась.
+func (s *Store) GetSetting(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("ошибка получения настройки '%s': %w", key, err)
+	}
+	return value, nil
+}
+
+// SetSetting сохраняет значение настройки и запись о том, кто и как ее изменил, в одной транзакции.
+func (s *Store) SetSetting(key, value string, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции для настройки '%s': %w", key, err)
+	}
+	defer tx.Rollback()
+
+	var old string
+	if err := tx.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&old); err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("ошибка получения настройки '%s': %w", key, err)
+	}
+	query := `INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP`
+	if _, err := tx.Exec(query, key, value); err != nil {
+		return fmt.Errorf("ошибка сохранения настройки '%s': %w", key, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO settings_audit (key, user_id, old_value, new_value) VALUES (?, ?, ?, ?)`, key, userID, old, value); err != nil {
+		return fmt.Errorf("ошибка записи истории настройки '%s': %w", key, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка коммита настройки '%s': %w", key, err)
+	}
+	return nil
+}
+
+// GetSettingHistory возвращает не больше limit последних изменений настройки, новые первыми.
+func (s *Store) GetSettingHistory(key string, limit int) ([]SettingChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := `SELECT a.id, a.key, a.user_id, COALESCE(u.login, ''), a.old_value, a.new_value, a.changed_at
+		FROM settings_audit a LEFT JOIN users u ON u.id = a.user_id
+		WHERE a.key = ? ORDER BY a.id DESC LIMIT ?`
+	rows, err := s.db.Query(query, key, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения истории настройки '%s': %w", key, err)
+	}
+	defer rows.Close()
+
+	var changes []SettingChange
+	for rows.Next() {
+		var c SettingChange
+		var old, value string
+		if err := rows.Scan(&c.ID, &c.Key, &c.UserID, &c.Login, &old, &value, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования истории настройки '%s': %w", key, err)
+		}
+		if old != "" {
+			c.OldValue = json.RawMessage(old)
+		}
+		c.NewValue = json.RawMessage(value)
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка итерации по истории настройки '%s': %w", key, err)
+	}
+	return changes, nil
+}
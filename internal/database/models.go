@@ -2,6 +2,13 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,35 +17,316 @@ type User struct {
 	Login        string    `json:"login"`
 	PasswordHash string    `json:"-"` // Не отправляем хэш клиенту
 	CreatedAt    time.Time `json:"created_at"`
+	Role         string    `json:"role"`   // RoleUser или RoleAdmin
+	Weight       int       `json:"weight"` // Доля пользователя при распределении задач между агентами
 }
 
+// Роли пользователей: роль ограничивает приоритет выражений и открывает доступ к /api/v1/admin/.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type Expression struct {
-	ID         int64           `json:"id"`
-	UserID     int64           `json:"user_id"`
-	Expression string          `json:"expression"`
-	Status     string          `json:"status"`           // pending, in_progress, done, error
-	Result     sql.NullFloat64 `json:"result,omitempty"` // Используем NullFloat64 для поддержки NULL в БД
-	Steps      sql.NullString  `json:"steps,omitempty"`  // Шаги можно хранить как JSON строку
-	CreatedAt  time.Time       `json:"created_at"`
-	UpdatedAt  time.Time       `json:"updated_at"`
+	ID             int64           `json:"id"`
+	UserID         int64           `json:"user_id"`
+	Expression     string          `json:"expression"`
+	Status         string          `json:"status"`                    // pending, waiting, in_progress, done, error, timeout
+	Result         Number          `json:"result"`                    // null, пока результата нет
+	Steps          sql.NullString  `json:"steps,omitempty"`           // Шаги можно хранить как JSON строку
+	ResultTensor   json.RawMessage `json:"result_tensor,omitempty"`   // Результат-вектор/матрица вложенными списками (result при этом NULL)
+	ResultComplex  *Complex        `json:"result_complex,omitempty"`  // Комплексный результат (result при этом NULL)
+	ResultInterval *Interval       `json:"result_interval,omitempty"` // Границы результата в режиме интервалов (result - середина)
+	Unit           string          `json:"unit,omitempty"`            // Единица измерения результата: "m", "km/h"
+	Canonical      string          `json:"canonical,omitempty"`       // Каноническая форма для поиска повторов
+	JobID          int64           `json:"job_id,omitempty"`          // Родительская задача (sweep и т.п.), 0 - нет
+	ScheduleID     int64           `json:"schedule_id,omitempty"`     // Расписание, по которому создано выражение, 0 - нет
+	Bindings       string          `json:"bindings,omitempty"`        // JSON значений переменных, подставленных в выражение задачи
+	Equation       bool            `json:"equation,omitempty"`        // Уравнение решателя "lhs=rhs": разбирается через ParseEquation
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	ETA            *time.Time      `json:"eta,omitempty"` // Оценка времени завершения выполняющегося выражения, не хранится в БД
+	ExpressionOptions
+}
+
+// Job - родительская задача, которая порождает несколько выражений и следит за их выполнением.
+type Job struct {
+	ID         int64          `json:"id"`
+	UserID     int64          `json:"user_id"`
+	Kind       string         `json:"kind"` // sweep, solve
+	Expression string         `json:"expression"`
+	Params     string         `json:"-"` // JSON параметров, специфичных для вида задачи
+	Status     string         `json:"status"`
+	Total      int            `json:"total"`     // Сколько выражений порождено
+	Completed  int            `json:"completed"` // Сколько из них завершено (успешно или с ошибкой)
+	Failed     int            `json:"failed"`
+	Result     sql.NullString `json:"-"` // JSON итогового результата
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+const (
+	JobKindSweep = "sweep"
+	JobKindSolve = "solve"
+)
+
+// Number - результат выражения, которого может не быть (NULL в БД). В JSON это число или null,
+// а бесконечности и NaN, которых в JSON нет, записываются строками "+Inf", "-Inf" и "NaN".
+type Number struct {
+	sql.NullFloat64
+}
+
+func (n Number) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return jsonFloat(n.Float64).MarshalJSON()
+}
+
+func (n *Number) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*n = Number{}
+		return nil
+	}
+	var v jsonFloat
+	if err := v.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*n = Number{sql.NullFloat64{Float64: float64(v), Valid: true}}
+	return nil
+}
+
+// jsonFloat - число, которое кодируется в JSON и при значениях IEEE 754 вне JSON.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return []byte(strconv.Quote(strconv.FormatFloat(v, 'g', -1, 64))), nil // "+Inf", "-Inf", "NaN"
+	}
+	return json.Marshal(v)
+}
+
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) != nil {
+		return json.Unmarshal(data, (*float64)(f))
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(math.IsInf(v, 0) || math.IsNaN(v)) {
+		return fmt.Errorf("некорректное число %q", s)
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// Complex - комплексное число в JSON: {"re": 11, "im": -2}.
+type Complex struct {
+	Re float64 `json:"re"`
+	Im float64 `json:"im"`
+}
+
+type jsonComplex struct {
+	Re jsonFloat `json:"re"`
+	Im jsonFloat `json:"im"`
+}
+
+func (c Complex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonComplex{jsonFloat(c.Re), jsonFloat(c.Im)})
+}
+
+func (c *Complex) UnmarshalJSON(data []byte) error {
+	var v jsonComplex
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = Complex{float64(v.Re), float64(v.Im)}
+	return nil
+}
+
+// Interval - результат в режиме интервалов: {"lo": 19.6, "hi": 20.622, "mid": 20.111}.
+type Interval struct {
+	Lo  float64 `json:"lo"`
+	Hi  float64 `json:"hi"`
+	Mid float64 `json:"mid"`
+}
+
+type jsonInterval struct {
+	Lo  jsonFloat `json:"lo"`
+	Hi  jsonFloat `json:"hi"`
+	Mid jsonFloat `json:"mid"`
+}
+
+func (i Interval) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonInterval{jsonFloat(i.Lo), jsonFloat(i.Hi), jsonFloat(i.Mid)})
+}
+
+func (i *Interval) UnmarshalJSON(data []byte) error {
+	var v jsonInterval
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*i = Interval{float64(v.Lo), float64(v.Hi), float64(v.Mid)}
+	return nil
+}
+
+// ModeInterval - числовой режим, в котором каждое значение - интервал [lo, hi] с округлением
+// границ наружу. Пустой режим - обычные числа.
+const ModeInterval = "interval"
+
+// Политики для бесконечностей и NaN в результатах операций. Пустая политика - SpecialStrict.
+const (
+	SpecialStrict = "strict" // Переполнение и NaN - ошибка задачи
+	SpecialIEEE   = "ieee"   // Значения возвращаются как есть, в JSON - строками "+Inf", "-Inf", "NaN"
+)
+
+// ExpressionOptions - параметры вычисления, которые клиент задает при отправке выражения.
+type ExpressionOptions struct {
+	NoCache       bool       `json:"no_cache,omitempty"`       // Не брать результаты из кэша, всегда выполнять задачи на агентах
+	Mode          string     `json:"mode,omitempty"`           // Числовой режим: "" или ModeInterval
+	SpecialValues string     `json:"special_values,omitempty"` // Политика для Inf и NaN: "", SpecialStrict или SpecialIEEE
+	Priority      int        `json:"priority,omitempty"`       // Чем больше, тем раньше задачи выражения выдаются агентам
+	Deadline      *time.Time `json:"deadline,omitempty"`       // После этого момента задачи выражения не выдаются, а выражение получает статус timeout
+	// Задачи выражения выдаются только агентам со всеми этими метками, например {"region": "eu"}
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// AllowSpecialValues сообщает, возвращаются ли бесконечности и NaN как результат.
+func (o ExpressionOptions) AllowSpecialValues() bool {
+	return o.SpecialValues == SpecialIEEE
 }
 
 type Task struct {
-	ID           int64           `json:"id"`
-	ExpressionID int64           `json:"expression_id"`
-	Operation    string          `json:"operation"` // +, -, *, /
-	Arg1         float64         `json:"arg1"`
-	Arg2         float64         `json:"arg2"`
-	Result       sql.NullFloat64 `json:"result,omitempty"`
-	Status       string          `json:"status"` // pending, in_progress, done, error
-	CreatedAt    time.Time       `json:"created_at"`
-	UpdatedAt    time.Time       `json:"updated_at"`
-	Retries      int             `json:"retries"`
+	ID            int64           `json:"id"`
+	ExpressionID  int64           `json:"expression_id"`
+	Operation     string          `json:"operation"` // +, -, *, /
+	NodeKey       string          `json:"node_key"`  // Каноническая запись поддерева, которое вычисляет задача
+	Arg1          float64         `json:"arg1"`
+	Arg2          float64         `json:"arg2"`
+	Arg1Tensor    string          `json:"arg1_tensor,omitempty"` // JSON вектора/матрицы, если аргумент не число
+	Arg2Tensor    string          `json:"arg2_tensor,omitempty"`
+	Arg1Imag      float64         `json:"arg1_imag,omitempty"` // Мнимая часть аргумента, если он комплексный
+	Arg2Imag      float64         `json:"arg2_imag,omitempty"`
+	Result        sql.NullFloat64 `json:"result,omitempty"`
+	ResultTensor  string          `json:"result_tensor,omitempty"`
+	ResultImag    float64         `json:"result_imag,omitempty"`
+	Mode          string          `json:"mode,omitempty"`    // Числовой режим выражения
+	Arg1Hi        float64         `json:"arg1_hi,omitempty"` // Верхняя граница аргумента в режиме интервалов (arg1 - нижняя)
+	Arg2Hi        float64         `json:"arg2_hi,omitempty"`
+	ResultHi      float64         `json:"result_hi,omitempty"`
+	SpecialValues string          `json:"special_values,omitempty"` // Политика выражения для Inf и NaN
+	Unit          string          `json:"unit,omitempty"`           // Единица результата в СИ, если в выражении есть величины с единицами
+	Status        string          `json:"status"`                   // waiting, pending, in_progress, done, error
+	CriticalPath  int64           `json:"critical_path,omitempty"`  // Время (мс) от начала задачи до результата выражения по самой долгой цепочке
+	Error         string          `json:"error,omitempty"`          // Последняя ошибка, о которой сообщил агент
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	Retries       int             `json:"retries"`
+}
+
+// Function - именованная функция пользователя: tax(x) = x * 0.13.
+type Function struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	Name      string    `json:"name"`
+	Params    []string  `json:"params"`
+	Body      string    `json:"body"` // Выражение от параметров, может вызывать другие функции пользователя
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Schedule - выражение, которое вычисляется заново по расписанию cron.
+type Schedule struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Cron       string     `json:"cron"`
+	Expression string     `json:"expression"` // Может использовать now() - момент срабатывания
+	Status     string     `json:"status"`     // ScheduleActive или SchedulePaused
+	NextRun    time.Time  `json:"next_run"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	Runs       int        `json:"runs"` // Сколько выражений создано
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ExpressionOptions
 }
 
 const (
+	ScheduleActive = "active"
+	SchedulePaused = "paused"
+)
+
+// TaskEdge связывает задачу-потребителя с задачей, результат которой станет ее аргументом.
+type TaskEdge struct {
+	ParentTaskID int64 `json:"parent_task_id"`
+	ChildTaskID  int64 `json:"child_task_id"`
+	ArgIndex     int   `json:"arg_index"` // 1 или 2
+	Resolved     bool  `json:"resolved"`
+}
+
+const (
+	StatusWaiting    = "waiting" // Задача ждет результатов дочерних задач, выражение - выражений, на которые ссылается, или вычислений решателя
 	StatusPending    = "pending"
 	StatusInProgress = "in_progress"
 	StatusDone       = "done"
 	StatusError      = "error"
+	StatusTimeout    = "timeout" // Срок выражения истек раньше, чем были выполнены его задачи
 )
+
+// SettingOperationTimes - ключ настройки с временем выполнения операций (JSON).
+const SettingOperationTimes = "operation_times"
+
+// SettingChange - запись истории изменения настройки: кто, когда и с какого значения на какое.
+// Значения - JSON, OldValue пуст при первом сохранении.
+type SettingChange struct {
+	ID        int64           `json:"id"`
+	Key       string          `json:"key"`
+	UserID    int64           `json:"user_id"`
+	Login     string          `json:"login"`
+	OldValue  json.RawMessage `json:"old_value,omitempty"`
+	NewValue  json.RawMessage `json:"new_value"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+// AgentCapabilities - что умеет агент, запрашивающий задачу.
+type AgentCapabilities struct {
+	Operations []string // Пусто - любые операции
+	Labels     []string // Метки вида key=value
+}
+
+// Serves сообщает, может ли агент выполнить операцию op выражения с метками labels (key=value).
+// То же условие GetAndLeasePendingTask проверяет в запросе к БД.
+func (a AgentCapabilities) Serves(op string, labels []string) bool {
+	if len(a.Operations) > 0 && !slices.Contains(a.Operations, op) {
+		return false
+	}
+	for _, label := range labels {
+		if !slices.Contains(a.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelList переводит метки выражения в отсортированный список key=value - в этом виде их
+// передают агенты и хранит БД.
+func LabelList(labels map[string]string) []string {
+	list := make([]string, 0, len(labels))
+	for k, v := range labels {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func labelMap(list string) map[string]string {
+	var labels []string
+	if err := json.Unmarshal([]byte(list), &labels); err != nil || len(labels) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(labels))
+	for _, label := range labels {
+		k, v, _ := strings.Cut(label, "=")
+		m[k] = v
+	}
+	return m
+}
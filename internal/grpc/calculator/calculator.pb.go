@@ -10,13 +10,17 @@ import (
 )
 
 const (
+	// Verify that this generated code is sufficiently up-to-date.
 	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
 type GetTaskRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	AgentId       string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Operations    []string               `protobuf:"bytes,2,rep,name=operations,proto3" json:"operations,omitempty"` // Операции, которые агент готов выполнять; пусто - любые
+	Labels        []string               `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty"`         // Метки агента вида key=value, например region=eu
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -46,6 +50,7 @@ func (x *GetTaskRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
+// Deprecated: Use GetTaskRequest.ProtoReflect.Descriptor instead.
 func (*GetTaskRequest) Descriptor() ([]byte, []int) {
 	return file_calculator_proto_rawDescGZIP(), []int{0}
 }
@@ -57,8 +62,26 @@ func (x *GetTaskRequest) GetAgentId() string {
 	return ""
 }
 
+func (x *GetTaskRequest) GetOperations() []string {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+func (x *GetTaskRequest) GetLabels() []string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
 type GetTaskResponse struct {
-	state         protoimpl.MessageState     `protogen:"open.v1"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to TaskInfo:
+	//
+	//	*GetTaskResponse_Task
+	//	*GetTaskResponse_NoTask
 	TaskInfo      isGetTaskResponse_TaskInfo `protobuf_oneof:"task_info"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -89,6 +112,7 @@ func (x *GetTaskResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
+// Deprecated: Use GetTaskResponse.ProtoReflect.Descriptor instead.
 func (*GetTaskResponse) Descriptor() ([]byte, []int) {
 	return file_calculator_proto_rawDescGZIP(), []int{1}
 }
@@ -135,14 +159,21 @@ func (*GetTaskResponse_Task) isGetTaskResponse_TaskInfo() {}
 func (*GetTaskResponse_NoTask) isGetTaskResponse_TaskInfo() {}
 
 type Task struct {
-	state           protoimpl.MessageState `protogen:"open.v1"`
-	Id              int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`                                                    // ID задачи в БД
-	Arg1            float64                `protobuf:"fixed64,2,opt,name=arg1,proto3" json:"arg1,omitempty"`                                               // Первый аргумент
-	Arg2            float64                `protobuf:"fixed64,3,opt,name=arg2,proto3" json:"arg2,omitempty"`                                               // Второй аргумент
-	Operation       string                 `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`                                       // Операция (+, -, *, /)
-	OperationTimeMs int32                  `protobuf:"varint,5,opt,name=operation_time_ms,json=operationTimeMs,proto3" json:"operation_time_ms,omitempty"` // Время выполнения в мс
-	unknownFields   protoimpl.UnknownFields
-	sizeCache       protoimpl.SizeCache
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Id                 int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`                                                              // ID задачи в БД
+	Arg1               float64                `protobuf:"fixed64,2,opt,name=arg1,proto3" json:"arg1,omitempty"`                                                         // Первый аргумент
+	Arg2               float64                `protobuf:"fixed64,3,opt,name=arg2,proto3" json:"arg2,omitempty"`                                                         // Второй аргумент
+	Operation          string                 `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`                                                 // Операция (+, -, *, /)
+	OperationTimeMs    int32                  `protobuf:"varint,5,opt,name=operation_time_ms,json=operationTimeMs,proto3" json:"operation_time_ms,omitempty"`           // Время выполнения в мс
+	Arg1Tensor         *Tensor                `protobuf:"bytes,6,opt,name=arg1_tensor,json=arg1Tensor,proto3" json:"arg1_tensor,omitempty"`                             // Первый аргумент-вектор/матрица (вместо arg1)
+	Arg2Tensor         *Tensor                `protobuf:"bytes,7,opt,name=arg2_tensor,json=arg2Tensor,proto3" json:"arg2_tensor,omitempty"`                             // Второй аргумент-вектор/матрица (вместо arg2)
+	Arg1Complex        *Complex               `protobuf:"bytes,8,opt,name=arg1_complex,json=arg1Complex,proto3" json:"arg1_complex,omitempty"`                          // Первый аргумент-комплексное число (вместо arg1)
+	Arg2Complex        *Complex               `protobuf:"bytes,9,opt,name=arg2_complex,json=arg2Complex,proto3" json:"arg2_complex,omitempty"`                          // Второй аргумент-комплексное число (вместо arg2)
+	Arg1Interval       *Interval              `protobuf:"bytes,10,opt,name=arg1_interval,json=arg1Interval,proto3" json:"arg1_interval,omitempty"`                      // Первый аргумент-интервал [lo, hi] (вместо arg1)
+	Arg2Interval       *Interval              `protobuf:"bytes,11,opt,name=arg2_interval,json=arg2Interval,proto3" json:"arg2_interval,omitempty"`                      // Второй аргумент-интервал [lo, hi] (вместо arg2)
+	AllowSpecialValues bool                   `protobuf:"varint,12,opt,name=allow_special_values,json=allowSpecialValues,proto3" json:"allow_special_values,omitempty"` // Политика ieee: бесконечность и NaN в результате - не ошибка
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
 }
 
 func (x *Task) Reset() {
@@ -170,6 +201,7 @@ func (x *Task) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
+// Deprecated: Use Task.ProtoReflect.Descriptor instead.
 func (*Task) Descriptor() ([]byte, []int) {
 	return file_calculator_proto_rawDescGZIP(), []int{2}
 }
@@ -209,6 +241,55 @@ func (x *Task) GetOperationTimeMs() int32 {
 	return 0
 }
 
+func (x *Task) GetArg1Tensor() *Tensor {
+	if x != nil {
+		return x.Arg1Tensor
+	}
+	return nil
+}
+
+func (x *Task) GetArg2Tensor() *Tensor {
+	if x != nil {
+		return x.Arg2Tensor
+	}
+	return nil
+}
+
+func (x *Task) GetArg1Complex() *Complex {
+	if x != nil {
+		return x.Arg1Complex
+	}
+	return nil
+}
+
+func (x *Task) GetArg2Complex() *Complex {
+	if x != nil {
+		return x.Arg2Complex
+	}
+	return nil
+}
+
+func (x *Task) GetArg1Interval() *Interval {
+	if x != nil {
+		return x.Arg1Interval
+	}
+	return nil
+}
+
+func (x *Task) GetArg2Interval() *Interval {
+	if x != nil {
+		return x.Arg2Interval
+	}
+	return nil
+}
+
+func (x *Task) GetAllowSpecialValues() bool {
+	if x != nil {
+		return x.AllowSpecialValues
+	}
+	return false
+}
+
 type NoTaskAvailable struct {
 	state             protoimpl.MessageState `protogen:"open.v1"`
 	RetryAfterSeconds int32                  `protobuf:"varint,1,opt,name=retry_after_seconds,json=retryAfterSeconds,proto3" json:"retry_after_seconds,omitempty"`
@@ -241,6 +322,7 @@ func (x *NoTaskAvailable) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
+// Deprecated: Use NoTaskAvailable.ProtoReflect.Descriptor instead.
 func (*NoTaskAvailable) Descriptor() ([]byte, []int) {
 	return file_calculator_proto_rawDescGZIP(), []int{3}
 }
@@ -253,8 +335,15 @@ func (x *NoTaskAvailable) GetRetryAfterSeconds() int32 {
 }
 
 type SubmitResultRequest struct {
-	state         protoimpl.MessageState             `protogen:"open.v1"`
-	TaskId        int64                              `protobuf:"varint,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"` // ID выполненной задачи
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	TaskId int64                  `protobuf:"varint,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"` // ID выполненной задачи
+	// Types that are valid to be assigned to ResultStatus:
+	//
+	//	*SubmitResultRequest_Result
+	//	*SubmitResultRequest_Error
+	//	*SubmitResultRequest_TensorResult
+	//	*SubmitResultRequest_ComplexResult
+	//	*SubmitResultRequest_IntervalResult
 	ResultStatus  isSubmitResultRequest_ResultStatus `protobuf_oneof:"result_status"`
 	AgentId       string                             `protobuf:"bytes,4,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"` // ID агента, выполнившего задачу
 	unknownFields protoimpl.UnknownFields
@@ -286,6 +375,7 @@ func (x *SubmitResultRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
+// Deprecated: Use SubmitResultRequest.ProtoReflect.Descriptor instead.
 func (*SubmitResultRequest) Descriptor() ([]byte, []int) {
 	return file_calculator_proto_rawDescGZIP(), []int{4}
 }
@@ -322,6 +412,33 @@ func (x *SubmitResultRequest) GetError() *TaskError {
 	return nil
 }
 
+func (x *SubmitResultRequest) GetTensorResult() *Tensor {
+	if x != nil {
+		if x, ok := x.ResultStatus.(*SubmitResultRequest_TensorResult); ok {
+			return x.TensorResult
+		}
+	}
+	return nil
+}
+
+func (x *SubmitResultRequest) GetComplexResult() *Complex {
+	if x != nil {
+		if x, ok := x.ResultStatus.(*SubmitResultRequest_ComplexResult); ok {
+			return x.ComplexResult
+		}
+	}
+	return nil
+}
+
+func (x *SubmitResultRequest) GetIntervalResult() *Interval {
+	if x != nil {
+		if x, ok := x.ResultStatus.(*SubmitResultRequest_IntervalResult); ok {
+			return x.IntervalResult
+		}
+	}
+	return nil
+}
+
 func (x *SubmitResultRequest) GetAgentId() string {
 	if x != nil {
 		return x.AgentId
@@ -341,13 +458,32 @@ type SubmitResultRequest_Error struct {
 	Error *TaskError `protobuf:"bytes,3,opt,name=error,proto3,oneof"` // Информация об ошибке
 }
 
+type SubmitResultRequest_TensorResult struct {
+	TensorResult *Tensor `protobuf:"bytes,5,opt,name=tensor_result,json=tensorResult,proto3,oneof"` // Результат-вектор/матрица
+}
+
+type SubmitResultRequest_ComplexResult struct {
+	ComplexResult *Complex `protobuf:"bytes,6,opt,name=complex_result,json=complexResult,proto3,oneof"` // Результат-комплексное число
+}
+
+type SubmitResultRequest_IntervalResult struct {
+	IntervalResult *Interval `protobuf:"bytes,7,opt,name=interval_result,json=intervalResult,proto3,oneof"` // Результат-интервал
+}
+
 func (*SubmitResultRequest_Result) isSubmitResultRequest_ResultStatus() {}
 
 func (*SubmitResultRequest_Error) isSubmitResultRequest_ResultStatus() {}
 
+func (*SubmitResultRequest_TensorResult) isSubmitResultRequest_ResultStatus() {}
+
+func (*SubmitResultRequest_ComplexResult) isSubmitResultRequest_ResultStatus() {}
+
+func (*SubmitResultRequest_IntervalResult) isSubmitResultRequest_ResultStatus() {}
+
 type TaskError struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"` // Сообщение об ошибке (например, "деление на ноль")
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`      // Сообщение об ошибке (например, "деление на ноль")
+	Permanent     bool                   `protobuf:"varint,2,opt,name=permanent,proto3" json:"permanent,omitempty"` // Ошибка определяется аргументами задачи: повтор на другом агенте не поможет
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -377,6 +513,7 @@ func (x *TaskError) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
+// Deprecated: Use TaskError.ProtoReflect.Descriptor instead.
 func (*TaskError) Descriptor() ([]byte, []int) {
 	return file_calculator_proto_rawDescGZIP(), []int{5}
 }
@@ -388,6 +525,13 @@ func (x *TaskError) GetMessage() string {
 	return ""
 }
 
+func (x *TaskError) GetPermanent() bool {
+	if x != nil {
+		return x.Permanent
+	}
+	return false
+}
+
 type SubmitResultResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Acknowledged  bool                   `protobuf:"varint,1,opt,name=acknowledged,proto3" json:"acknowledged,omitempty"` // Подтверждение получения результата
@@ -420,6 +564,7 @@ func (x *SubmitResultResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
+// Deprecated: Use SubmitResultResponse.ProtoReflect.Descriptor instead.
 func (*SubmitResultResponse) Descriptor() ([]byte, []int) {
 	return file_calculator_proto_rawDescGZIP(), []int{6}
 }
@@ -431,39 +576,272 @@ func (x *SubmitResultResponse) GetAcknowledged() bool {
 	return false
 }
 
+type Tensor struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Shape         []int32                `protobuf:"varint,1,rep,packed,name=shape,proto3" json:"shape,omitempty"` // Размерности: [n] - вектор, [rows, cols] - матрица
+	Data          []float64              `protobuf:"fixed64,2,rep,packed,name=data,proto3" json:"data,omitempty"`  // Элементы построчно
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Tensor) Reset() {
+	*x = Tensor{}
+	mi := &file_calculator_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Tensor) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tensor) ProtoMessage() {}
+
+func (x *Tensor) ProtoReflect() protoreflect.Message {
+	mi := &file_calculator_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tensor.ProtoReflect.Descriptor instead.
+func (*Tensor) Descriptor() ([]byte, []int) {
+	return file_calculator_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Tensor) GetShape() []int32 {
+	if x != nil {
+		return x.Shape
+	}
+	return nil
+}
+
+func (x *Tensor) GetData() []float64 {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type Complex struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Re            float64                `protobuf:"fixed64,1,opt,name=re,proto3" json:"re,omitempty"` // Действительная часть
+	Im            float64                `protobuf:"fixed64,2,opt,name=im,proto3" json:"im,omitempty"` // Мнимая часть
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Complex) Reset() {
+	*x = Complex{}
+	mi := &file_calculator_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Complex) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Complex) ProtoMessage() {}
+
+func (x *Complex) ProtoReflect() protoreflect.Message {
+	mi := &file_calculator_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Complex.ProtoReflect.Descriptor instead.
+func (*Complex) Descriptor() ([]byte, []int) {
+	return file_calculator_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Complex) GetRe() float64 {
+	if x != nil {
+		return x.Re
+	}
+	return 0
+}
+
+func (x *Complex) GetIm() float64 {
+	if x != nil {
+		return x.Im
+	}
+	return 0
+}
+
+type Interval struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lo            float64                `protobuf:"fixed64,1,opt,name=lo,proto3" json:"lo,omitempty"` // Нижняя граница
+	Hi            float64                `protobuf:"fixed64,2,opt,name=hi,proto3" json:"hi,omitempty"` // Верхняя граница
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Interval) Reset() {
+	*x = Interval{}
+	mi := &file_calculator_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Interval) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Interval) ProtoMessage() {}
+
+func (x *Interval) ProtoReflect() protoreflect.Message {
+	mi := &file_calculator_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Interval.ProtoReflect.Descriptor instead.
+func (*Interval) Descriptor() ([]byte, []int) {
+	return file_calculator_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Interval) GetLo() float64 {
+	if x != nil {
+		return x.Lo
+	}
+	return 0
+}
+
+func (x *Interval) GetHi() float64 {
+	if x != nil {
+		return x.Hi
+	}
+	return 0
+}
+
 var File_calculator_proto protoreflect.FileDescriptor
 
-const file_calculator_proto_rawDesc = "" +
-	"\n" +
-	"\x10calculator.proto\x12\n" +
-	"calculator\"+\n" +
-	"\x0eGetTaskRequest\x12\x19\n" +
-	"\bagent_id\x18\x01 \x01(\tR\aagentId\"~\n" +
-	"\x0fGetTaskResponse\x12&\n" +
-	"\x04task\x18\x01 \x01(\v2\x10.calculator.TaskH\x00R\x04task\x126\n" +
-	"\ano_task\x18\x02 \x01(\v2\x1b.calculator.NoTaskAvailableH\x00R\x06noTaskB\v\n" +
-	"\ttask_info\"\x88\x01\n" +
-	"\x04Task\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
-	"\x04arg1\x18\x02 \x01(\x01R\x04arg1\x12\x12\n" +
-	"\x04arg2\x18\x03 \x01(\x01R\x04arg2\x12\x1c\n" +
-	"\toperation\x18\x04 \x01(\tR\toperation\x12*\n" +
-	"\x11operation_time_ms\x18\x05 \x01(\x05R\x0foperationTimeMs\"A\n" +
-	"\x0fNoTaskAvailable\x12.\n" +
-	"\x13retry_after_seconds\x18\x01 \x01(\x05R\x11retryAfterSeconds\"\xa3\x01\n" +
-	"\x13SubmitResultRequest\x12\x17\n" +
-	"\atask_id\x18\x01 \x01(\x03R\x06taskId\x12\x18\n" +
-	"\x06result\x18\x02 \x01(\x01H\x00R\x06result\x12-\n" +
-	"\x05error\x18\x03 \x01(\v2\x15.calculator.TaskErrorH\x00R\x05error\x12\x19\n" +
-	"\bagent_id\x18\x04 \x01(\tR\aagentIdB\x0f\n" +
-	"\rresult_status\"%\n" +
-	"\tTaskError\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\tR\amessage\":\n" +
-	"\x14SubmitResultResponse\x12\"\n" +
-	"\facknowledged\x18\x01 \x01(\bR\facknowledged2\xaf\x01\n" +
-	"\x16CalculatorAgentService\x12B\n" +
-	"\aGetTask\x12\x1a.calculator.GetTaskRequest\x1a\x1b.calculator.GetTaskResponse\x12Q\n" +
-	"\fSubmitResult\x12\x1f.calculator.SubmitResultRequest\x1a .calculator.SubmitResultResponseB Z\x1ecalculator/pkg/grpc/calculatorb\x06proto3"
+var file_calculator_proto_rawDesc = string([]byte{
+	0x0a, 0x10, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x0a, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x22, 0x63,
+	0x0a, 0x0e, 0x47, 0x65, 0x74, 0x54, 0x61, 0x73, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x19, 0x0a, 0x08, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x07, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x6f,
+	0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x0a, 0x6f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x6c,
+	0x61, 0x62, 0x65, 0x6c, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x6c, 0x61, 0x62,
+	0x65, 0x6c, 0x73, 0x22, 0x7e, 0x0a, 0x0f, 0x47, 0x65, 0x74, 0x54, 0x61, 0x73, 0x6b, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x26, 0x0a, 0x04, 0x74, 0x61, 0x73, 0x6b, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f,
+	0x72, 0x2e, 0x54, 0x61, 0x73, 0x6b, 0x48, 0x00, 0x52, 0x04, 0x74, 0x61, 0x73, 0x6b, 0x12, 0x36,
+	0x0a, 0x07, 0x6e, 0x6f, 0x5f, 0x74, 0x61, 0x73, 0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1b, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x4e, 0x6f, 0x54,
+	0x61, 0x73, 0x6b, 0x41, 0x76, 0x61, 0x69, 0x6c, 0x61, 0x62, 0x6c, 0x65, 0x48, 0x00, 0x52, 0x06,
+	0x6e, 0x6f, 0x54, 0x61, 0x73, 0x6b, 0x42, 0x0b, 0x0a, 0x09, 0x74, 0x61, 0x73, 0x6b, 0x5f, 0x69,
+	0x6e, 0x66, 0x6f, 0x22, 0x8a, 0x04, 0x0a, 0x04, 0x54, 0x61, 0x73, 0x6b, 0x12, 0x0e, 0x0a, 0x02,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04,
+	0x61, 0x72, 0x67, 0x31, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x04, 0x61, 0x72, 0x67, 0x31,
+	0x12, 0x12, 0x0a, 0x04, 0x61, 0x72, 0x67, 0x32, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x04,
+	0x61, 0x72, 0x67, 0x32, 0x12, 0x1c, 0x0a, 0x09, 0x6f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x2a, 0x0a, 0x11, 0x6f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f,
+	0x74, 0x69, 0x6d, 0x65, 0x5f, 0x6d, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0f, 0x6f,
+	0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x4d, 0x73, 0x12, 0x33,
+	0x0a, 0x0b, 0x61, 0x72, 0x67, 0x31, 0x5f, 0x74, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72,
+	0x2e, 0x54, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x52, 0x0a, 0x61, 0x72, 0x67, 0x31, 0x54, 0x65, 0x6e,
+	0x73, 0x6f, 0x72, 0x12, 0x33, 0x0a, 0x0b, 0x61, 0x72, 0x67, 0x32, 0x5f, 0x74, 0x65, 0x6e, 0x73,
+	0x6f, 0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75,
+	0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x54, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x52, 0x0a, 0x61, 0x72,
+	0x67, 0x32, 0x54, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x12, 0x36, 0x0a, 0x0c, 0x61, 0x72, 0x67, 0x31,
+	0x5f, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13,
+	0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x43, 0x6f, 0x6d, 0x70,
+	0x6c, 0x65, 0x78, 0x52, 0x0b, 0x61, 0x72, 0x67, 0x31, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78,
+	0x12, 0x36, 0x0a, 0x0c, 0x61, 0x72, 0x67, 0x32, 0x5f, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61,
+	0x74, 0x6f, 0x72, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x0b, 0x61, 0x72, 0x67,
+	0x32, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x12, 0x39, 0x0a, 0x0d, 0x61, 0x72, 0x67, 0x31,
+	0x5f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x14, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x49, 0x6e, 0x74,
+	0x65, 0x72, 0x76, 0x61, 0x6c, 0x52, 0x0c, 0x61, 0x72, 0x67, 0x31, 0x49, 0x6e, 0x74, 0x65, 0x72,
+	0x76, 0x61, 0x6c, 0x12, 0x39, 0x0a, 0x0d, 0x61, 0x72, 0x67, 0x32, 0x5f, 0x69, 0x6e, 0x74, 0x65,
+	0x72, 0x76, 0x61, 0x6c, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x63, 0x61, 0x6c,
+	0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c,
+	0x52, 0x0c, 0x61, 0x72, 0x67, 0x32, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x12, 0x30,
+	0x0a, 0x14, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x5f, 0x73, 0x70, 0x65, 0x63, 0x69, 0x61, 0x6c, 0x5f,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x08, 0x52, 0x12, 0x61, 0x6c,
+	0x6c, 0x6f, 0x77, 0x53, 0x70, 0x65, 0x63, 0x69, 0x61, 0x6c, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x73,
+	0x22, 0x41, 0x0a, 0x0f, 0x4e, 0x6f, 0x54, 0x61, 0x73, 0x6b, 0x41, 0x76, 0x61, 0x69, 0x6c, 0x61,
+	0x62, 0x6c, 0x65, 0x12, 0x2e, 0x0a, 0x13, 0x72, 0x65, 0x74, 0x72, 0x79, 0x5f, 0x61, 0x66, 0x74,
+	0x65, 0x72, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x11, 0x72, 0x65, 0x74, 0x72, 0x79, 0x41, 0x66, 0x74, 0x65, 0x72, 0x53, 0x65, 0x63, 0x6f,
+	0x6e, 0x64, 0x73, 0x22, 0xdd, 0x02, 0x0a, 0x13, 0x53, 0x75, 0x62, 0x6d, 0x69, 0x74, 0x52, 0x65,
+	0x73, 0x75, 0x6c, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x74,
+	0x61, 0x73, 0x6b, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x74, 0x61,
+	0x73, 0x6b, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x2d,
+	0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x15, 0x2e,
+	0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x54, 0x61, 0x73, 0x6b, 0x45,
+	0x72, 0x72, 0x6f, 0x72, 0x48, 0x00, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x39, 0x0a,
+	0x0d, 0x74, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x5f, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f,
+	0x72, 0x2e, 0x54, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x48, 0x00, 0x52, 0x0c, 0x74, 0x65, 0x6e, 0x73,
+	0x6f, 0x72, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x3c, 0x0a, 0x0e, 0x63, 0x6f, 0x6d, 0x70,
+	0x6c, 0x65, 0x78, 0x5f, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x13, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x43, 0x6f,
+	0x6d, 0x70, 0x6c, 0x65, 0x78, 0x48, 0x00, 0x52, 0x0d, 0x63, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78,
+	0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x3f, 0x0a, 0x0f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76,
+	0x61, 0x6c, 0x5f, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x14, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x49, 0x6e, 0x74,
+	0x65, 0x72, 0x76, 0x61, 0x6c, 0x48, 0x00, 0x52, 0x0e, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61,
+	0x6c, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x61, 0x67, 0x65, 0x6e, 0x74,
+	0x5f, 0x69, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x67, 0x65, 0x6e, 0x74,
+	0x49, 0x64, 0x42, 0x0f, 0x0a, 0x0d, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x5f, 0x73, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x22, 0x43, 0x0a, 0x09, 0x54, 0x61, 0x73, 0x6b, 0x45, 0x72, 0x72, 0x6f, 0x72,
+	0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x70, 0x65,
+	0x72, 0x6d, 0x61, 0x6e, 0x65, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x70,
+	0x65, 0x72, 0x6d, 0x61, 0x6e, 0x65, 0x6e, 0x74, 0x22, 0x3a, 0x0a, 0x14, 0x53, 0x75, 0x62, 0x6d,
+	0x69, 0x74, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x22, 0x0a, 0x0c, 0x61, 0x63, 0x6b, 0x6e, 0x6f, 0x77, 0x6c, 0x65, 0x64, 0x67, 0x65, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x61, 0x63, 0x6b, 0x6e, 0x6f, 0x77, 0x6c, 0x65,
+	0x64, 0x67, 0x65, 0x64, 0x22, 0x32, 0x0a, 0x06, 0x54, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x12, 0x14,
+	0x0a, 0x05, 0x73, 0x68, 0x61, 0x70, 0x65, 0x18, 0x01, 0x20, 0x03, 0x28, 0x05, 0x52, 0x05, 0x73,
+	0x68, 0x61, 0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x03,
+	0x28, 0x01, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x29, 0x0a, 0x07, 0x43, 0x6f, 0x6d, 0x70,
+	0x6c, 0x65, 0x78, 0x12, 0x0e, 0x0a, 0x02, 0x72, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x02, 0x72, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x02, 0x69, 0x6d, 0x22, 0x2a, 0x0a, 0x08, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x12,
+	0x0e, 0x0a, 0x02, 0x6c, 0x6f, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x02, 0x6c, 0x6f, 0x12,
+	0x0e, 0x0a, 0x02, 0x68, 0x69, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x02, 0x68, 0x69, 0x32,
+	0xaf, 0x01, 0x0a, 0x16, 0x43, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x41, 0x67,
+	0x65, 0x6e, 0x74, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x42, 0x0a, 0x07, 0x47, 0x65,
+	0x74, 0x54, 0x61, 0x73, 0x6b, 0x12, 0x1a, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74,
+	0x6f, 0x72, 0x2e, 0x47, 0x65, 0x74, 0x54, 0x61, 0x73, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x1b, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x47,
+	0x65, 0x74, 0x54, 0x61, 0x73, 0x6b, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x51,
+	0x0a, 0x0c, 0x53, 0x75, 0x62, 0x6d, 0x69, 0x74, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x1f,
+	0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x53, 0x75, 0x62, 0x6d,
+	0x69, 0x74, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x20, 0x2e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2e, 0x53, 0x75, 0x62,
+	0x6d, 0x69, 0x74, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x42, 0x20, 0x5a, 0x1e, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61, 0x74, 0x6f, 0x72, 0x2f,
+	0x70, 0x6b, 0x67, 0x2f, 0x67, 0x72, 0x70, 0x63, 0x2f, 0x63, 0x61, 0x6c, 0x63, 0x75, 0x6c, 0x61,
+	0x74, 0x6f, 0x72, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+})
 
 var (
 	file_calculator_proto_rawDescOnce sync.Once
@@ -477,7 +855,7 @@ func file_calculator_proto_rawDescGZIP() []byte {
 	return file_calculator_proto_rawDescData
 }
 
-var file_calculator_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_calculator_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_calculator_proto_goTypes = []any{
 	(*GetTaskRequest)(nil),       // 0: calculator.GetTaskRequest
 	(*GetTaskResponse)(nil),      // 1: calculator.GetTaskResponse
@@ -486,20 +864,32 @@ var file_calculator_proto_goTypes = []any{
 	(*SubmitResultRequest)(nil),  // 4: calculator.SubmitResultRequest
 	(*TaskError)(nil),            // 5: calculator.TaskError
 	(*SubmitResultResponse)(nil), // 6: calculator.SubmitResultResponse
+	(*Tensor)(nil),               // 7: calculator.Tensor
+	(*Complex)(nil),              // 8: calculator.Complex
+	(*Interval)(nil),             // 9: calculator.Interval
 }
 var file_calculator_proto_depIdxs = []int32{
-	2, // 0: calculator.GetTaskResponse.task:type_name -> calculator.Task
-	3, // 1: calculator.GetTaskResponse.no_task:type_name -> calculator.NoTaskAvailable
-	5, // 2: calculator.SubmitResultRequest.error:type_name -> calculator.TaskError
-	0, // 3: calculator.CalculatorAgentService.GetTask:input_type -> calculator.GetTaskRequest
-	4, // 4: calculator.CalculatorAgentService.SubmitResult:input_type -> calculator.SubmitResultRequest
-	1, // 5: calculator.CalculatorAgentService.GetTask:output_type -> calculator.GetTaskResponse
-	6, // 6: calculator.CalculatorAgentService.SubmitResult:output_type -> calculator.SubmitResultResponse
-	5, // [5:7] is the sub-list for method output_type
-	3, // [3:5] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	2,  // 0: calculator.GetTaskResponse.task:type_name -> calculator.Task
+	3,  // 1: calculator.GetTaskResponse.no_task:type_name -> calculator.NoTaskAvailable
+	7,  // 2: calculator.Task.arg1_tensor:type_name -> calculator.Tensor
+	7,  // 3: calculator.Task.arg2_tensor:type_name -> calculator.Tensor
+	8,  // 4: calculator.Task.arg1_complex:type_name -> calculator.Complex
+	8,  // 5: calculator.Task.arg2_complex:type_name -> calculator.Complex
+	9,  // 6: calculator.Task.arg1_interval:type_name -> calculator.Interval
+	9,  // 7: calculator.Task.arg2_interval:type_name -> calculator.Interval
+	5,  // 8: calculator.SubmitResultRequest.error:type_name -> calculator.TaskError
+	7,  // 9: calculator.SubmitResultRequest.tensor_result:type_name -> calculator.Tensor
+	8,  // 10: calculator.SubmitResultRequest.complex_result:type_name -> calculator.Complex
+	9,  // 11: calculator.SubmitResultRequest.interval_result:type_name -> calculator.Interval
+	0,  // 12: calculator.CalculatorAgentService.GetTask:input_type -> calculator.GetTaskRequest
+	4,  // 13: calculator.CalculatorAgentService.SubmitResult:input_type -> calculator.SubmitResultRequest
+	1,  // 14: calculator.CalculatorAgentService.GetTask:output_type -> calculator.GetTaskResponse
+	6,  // 15: calculator.CalculatorAgentService.SubmitResult:output_type -> calculator.SubmitResultResponse
+	14, // [14:16] is the sub-list for method output_type
+	12, // [12:14] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
 }
 
 func init() { file_calculator_proto_init() }
@@ -514,6 +904,9 @@ func file_calculator_proto_init() {
 	file_calculator_proto_msgTypes[4].OneofWrappers = []any{
 		(*SubmitResultRequest_Result)(nil),
 		(*SubmitResultRequest_Error)(nil),
+		(*SubmitResultRequest_TensorResult)(nil),
+		(*SubmitResultRequest_ComplexResult)(nil),
+		(*SubmitResultRequest_IntervalResult)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -521,7 +914,7 @@ func file_calculator_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_calculator_proto_rawDesc), len(file_calculator_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   7,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -0,0 +1,225 @@
+package interval
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Interval - отрезок [Lo, Hi], гарантированно содержащий точное значение величины.
+// Число x - вырожденный интервал [x, x].
+type Interval struct {
+	Lo float64
+	Hi float64
+}
+
+func New(lo, hi float64) (Interval, error) {
+	if math.IsNaN(lo) || math.IsNaN(hi) {
+		return Interval{}, fmt.Errorf("граница интервала не является числом")
+	}
+	if lo > hi {
+		return Interval{}, fmt.Errorf("нижняя граница интервала больше верхней: [%v, %v]", lo, hi)
+	}
+	return Interval{lo, hi}, nil
+}
+
+func Point(x float64) Interval {
+	return Interval{x, x}
+}
+
+// Decimal возвращает наименьший интервал, содержащий десятичное число, кратчайшая запись
+// которого - x. Так 0.1 из выражения, не представимое в float64 точно, становится интервалом
+// шириной в один ulp, а точно представимые числа остаются вырожденными интервалами.
+func Decimal(x float64) Interval {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return Point(x)
+	}
+	exact, ok := new(big.Rat).SetString(strconv.FormatFloat(x, 'g', -1, 64))
+	if !ok {
+		return Point(x)
+	}
+	switch new(big.Rat).SetFloat64(x).Cmp(exact) {
+	case -1:
+		return Interval{x, up(x, 1)}
+	case 1:
+		return Interval{down(x, 1), x}
+	}
+	return Point(x)
+}
+
+// Mid возвращает середину интервала; сложение половин не переполняется на больших границах.
+func (a Interval) Mid() float64 {
+	return a.Lo/2 + a.Hi/2
+}
+
+func (a Interval) Contains(x float64) bool {
+	return a.Lo <= x && x <= a.Hi
+}
+
+func (a Interval) IsPoint() bool {
+	return a.Lo == a.Hi
+}
+
+// String выводит интервал так же, как он записывается в выражении: [9.8,9.82].
+func (a Interval) String() string {
+	return "[" + strconv.FormatFloat(a.Lo, 'g', -1, 64) + "," + strconv.FormatFloat(a.Hi, 'g', -1, 64) + "]"
+}
+
+// Режима округления в Go нет, поэтому границы расширяются наружу на несколько ulp после
+// вычисления с округлением к ближайшему. Арифметика и sqrt округляются точно (ошибка не больше
+// половины ulp), у библиотечных функций (exp, ln, sin, pow) ошибка больше, отсюда запас.
+const (
+	arithmeticULPs = 1
+	libraryULPs    = 4
+)
+
+func down(x float64, ulps int) float64 {
+	for i := 0; i < ulps && !math.IsInf(x, 0); i++ {
+		x = math.Nextafter(x, math.Inf(-1))
+	}
+	return x
+}
+
+func up(x float64, ulps int) float64 {
+	for i := 0; i < ulps && !math.IsInf(x, 0); i++ {
+		x = math.Nextafter(x, math.Inf(1))
+	}
+	return x
+}
+
+func outward(lo, hi float64, ulps int) Interval {
+	return Interval{down(lo, ulps), up(hi, ulps)}
+}
+
+// span возвращает наименьший интервал, содержащий все значения, расширенный наружу на ulps.
+func span(ulps int, values ...float64) Interval {
+	lo, hi := values[0], values[0]
+	for _, v := range values[1:] {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	return outward(lo, hi, ulps)
+}
+
+// powULPs - запас для math.Pow: целая часть показателя возводится повторным умножением,
+// и ошибка растет с логарифмом показателя.
+func powULPs(y float64) int {
+	return libraryULPs + int(math.Log2(math.Abs(y)+1)) + 1
+}
+
+// Apply выполняет операцию над интервалами. У функций одного аргумента b не используется.
+// Результат содержит значения операции во всех точках аргументов; если операция определена
+// не во всех точках (деление на интервал с нулем, логарифм отрицательных), возвращается ошибка.
+func Apply(op string, a, b Interval) (Interval, error) {
+	switch op {
+	case "+":
+		return outward(a.Lo+b.Lo, a.Hi+b.Hi, arithmeticULPs), nil
+	case "-":
+		return outward(a.Lo-b.Hi, a.Hi-b.Lo, arithmeticULPs), nil
+	case "*":
+		return span(arithmeticULPs, a.Lo*b.Lo, a.Lo*b.Hi, a.Hi*b.Lo, a.Hi*b.Hi), nil
+	case "/":
+		if b.Contains(0) {
+			return Interval{}, fmt.Errorf("деление на интервал, содержащий ноль: %s", b)
+		}
+		return span(arithmeticULPs, a.Lo/b.Lo, a.Lo/b.Hi, a.Hi/b.Lo, a.Hi/b.Hi), nil
+	case "^":
+		return pow(a, b)
+	// Функции одного аргумента.
+	case "sin":
+		return periodic(math.Sin, a, math.Pi/2, -math.Pi/2), nil
+	case "cos":
+		return periodic(math.Cos, a, 0, math.Pi), nil
+	case "tan":
+		// Полюс pi/2 + k*pi внутри интервала - тангенс не ограничен.
+		if a.Hi-a.Lo >= math.Pi || containsPeriodic(a, math.Pi/2, math.Pi) {
+			return Interval{}, fmt.Errorf("тангенс интервала, содержащего полюс: %s", a)
+		}
+		return outward(math.Tan(a.Lo), math.Tan(a.Hi), libraryULPs), nil
+	case "exp":
+		r := outward(math.Exp(a.Lo), math.Exp(a.Hi), libraryULPs)
+		r.Lo = math.Max(r.Lo, 0)
+		return r, nil
+	case "ln":
+		if a.Lo <= 0 {
+			return Interval{}, fmt.Errorf("логарифм интервала с неположительными значениями: %s", a)
+		}
+		return outward(math.Log(a.Lo), math.Log(a.Hi), libraryULPs), nil
+	case "sqrt":
+		if a.Lo < 0 {
+			return Interval{}, fmt.Errorf("корень из интервала с отрицательными значениями: %s", a)
+		}
+		r := outward(math.Sqrt(a.Lo), math.Sqrt(a.Hi), arithmeticULPs)
+		r.Lo = math.Max(r.Lo, 0)
+		return r, nil
+	case "abs": // Точная операция: границы только меняют знак
+		switch {
+		case a.Lo >= 0:
+			return a, nil
+		case a.Hi <= 0:
+			return Interval{-a.Hi, -a.Lo}, nil
+		}
+		return Interval{0, math.Max(-a.Lo, a.Hi)}, nil
+	case "median":
+		return a, nil
+	default:
+		return Interval{}, fmt.Errorf("операция '%s' не поддерживается для интервалов", op)
+	}
+}
+
+// pow возводит интервал в степень. Целая постоянная степень допускает отрицательное основание,
+// остальные - только неотрицательное: там x^y монотонна по каждому аргументу и крайние значения
+// достигаются в углах.
+func pow(a, b Interval) (Interval, error) {
+	if b.IsPoint() && b.Lo == math.Trunc(b.Lo) && !math.IsInf(b.Lo, 0) {
+		n := b.Lo
+		if n == 0 {
+			return Point(1), nil
+		}
+		if n < 0 && a.Contains(0) {
+			return Interval{}, fmt.Errorf("возведение интервала, содержащего ноль, в отрицательную степень: %s", a)
+		}
+		r := span(powULPs(n), math.Pow(a.Lo, n), math.Pow(a.Hi, n))
+		if math.Mod(n, 2) == 0 { // Четная степень неотрицательна и в нуле равна нулю
+			if a.Contains(0) {
+				r.Lo = 0
+			}
+			r.Lo = math.Max(r.Lo, 0)
+		}
+		return r, nil
+	}
+	if a.Lo < 0 {
+		return Interval{}, fmt.Errorf("дробная степень интервала с отрицательными значениями: %s", a)
+	}
+	if a.Lo == 0 && b.Lo < 0 {
+		return Interval{}, fmt.Errorf("возведение нуля в отрицательную степень")
+	}
+	r := span(powULPs(math.Max(math.Abs(b.Lo), math.Abs(b.Hi))),
+		math.Pow(a.Lo, b.Lo), math.Pow(a.Lo, b.Hi),
+		math.Pow(a.Hi, b.Lo), math.Pow(a.Hi, b.Hi))
+	r.Lo = math.Max(r.Lo, 0)
+	return r, nil
+}
+
+// periodic вычисляет образ интервала для sin или cos: значения на концах, а если внутри есть
+// точка максимума maxAt + 2k*pi или минимума minAt + 2k*pi - соответственно 1 и -1.
+func periodic(f func(float64) float64, a Interval, maxAt, minAt float64) Interval {
+	if a.Hi-a.Lo >= 2*math.Pi {
+		return Interval{-1, 1}
+	}
+	r := span(libraryULPs, f(a.Lo), f(a.Hi))
+	if containsPeriodic(a, maxAt, 2*math.Pi) {
+		r.Hi = 1
+	}
+	if containsPeriodic(a, minAt, 2*math.Pi) {
+		r.Lo = -1
+	}
+	r.Lo, r.Hi = math.Max(r.Lo, -1), math.Min(r.Hi, 1)
+	return r
+}
+
+// containsPeriodic сообщает, попадает ли в интервал точка x0 + k*period для какого-нибудь целого k.
+func containsPeriodic(a Interval, x0, period float64) bool {
+	k := math.Ceil((a.Lo - x0) / period)
+	return x0+k*period <= a.Hi
+}
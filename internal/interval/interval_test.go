@@ -0,0 +1,113 @@
+package interval
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// exact вычисляет операцию над границами без округления.
+func exact(op string, x, y float64) *big.Float {
+	a := new(big.Float).SetPrec(2048).SetFloat64(x)
+	b := new(big.Float).SetPrec(2048).SetFloat64(y)
+	switch op {
+	case "+":
+		return a.Add(a, b)
+	case "-":
+		return a.Sub(a, b)
+	}
+	return a.Mul(a, b)
+}
+
+func TestApplyEnclosesExactResult(t *testing.T) {
+	values := []Interval{{0.1, 0.2}, {-3.3, 1.7}, {1e-300, 1e-290}, {-1e300, -7}, {2, 2}, {0, 0.3}}
+	for _, op := range []string{"+", "-", "*"} {
+		for _, a := range values {
+			for _, b := range values {
+				r, err := Apply(op, a, b)
+				if err != nil {
+					t.Fatalf("%v %s %v: %v", a, op, b, err)
+				}
+				lo, hi := new(big.Float).SetFloat64(r.Lo), new(big.Float).SetFloat64(r.Hi)
+				for _, x := range []float64{a.Lo, a.Hi} {
+					for _, y := range []float64{b.Lo, b.Hi} {
+						if v := exact(op, x, y); v.Cmp(lo) < 0 || v.Cmp(hi) > 0 {
+							t.Errorf("%v %s %v = %v does not contain %v %s %v", a, op, b, r, x, op, y)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		op      string
+		a, b    Interval
+		lo, hi  float64 // Ожидаемые границы с точностью до расширения наружу
+		wantErr bool
+	}{
+		{op: "*", a: Interval{9.8, 9.82}, b: Interval{2, 2.1}, lo: 19.6, hi: 20.622},
+		{op: "/", a: Interval{1, 2}, b: Interval{4, 8}, lo: 0.125, hi: 0.5},
+		{op: "/", a: Interval{1, 2}, b: Interval{-1, 1}, wantErr: true},
+		{op: "^", a: Interval{-2, 3}, b: Point(2), lo: 0, hi: 9},
+		{op: "^", a: Interval{-2, -1}, b: Point(3), lo: -8, hi: -1},
+		{op: "^", a: Interval{-2, 2}, b: Point(-1), wantErr: true},
+		{op: "^", a: Interval{4, 9}, b: Interval{0.5, 0.5}, lo: 2, hi: 3},
+		{op: "^", a: Interval{-4, 9}, b: Point(0.5), wantErr: true},
+		{op: "sin", a: Interval{0, math.Pi}, lo: 0, hi: 1},
+		{op: "sin", a: Interval{-10, 10}, lo: -1, hi: 1},
+		{op: "cos", a: Interval{-1, 1}, lo: math.Cos(1), hi: 1},
+		{op: "tan", a: Interval{1, 2}, wantErr: true},
+		{op: "tan", a: Interval{-1, 1}, lo: math.Tan(-1), hi: math.Tan(1)},
+		{op: "exp", a: Interval{0, 1}, lo: 1, hi: math.E},
+		{op: "ln", a: Interval{0, 1}, wantErr: true},
+		{op: "sqrt", a: Interval{0, 4}, lo: 0, hi: 2},
+		{op: "sqrt", a: Interval{-1, 4}, wantErr: true},
+		{op: "abs", a: Interval{-3, 2}, lo: 0, hi: 3},
+		{op: "dot", a: Interval{1, 2}, b: Interval{1, 2}, wantErr: true},
+	}
+	for _, tc := range tests {
+		r, err := Apply(tc.op, tc.a, tc.b)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s(%v, %v): expected error, got %v", tc.op, tc.a, tc.b, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s(%v, %v): %v", tc.op, tc.a, tc.b, err)
+			continue
+		}
+		if r.Lo > tc.lo || r.Hi < tc.hi || tc.lo-r.Lo > 1e-12 || r.Hi-tc.hi > 1e-12 {
+			t.Errorf("%s(%v, %v) = %v, want about [%v, %v]", tc.op, tc.a, tc.b, r, tc.lo, tc.hi)
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	if d := Decimal(0.5); !d.IsPoint() {
+		t.Errorf("Decimal(0.5) = %v, want a point", d)
+	}
+	tenth := new(big.Rat).SetFrac64(1, 10)
+	d := Decimal(0.1)
+	if d.IsPoint() || new(big.Rat).SetFloat64(d.Lo).Cmp(tenth) > 0 || new(big.Rat).SetFloat64(d.Hi).Cmp(tenth) < 0 {
+		t.Errorf("Decimal(0.1) = %v does not contain 1/10", d)
+	}
+	if math.Nextafter(d.Lo, 1) != d.Hi {
+		t.Errorf("Decimal(0.1) = %v is wider than one ulp", d)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(2, 1); err == nil {
+		t.Error("expected error for lo > hi")
+	}
+	if _, err := New(math.NaN(), 1); err == nil {
+		t.Error("expected error for NaN bound")
+	}
+	if i, err := New(9.8, 9.82); err != nil || i.String() != "[9.8,9.82]" {
+		t.Errorf("New(9.8, 9.82) = %v, %v", i, err)
+	}
+}
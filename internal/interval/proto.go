@@ -0,0 +1,17 @@
+package interval
+
+import (
+	pb "calculator/internal/grpc/calculator"
+)
+
+// FromProto преобразует интервал из gRPC-сообщения; ok == false, если аргумент не интервал.
+func FromProto(i *pb.Interval) (a Interval, ok bool) {
+	if i == nil {
+		return Interval{}, false
+	}
+	return Interval{i.Lo, i.Hi}, true
+}
+
+func (a Interval) ToProto() *pb.Interval {
+	return &pb.Interval{Lo: a.Lo, Hi: a.Hi}
+}
@@ -0,0 +1,158 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxPriority - потолок приоритета выражений для роли: пользователь может поднять свои выражения
+// над фоновыми, но не выше срочных выражений администратора. Больший запрошенный приоритет
+// снижается до потолка.
+var maxPriority = map[string]int{
+	database.RoleUser:  5,
+	database.RoleAdmin: 10,
+}
+
+// maxUserWeight ограничивает вес пользователя в очереди задач.
+const maxUserWeight = 100
+
+func readListEnv(key string) map[string]bool {
+	values := make(map[string]bool)
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values[v] = true
+		}
+	}
+	return values
+}
+
+// UserRole возвращает роль пользователя. Логины из переменной окружения ADMIN_LOGINS
+// (через запятую) всегда администраторы - так назначается первый администратор.
+func (s *AuthService) UserRole(userID int64) (string, error) {
+	user, err := s.dbStore.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return database.RoleUser, nil
+	}
+	if user.Role == database.RoleAdmin || s.adminLogins[user.Login] {
+		return database.RoleAdmin, nil
+	}
+	return database.RoleUser, nil
+}
+
+// capPriority проверяет приоритет, запрошенный клиентом, и ограничивает его потолком роли.
+func capPriority(role string, priority int) (int, error) {
+	if priority < 0 {
+		return 0, fmt.Errorf("приоритет не может быть отрицательным: %d", priority)
+	}
+	return min(priority, maxPriority[role]), nil
+}
+
+// requireAdmin проверяет, что запрос отправлен администратором, и иначе сам отвечает ошибкой.
+func (h *HTTPHandlers) requireAdmin(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, ok := GetUserIDFromContext(r.Context())
+	if !ok {
+		log.Println("Ошибка: не удалось получить userID из контекста в requireAdmin")
+		http.Error(w, "Внутренняя ошибка сервера (контекст пользователя)", http.StatusInternalServerError)
+		return 0, false
+	}
+	role, err := h.auth.UserRole(userID)
+	if err != nil {
+		log.Printf("Ошибка получения роли пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return 0, false
+	}
+	if role != database.RoleAdmin {
+		http.Error(w, "Доступно только администраторам", http.StatusForbidden)
+		return 0, false
+	}
+	return userID, true
+}
+
+type UserSchedulingRequest struct {
+	Role   *string `json:"role,omitempty"`   // database.RoleUser или database.RoleAdmin
+	Weight *int    `json:"weight,omitempty"` // Доля пользователя в очереди задач, от 1 до maxUserWeight
+}
+
+// AdminUsersHandler: GET /api/v1/admin/users - пользователи с ролями и весами,
+// PUT /api/v1/admin/users/{id} - изменить роль и вес пользователя.
+func (h *HTTPHandlers) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/users"), "/")
+	switch {
+	case r.Method == http.MethodGet && idStr == "":
+		users, err := h.db.GetUsers()
+		if err != nil {
+			log.Printf("Ошибка получения списка пользователей: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера при получении пользователей", http.StatusInternalServerError)
+			return
+		}
+		if users == nil {
+			users = []database.User{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"users": users})
+	case r.Method == http.MethodPut && idStr != "":
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Некорректный ID пользователя", http.StatusBadRequest)
+			return
+		}
+		h.putUserScheduling(w, r, adminID, id)
+	default:
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *HTTPHandlers) putUserScheduling(w http.ResponseWriter, r *http.Request, adminID, id int64) {
+	var req UserSchedulingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.db.GetUserByID(id)
+	if err != nil {
+		log.Printf("Ошибка получения пользователя %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, fmt.Sprintf("Пользователь ID %d не найден", id), http.StatusNotFound)
+		return
+	}
+	if req.Role != nil {
+		if *req.Role != database.RoleUser && *req.Role != database.RoleAdmin {
+			http.Error(w, fmt.Sprintf("Неизвестная роль '%s'", *req.Role), http.StatusBadRequest)
+			return
+		}
+		user.Role = *req.Role
+	}
+	if req.Weight != nil {
+		if *req.Weight < 1 || *req.Weight > maxUserWeight {
+			http.Error(w, fmt.Sprintf("Вес должен быть от 1 до %d", maxUserWeight), http.StatusBadRequest)
+			return
+		}
+		user.Weight = *req.Weight
+	}
+
+	if _, err := h.db.UpdateUserScheduling(id, user.Role, user.Weight); err != nil {
+		log.Printf("Ошибка обновления пользователя %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера при обновлении пользователя", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Администратор %d изменил пользователя %d: роль %s, вес %d", adminID, id, user.Role, user.Weight)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
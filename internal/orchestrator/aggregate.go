@@ -0,0 +1,53 @@
+package orchestrator
+
+// aggregateFunctions - функции произвольного числа аргументов. Парсер сразу раскрывает их
+// в дерево обычных операций, поэтому планировщик и агенты работают с ними как с любым выражением.
+var aggregateFunctions = map[string]bool{
+	"sum":    true,
+	"avg":    true,
+	"median": true,
+	"stddev": true,
+}
+
+// reduceTree объединяет аргументы сбалансированным деревом операции op:
+// sum(a, b, c, d) = (a+b)+(c+d). Частичные суммы не зависят друг от друга и
+// вычисляются агентами параллельно, глубина дерева - log2(n) вместо n-1 у цепочки.
+func reduceTree(op string, args []*Node) *Node {
+	if len(args) == 1 {
+		return args[0]
+	}
+	mid := len(args) / 2
+	return binary(op, reduceTree(op, args[:mid]), reduceTree(op, args[mid:]))
+}
+
+// expandAggregate строит дерево вычисления агрегатной функции.
+// median собирает аргументы в упорядоченный вектор попарными слияниями (merge)
+// и берет его середину; stddev - стандартное отклонение генеральной совокупности.
+func expandAggregate(name string, args []*Node) *Node {
+	n := num(float64(len(args)))
+	switch name {
+	case "sum":
+		return reduceTree("+", args)
+	case "avg":
+		return binary("/", reduceTree("+", args), n)
+	case "median":
+		if len(args) == 1 {
+			return call("median", args[0])
+		}
+		return call("median", reduceTree("merge", args))
+	case "stddev":
+		// Каждый аргумент участвует и в среднем, и в отклонении, поэтому поддеревья копируются:
+		// одинаковые копии все равно станут одной задачей при планировании.
+		copies := make([]*Node, len(args))
+		for i, arg := range args {
+			copies[i] = Substitute(arg, nil)
+		}
+		mean := binary("/", reduceTree("+", copies), num(float64(len(args))))
+		squares := make([]*Node, len(args))
+		for i, arg := range args {
+			squares[i] = binary("^", binary("-", arg, Substitute(mean, nil)), num(2))
+		}
+		return call("sqrt", binary("/", reduceTree("+", squares), n))
+	}
+	return nil
+}
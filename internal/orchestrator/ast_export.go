@@ -0,0 +1,333 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"calculator/internal/interval"
+	"calculator/internal/tensor"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ASTNode - представление узла дерева для JSON-экспорта.
+type ASTNode struct {
+	Type     string             `json:"type"` // number, complex, interval, tensor, variable, function или operation
+	Value    *float64           `json:"value,omitempty"`
+	Complex  *database.Complex  `json:"complex,omitempty"`
+	Interval *database.Interval `json:"interval,omitempty"`
+	Tensor   *tensor.Tensor     `json:"tensor,omitempty"` // Вектор или матрица вложенными списками
+	Name     string             `json:"name,omitempty"`   // Имя переменной
+	Unit     string             `json:"unit,omitempty"`   // Единица числа или целевая единица перевода (op "in")
+	Op       string             `json:"op,omitempty"`
+	Left     *ASTNode           `json:"left,omitempty"`
+	Right    *ASTNode           `json:"right,omitempty"`
+	TaskID   int64              `json:"task_id,omitempty"`
+	Status   string             `json:"status,omitempty"`
+	// Оставшийся критический путь задачи узла в мс, по нему выбирается порядок выдачи задач
+	CriticalPath int64 `json:"critical_path,omitempty"`
+}
+
+// tasksByNodeKey сопоставляет узлы дерева с задачами выражения по ключу поддерева.
+func tasksByNodeKey(tasks []database.Task) map[string]database.Task {
+	m := make(map[string]database.Task, len(tasks))
+	for _, t := range tasks {
+		m[t.NodeKey] = t
+	}
+	return m
+}
+
+// ExportJSON строит JSON-представление дерева. tasks может быть nil.
+func ExportJSON(n *Node, tasks map[string]database.Task) *ASTNode {
+	return exportJSON(n, func(n *Node) (database.Task, bool) {
+		t, ok := tasks[n.String()]
+		return t, ok
+	})
+}
+
+// exportJSON строит JSON-представление дерева, находя задачу узла через taskOf.
+func exportJSON(n *Node, taskOf func(*Node) (database.Task, bool)) *ASTNode {
+	if n == nil {
+		return nil
+	}
+	if n.Value != nil {
+		v := *n.Value
+		return &ASTNode{Type: "number", Value: &v, Unit: n.Unit}
+	}
+	if n.Tensor != nil {
+		return &ASTNode{Type: "tensor", Tensor: n.Tensor}
+	}
+	if n.Complex != nil {
+		return &ASTNode{Type: "complex", Complex: &database.Complex{Re: real(*n.Complex), Im: imag(*n.Complex)}}
+	}
+	if n.Interval != nil {
+		return &ASTNode{Type: "interval", Interval: &database.Interval{Lo: n.Interval.Lo, Hi: n.Interval.Hi, Mid: n.Interval.Mid()}}
+	}
+	if n.Var != "" {
+		return &ASTNode{Type: "variable", Name: n.Var}
+	}
+	out := &ASTNode{
+		Type:  "operation",
+		Op:    n.Op,
+		Left:  exportJSON(n.Left, taskOf),
+		Right: exportJSON(n.Right, taskOf),
+		Unit:  n.Unit,
+	}
+	if n.IsFunction() || binaryFunctions[n.Op] {
+		out.Type = "function"
+	}
+	if t, ok := taskOf(n); ok {
+		out.TaskID = t.ID
+		out.Status = t.Status
+		out.CriticalPath = t.CriticalPath
+	}
+	return out
+}
+
+// importJSON восстанавливает дерево, сохраненное при планировании, и сопоставляет его узлы
+// с текущим состоянием задач по task_id.
+func importJSON(a *ASTNode, tasks map[int64]database.Task) (*Node, map[string]database.Task) {
+	byKey := make(map[string]database.Task)
+	var walk func(a *ASTNode) *Node
+	walk = func(a *ASTNode) *Node {
+		if a == nil {
+			return nil
+		}
+		n := &Node{Unit: a.Unit}
+		switch a.Type {
+		case "number":
+			n.Value = a.Value
+		case "tensor":
+			n.Tensor = a.Tensor
+		case "complex":
+			z := complex(a.Complex.Re, a.Complex.Im)
+			n.Complex = &z
+		case "interval":
+			n.Interval = &interval.Interval{Lo: a.Interval.Lo, Hi: a.Interval.Hi}
+		case "variable":
+			n.Var = a.Name
+		default:
+			n.Op, n.Left, n.Right = a.Op, walk(a.Left), walk(a.Right)
+			if t, ok := tasks[a.TaskID]; ok {
+				byKey[n.String()] = t
+			}
+		}
+		return n
+	}
+	return walk(a), byKey
+}
+
+// ExportDOT строит граф Graphviz. Одинаковые поддеревья выводятся одним узлом,
+// как и общие задачи в DAG планировщика.
+func ExportDOT(n *Node, tasks map[string]database.Task) string {
+	var b strings.Builder
+	b.WriteString("digraph AST {\n")
+	b.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+
+	ids := make(map[string]string)
+	counter := 0
+	var walk func(n *Node) string
+	walk = func(n *Node) string {
+		if n.Value != nil {
+			id := fmt.Sprintf("n%d", counter)
+			counter++
+			fmt.Fprintf(&b, "  %s [label=%q, shape=ellipse];\n", id, strings.TrimSpace(formatNumber(*n.Value)+" "+n.Unit))
+			return id
+		}
+		if n.Tensor != nil {
+			id := fmt.Sprintf("n%d", counter)
+			counter++
+			fmt.Fprintf(&b, "  %s [label=%q, shape=ellipse];\n", id, n.Tensor.String())
+			return id
+		}
+		if n.Complex != nil {
+			id := fmt.Sprintf("n%d", counter)
+			counter++
+			fmt.Fprintf(&b, "  %s [label=%q, shape=ellipse];\n", id, formatComplex(*n.Complex, DefaultPrintOptions))
+			return id
+		}
+		if n.Interval != nil {
+			id := fmt.Sprintf("n%d", counter)
+			counter++
+			fmt.Fprintf(&b, "  %s [label=%q, shape=ellipse];\n", id, n.Interval.String())
+			return id
+		}
+		if n.Var != "" {
+			id := fmt.Sprintf("n%d", counter)
+			counter++
+			fmt.Fprintf(&b, "  %s [label=%q, shape=ellipse, style=dashed];\n", id, n.Var)
+			return id
+		}
+		key := n.String()
+		if id, ok := ids[key]; ok {
+			return id
+		}
+		id := fmt.Sprintf("n%d", counter)
+		counter++
+		ids[key] = id
+
+		label := n.Op
+		if n.Op == "in" {
+			label += " " + n.Unit
+		}
+		if t, ok := tasks[key]; ok {
+			label += fmt.Sprintf("\ntask %d\n%s", t.ID, t.Status)
+			if t.Mode == database.ModeInterval && t.Result.Valid {
+				label += "\n= " + interval.Interval{Lo: t.Result.Float64, Hi: t.ResultHi}.String()
+			} else if t.ResultImag != 0 {
+				label += "\n= " + formatComplex(complex(t.Result.Float64, t.ResultImag), DefaultPrintOptions)
+			} else if t.Result.Valid {
+				label += "\n= " + formatNumber(t.Result.Float64)
+			}
+		}
+		fmt.Fprintf(&b, "  %s [label=%q];\n", id, label)
+		if n.Left != nil {
+			fmt.Fprintf(&b, "  %s -> %s [label=\"1\"];\n", id, walk(n.Left))
+		}
+		if n.Right != nil {
+			fmt.Fprintf(&b, "  %s -> %s [label=\"2\"];\n", id, walk(n.Right))
+		}
+		return id
+	}
+	if n != nil {
+		walk(n)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// opPrecedence возвращает приоритет операции; у чисел приоритет максимальный.
+func opPrecedence(n *Node) int {
+	if n == nil || n.IsLeaf() {
+		return 100
+	}
+	switch n.Op {
+	case "+", "-":
+		return 1
+	case "*", "/":
+		return 2
+	case "^":
+		return 3
+	}
+	return 100
+}
+
+// ExportLaTeX выводит выражение в LaTeX, расставляя скобки только там, где они нужны.
+func ExportLaTeX(n *Node) string {
+	if n == nil {
+		return ""
+	}
+	if n.Value != nil {
+		if n.Unit != "" {
+			return fmt.Sprintf("%s\\,\\mathrm{%s}", latexNumber(*n.Value), n.Unit)
+		}
+		return latexNumber(*n.Value)
+	}
+	if n.Tensor != nil {
+		return latexTensor(n.Tensor)
+	}
+	if n.Complex != nil {
+		s := formatComplex(*n.Complex, DefaultPrintOptions)
+		if real(*n.Complex) != 0 {
+			return latexParens(s)
+		}
+		return s
+	}
+	if n.Interval != nil {
+		return fmt.Sprintf("\\left[%s, %s\\right]", latexNumber(n.Interval.Lo), latexNumber(n.Interval.Hi))
+	}
+	if n.Var != "" {
+		return n.Var
+	}
+
+	switch n.Op {
+	case "^":
+		base := ExportLaTeX(n.Left)
+		if opPrecedence(n.Left) <= opPrecedence(n) || isNegativeNumber(n.Left) || n.Left.IsFunction() {
+			base = latexParens(base)
+		}
+		return fmt.Sprintf("{%s}^{%s}", base, ExportLaTeX(n.Right))
+	case "sqrt":
+		return fmt.Sprintf("\\sqrt{%s}", ExportLaTeX(n.Left))
+	case "abs":
+		return fmt.Sprintf("\\left|%s\\right|", ExportLaTeX(n.Left))
+	case "sin", "cos", "tan", "exp", "ln":
+		return fmt.Sprintf("\\%s%s", n.Op, latexParens(ExportLaTeX(n.Left)))
+	case "dot":
+		return fmt.Sprintf("\\left\\langle %s, %s \\right\\rangle", ExportLaTeX(n.Left), ExportLaTeX(n.Right))
+	case "in":
+		return fmt.Sprintf("%s \\;\\text{in}\\; \\mathrm{%s}", ExportLaTeX(n.Left), n.Unit)
+	case "merge":
+		return fmt.Sprintf("\\operatorname{merge}\\left(%s, %s\\right)", ExportLaTeX(n.Left), ExportLaTeX(n.Right))
+	case "/":
+		return fmt.Sprintf("\\frac{%s}{%s}", ExportLaTeX(n.Left), ExportLaTeX(n.Right))
+	case "+", "-", "*":
+		p := opPrecedence(n)
+		if isNegation(n) {
+			operand := ExportLaTeX(n.Right)
+			if opPrecedence(n.Right) < p || isNegativeNumber(n.Right) || isNegation(n.Right) {
+				operand = latexParens(operand)
+			}
+			return "-" + operand
+		}
+		left := ExportLaTeX(n.Left)
+		if opPrecedence(n.Left) < p {
+			left = latexParens(left)
+		}
+		right := ExportLaTeX(n.Right)
+		// Правый операнд вычитания той же приоритетности тоже требует скобок: a - (b + c).
+		if rp := opPrecedence(n.Right); rp < p || (rp == p && n.Op == "-") || isNegativeNumber(n.Right) || isNegation(n.Right) {
+			right = latexParens(right)
+		}
+		op := " " + n.Op + " "
+		if n.Op == "*" {
+			op = " \\cdot "
+		}
+		return left + op + right
+	}
+	return fmt.Sprintf("\\operatorname{%s}%s", n.Op, latexParens(ExportLaTeX(n.Left)))
+}
+
+// latexTensor выводит вектор столбцом, а матрицу - по строкам.
+func latexTensor(t *tensor.Tensor) string {
+	cols := 1
+	if t.Rank() == 2 {
+		cols = t.Shape[1]
+	}
+	rows := make([]string, 0, len(t.Data)/cols)
+	for i := 0; i < len(t.Data); i += cols {
+		cells := make([]string, cols)
+		for j := range cells {
+			cells[j] = latexNumber(t.Data[i+j])
+		}
+		rows = append(rows, strings.Join(cells, " & "))
+	}
+	return "\\begin{pmatrix} " + strings.Join(rows, " \\\\ ") + " \\end{pmatrix}"
+}
+
+func latexParens(s string) string {
+	return "\\left(" + s + "\\right)"
+}
+
+// isNegation распознает унарный минус перед выражением: парсер записывает -(2+3) как -1 * (2+3).
+func isNegation(n *Node) bool {
+	return n != nil && n.Op == "*" && n.Left != nil && n.Left.Value != nil && *n.Left.Value == -1 && n.Left.Unit == ""
+}
+
+func isNegativeNumber(n *Node) bool {
+	if n != nil && n.Complex != nil {
+		return real(*n.Complex) == 0 && imag(*n.Complex) < 0
+	}
+	return n != nil && n.Value != nil && *n.Value < 0
+}
+
+func latexNumber(v float64) string {
+	s := formatNumber(v)
+	if mantissa, exp, ok := strings.Cut(s, "e"); ok {
+		return fmt.Sprintf("%s \\times 10^{%s}", mantissa, strings.TrimPrefix(exp, "+"))
+	}
+	return s
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
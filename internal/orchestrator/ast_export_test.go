@@ -0,0 +1,51 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"strings"
+	"testing"
+)
+
+func TestExportLaTeX(t *testing.T) {
+	tests := []struct{ input, want string }{
+		{"2+3*4", "2 + 3 \\cdot 4"},
+		{"(2+3)*4", "\\left(2 + 3\\right) \\cdot 4"},
+		{"1-(2-3)", "1 - \\left(2 - 3\\right)"},
+		{"(1-2)-3", "1 - 2 - 3"},
+		{"(1+2)/3", "\\frac{1 + 2}{3}"},
+		{"2*-3", "2 \\cdot \\left(-3\\right)"},
+		{"-(2+3)", "-\\left(2 + 3\\right)"},
+		{"2*-(2+3)", "2 \\cdot \\left(-\\left(2 + 3\\right)\\right)"},
+		{"-x^2", "-{x}^{2}"},
+	}
+	for _, tc := range tests {
+		ast, err := NewParser(tc.input).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", tc.input, err)
+		}
+		if got := ExportLaTeX(ast); got != tc.want {
+			t.Errorf("ExportLaTeX(%q) = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestExportDOTAndJSON(t *testing.T) {
+	ast, _ := NewParser("(1+2)*(1+2)").Parse()
+	tasks := tasksByNodeKey([]database.Task{
+		{ID: 7, NodeKey: "(1+2)", Status: database.StatusDone},
+		{ID: 8, NodeKey: "((1+2)*(1+2))", Status: database.StatusWaiting},
+	})
+
+	dot := ExportDOT(ast, tasks)
+	if strings.Count(dot, "task 7") != 1 {
+		t.Errorf("shared subtree should be rendered once:\n%s", dot)
+	}
+	if strings.Count(dot, "->") != 4 || !strings.Contains(dot, "waiting") {
+		t.Errorf("unexpected DOT output:\n%s", dot)
+	}
+
+	node := ExportJSON(ast, tasks)
+	if node.Type != "operation" || node.Op != "*" || node.TaskID != 8 || node.Left.TaskID != 7 || *node.Left.Left.Value != 1 {
+		t.Errorf("unexpected JSON export: %+v", node)
+	}
+}
@@ -25,8 +25,9 @@ type Claims struct {
 }
 
 type AuthService struct {
-	dbStore   *database.Store
-	jwtSecret string
+	dbStore     *database.Store
+	jwtSecret   string
+	adminLogins map[string]bool // Логины из ADMIN_LOGINS - администраторы независимо от роли в БД
 }
 
 func NewAuthService(db *database.Store, secret string) *AuthService {
@@ -35,8 +36,9 @@ func NewAuthService(db *database.Store, secret string) *AuthService {
 	}
 	jwtKey = []byte(secret)
 	return &AuthService{
-		dbStore:   db,
-		jwtSecret: secret,
+		dbStore:     db,
+		jwtSecret:   secret,
+		adminLogins: readListEnv("ADMIN_LOGINS"),
 	}
 }
 
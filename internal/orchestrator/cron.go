@@ -0,0 +1,137 @@
+package orchestrator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSpec - разобранное расписание cron из пяти полей: минуты, часы, день месяца, месяц и
+// день недели. Время расписаний - UTC.
+type CronSpec struct {
+	minute, hour, dom, month, dow uint64 // Битовые множества допустимых значений
+	domAny, dowAny                bool   // Поле задано как "*"
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron разбирает расписание вида "*/15 9-18 * * 1-5": в каждом поле "*", число, диапазон
+// a-b, шаг */n или a-b/n и списки через запятую. Поддерживаются также @hourly, @daily,
+// @weekly, @monthly и @yearly. День недели - от 0 (воскресенье) до 7 (тоже воскресенье).
+func ParseCron(spec string) (*CronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("расписание должно состоять из 5 полей, получено %d: '%s'", len(fields), spec)
+	}
+
+	c := &CronSpec{}
+	var err error
+	bounds := []struct {
+		name     string
+		dst      *uint64
+		min, max int
+	}{
+		{"минуты", &c.minute, 0, 59},
+		{"часы", &c.hour, 0, 23},
+		{"день месяца", &c.dom, 1, 31},
+		{"месяц", &c.month, 1, 12},
+		{"день недели", &c.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if *b.dst, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return nil, fmt.Errorf("поле '%s': %w", b.name, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // 7 - тоже воскресенье
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("некорректный шаг '%s'", stepStr)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("некорректное значение '%s'", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("некорректное значение '%s'", part)
+				}
+			} else if hasStep {
+				hi = max // "5/15" - с 5 до конца диапазона
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("значение '%s' вне диапазона %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// Next возвращает первый момент расписания строго после after. Нулевое время - расписание
+// не срабатывает никогда (например, 30 февраля).
+func (c *CronSpec) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches проверяет день месяца и день недели. Как в cron, если оба поля ограничены,
+// достаточно совпадения любого из них.
+func (c *CronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,183 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// agentActiveWindow - сколько агент считается активным после последнего запроса задачи.
+// Агент без задач повторяет запрос через несколько секунд, так что окно покрывает несколько опросов.
+const agentActiveWindow = 30 * time.Second
+
+// agentTracker запоминает, когда агенты последний раз запрашивали задачи и что они умеют: по
+// числу активных агентов оценивается время завершения выражений, а по их возможностям -
+// есть ли кому выполнить задачу.
+type agentTracker struct {
+	mu     sync.Mutex
+	agents map[string]trackedAgent
+	since  time.Time // С этого момента без перерыва есть активные агенты
+}
+
+type trackedAgent struct {
+	lastSeen time.Time
+	caps     database.AgentCapabilities
+}
+
+func (t *agentTracker) seen(agentID string, caps database.AgentCapabilities, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.agents == nil {
+		t.agents = make(map[string]trackedAgent)
+	}
+	if t.prune(now); len(t.agents) == 0 {
+		t.since = now
+	}
+	t.agents[agentID] = trackedAgent{lastSeen: now, caps: caps}
+}
+
+// prune забывает агентов, не запрашивавших задачи дольше agentActiveWindow. Вызывается под mu.
+func (t *agentTracker) prune(now time.Time) {
+	for id, a := range t.agents {
+		if now.Sub(a.lastSeen) > agentActiveWindow {
+			delete(t.agents, id)
+		}
+	}
+}
+
+func (t *agentTracker) active(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	return len(t.agents)
+}
+
+// serves сообщает, может ли операцию op выражения с метками labels выполнить хоть один
+// активный агент. known = false, если агенты известны меньше agentActiveWindow: часть из них
+// могла еще не успеть запросить задачу, и отсутствие подходящего агента ничего не значит.
+func (t *agentTracker) serves(op string, labels []string, now time.Time) (ok, known bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	if len(t.agents) == 0 || now.Sub(t.since) < agentActiveWindow {
+		return false, false
+	}
+	for _, a := range t.agents {
+		if a.caps.Serves(op, labels) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// AgentSeen отмечает запрос задачи агентом agentID с возможностями caps.
+func (s *Scheduler) AgentSeen(agentID string, caps database.AgentCapabilities) {
+	s.agents.seen(agentID, caps, time.Now())
+}
+
+// resolveDeadline превращает deadline или timeout_ms запроса в момент, после которого выражение
+// получает статус timeout. Срок хранится с точностью до миллисекунды. nil - срок не задан.
+func resolveDeadline(deadline *time.Time, timeoutMs int64, now time.Time) (*time.Time, error) {
+	switch {
+	case deadline != nil && timeoutMs != 0:
+		return nil, fmt.Errorf("нужно задать либо deadline, либо timeout_ms")
+	case timeoutMs < 0:
+		return nil, fmt.Errorf("timeout_ms не может быть отрицательным: %d", timeoutMs)
+	case timeoutMs > 0:
+		t := now.Add(time.Duration(timeoutMs) * time.Millisecond).UTC().Truncate(time.Millisecond)
+		return &t, nil
+	case deadline != nil && !deadline.After(now):
+		return nil, fmt.Errorf("срок %s уже истек", deadline.Format(time.RFC3339))
+	case deadline != nil:
+		t := deadline.UTC().Truncate(time.Millisecond)
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// EstimateCompletion оценивает, когда завершится выполняющееся выражение: оставшиеся задачи
+// займут не меньше самого длинного оставшегося критического пути и не меньше их суммарного
+// времени, поделенного на число активных агентов. nil - оценки нет (выражение не выполняется
+// или нет агентов).
+func (s *Scheduler) EstimateCompletion(expr *database.Expression, now time.Time) *time.Time {
+	if expr.Status != database.StatusInProgress {
+		return nil
+	}
+	agents := s.agents.active(now)
+	if agents == 0 {
+		return nil
+	}
+	tasks, err := s.dbStore.GetAllTasksForExpression(expr.ID)
+	if err != nil {
+		log.Printf("Scheduler: Ошибка получения задач выражения ID %d для оценки времени: %v", expr.ID, err)
+		return nil
+	}
+	times := s.GetOperationTimes()
+	var work, path int64
+	for _, t := range tasks {
+		switch t.Status {
+		case database.StatusWaiting, database.StatusPending, database.StatusInProgress:
+			work += int64(times.For(t.Operation))
+			path = max(path, t.CriticalPath)
+		}
+	}
+	eta := now.Add(time.Duration(max(path, work/int64(agents))) * time.Millisecond).UTC()
+	return &eta
+}
+
+// ExpireDeadlines переводит в статус timeout выражения, срок которых истек. В шаги выражения
+// дописывается, сколько задач успело выполниться.
+func (s *Scheduler) ExpireDeadlines(now time.Time) {
+	expired, err := s.dbStore.ExpiredExpressions(now)
+	if err != nil {
+		log.Printf("Scheduler: %v", err)
+		return
+	}
+	for _, expr := range expired {
+		if s.timeoutExpression(&expr) {
+			s.expressionFinished(expr.ID)
+		}
+	}
+}
+
+func (s *Scheduler) timeoutExpression(expr *database.Expression) bool {
+	tasks, err := s.dbStore.GetAllTasksForExpression(expr.ID)
+	if err != nil {
+		log.Printf("Scheduler: Ошибка получения задач выражения ID %d: %v", expr.ID, err)
+		return false
+	}
+	done := 0
+	for _, t := range tasks {
+		if t.Status == database.StatusDone {
+			done++
+		}
+	}
+
+	// Блокировка та же, что у recordTaskResult: шаги не перезапишутся параллельно завершенной задачей.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var steps []string
+	if current, err := s.dbStore.GetExpressionByIDInternal(expr.ID); err == nil && current != nil && current.Steps.Valid {
+		json.Unmarshal([]byte(current.Steps.String), &steps)
+	}
+	steps = append(steps, fmt.Sprintf("Timeout: deadline %s exceeded, %d of %d tasks done",
+		expr.Deadline.Format(time.RFC3339Nano), done, len(tasks)))
+	ok, err := s.dbStore.TimeoutExpression(expr.ID, stepsJSON(steps))
+	if err != nil {
+		log.Printf("Scheduler: %v", err)
+		return false
+	}
+	return ok
+}
+
+// WatchDeadlines раз в interval проверяет сроки выражений. Запускается в отдельной горутине.
+func (s *Scheduler) WatchDeadlines(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.ExpireDeadlines(now)
+	}
+}
@@ -0,0 +1,235 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// UserFunction - функция пользователя: тело - выражение от параметров.
+type UserFunction struct {
+	Params []string
+	Body   string
+}
+
+// maxFunctionDepth ограничивает вложенность вызовов функций пользователя друг из друга.
+const maxFunctionDepth = 16
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expandUserFunction разбирает тело функции и подставляет в него аргументы вызова. Повторный
+// вход в функцию, тело которой уже разбирается, - ошибка: без условий рекурсия не завершится.
+func (p *Parser) expandUserFunction(name string, fn UserFunction, args []*Node) (*Node, error) {
+	for _, caller := range p.callStack {
+		if caller == name {
+			return nil, fmt.Errorf("рекурсивный вызов функции '%s': %s -> %s", name, strings.Join(p.callStack, " -> "), name)
+		}
+	}
+	if len(p.callStack) >= maxFunctionDepth {
+		return nil, fmt.Errorf("слишком глубокая вложенность вызовов функций (больше %d)", maxFunctionDepth)
+	}
+
+	body := NewParser(fn.Body).WithFunctions(p.functions).WithVariables(fn.Params...)
+	body.callStack = append(append([]string(nil), p.callStack...), name)
+	tree, err := body.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка в теле функции '%s': %w", name, err)
+	}
+
+	bindings := make(map[string]*Node, len(args))
+	for i, param := range fn.Params {
+		bindings[param] = args[i]
+	}
+	return bindParams(tree, bindings), nil
+}
+
+// bindParams заменяет параметры в теле функции копиями аргументов. Одинаковые копии
+// планировщик все равно объединит в одну задачу.
+func bindParams(n *Node, args map[string]*Node) *Node {
+	if n == nil {
+		return nil
+	}
+	if arg, ok := args[n.Var]; ok && n.Var != "" {
+		return Substitute(arg, nil)
+	}
+	if n.IsLeaf() {
+		return n
+	}
+	return &Node{Op: n.Op, Left: bindParams(n.Left, args), Right: bindParams(n.Right, args), Unit: n.Unit}
+}
+
+// userFunctions загружает функции пользователя для парсера. Ошибка БД не мешает разбору
+// выражений без функций, поэтому только логируется.
+func userFunctions(db *database.Store, userID int64) map[string]UserFunction {
+	functions, err := db.GetFunctions(userID)
+	if err != nil {
+		log.Printf("Ошибка загрузки функций пользователя ID %d: %v", userID, err)
+		return nil
+	}
+	m := make(map[string]UserFunction, len(functions))
+	for _, fn := range functions {
+		m[fn.Name] = UserFunction{Params: fn.Params, Body: fn.Body}
+	}
+	return m
+}
+
+// newParser создает парсер выражения пользователя с его функциями.
+func (h *HTTPHandlers) newParser(userID int64, input string, lenient bool) *Parser {
+	parser := NewParser(input)
+	if lenient {
+		parser = NewLenientParser(input)
+	}
+	return parser.WithFunctions(userFunctions(h.db, userID))
+}
+
+func isReservedName(name string) bool {
+	return unaryFunctions[name] || binaryFunctions[name] || aggregateFunctions[name] ||
+		strings.ToLower(name) == "pi" || name == "in" || name == nowVariable
+}
+
+// validateFunction проверяет определение перед сохранением: имя и параметры, тело разбирается
+// вместе с остальными функциями пользователя, поэтому циклы между функциями отклоняются сразу.
+func validateFunction(name string, fn UserFunction, others map[string]UserFunction) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("некорректное имя функции '%s'", name)
+	}
+	if isReservedName(name) {
+		return fmt.Errorf("имя '%s' занято встроенной функцией или константой", name)
+	}
+	params := make(map[string]bool, len(fn.Params))
+	for _, param := range fn.Params {
+		if !identifierPattern.MatchString(param) || isReservedName(param) {
+			return fmt.Errorf("некорректное имя параметра '%s'", param)
+		}
+		if params[param] {
+			return fmt.Errorf("параметр '%s' указан дважды", param)
+		}
+		params[param] = true
+	}
+
+	functions := make(map[string]UserFunction, len(others)+1)
+	for n, f := range others {
+		functions[n] = f
+	}
+	functions[name] = fn
+	parser := NewParser(fn.Body).WithFunctions(functions).WithVariables(fn.Params...)
+	parser.callStack = []string{name}
+	ast, err := parser.Parse()
+	if err != nil {
+		return fmt.Errorf("ошибка в теле функции: %w", err)
+	}
+	if ast.Op == "in" {
+		return fmt.Errorf("перевод единиц 'in' в теле функции не поддерживается")
+	}
+	for _, v := range FreeVariables(ast) {
+		if !params[v] {
+			return fmt.Errorf("переменная '%s' не является параметром функции", v)
+		}
+	}
+	return nil
+}
+
+type FunctionRequest struct {
+	Params []string `json:"params"`
+	Body   string   `json:"body"`
+}
+
+// FunctionsHandler: GET /api/v1/functions - список функций пользователя,
+// GET, PUT и DELETE /api/v1/functions/{name} - одна функция.
+func (h *HTTPHandlers) FunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserIDFromContext(r.Context())
+	if !ok {
+		log.Println("Ошибка: не удалось получить userID из контекста в FunctionsHandler")
+		http.Error(w, "Внутренняя ошибка сервера (контекст пользователя)", http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/functions"), "/")
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		h.listFunctions(w, userID)
+	case r.Method == http.MethodGet:
+		h.getFunction(w, userID, name)
+	case r.Method == http.MethodPut && name != "":
+		h.putFunction(w, r, userID, name)
+	case r.Method == http.MethodDelete && name != "":
+		h.deleteFunction(w, userID, name)
+	default:
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *HTTPHandlers) listFunctions(w http.ResponseWriter, userID int64) {
+	functions, err := h.db.GetFunctions(userID)
+	if err != nil {
+		log.Printf("Ошибка получения функций пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении функций", http.StatusInternalServerError)
+		return
+	}
+	if functions == nil {
+		functions = []database.Function{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"functions": functions})
+}
+
+func (h *HTTPHandlers) getFunction(w http.ResponseWriter, userID int64, name string) {
+	fn, err := h.db.GetFunction(userID, name)
+	if err != nil {
+		log.Printf("Ошибка получения функции '%s' пользователя %d: %v", name, userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении функции", http.StatusInternalServerError)
+		return
+	}
+	if fn == nil {
+		http.Error(w, fmt.Sprintf("Функция '%s' не найдена", name), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fn)
+}
+
+func (h *HTTPHandlers) putFunction(w http.ResponseWriter, r *http.Request, userID int64, name string) {
+	var req FunctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" {
+		http.Error(w, "Пустое тело функции недопустимо", http.StatusBadRequest)
+		return
+	}
+	if req.Params == nil {
+		req.Params = []string{}
+	}
+
+	fn := UserFunction{Params: req.Params, Body: req.Body}
+	if err := validateFunction(name, fn, userFunctions(h.db, userID)); err != nil {
+		http.Error(w, "Некорректная функция: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.db.SaveFunction(&database.Function{UserID: userID, Name: name, Params: req.Params, Body: req.Body}); err != nil {
+		log.Printf("Ошибка сохранения функции '%s' пользователя %d: %v", name, userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при сохранении функции", http.StatusInternalServerError)
+		return
+	}
+	h.getFunction(w, userID, name)
+}
+
+func (h *HTTPHandlers) deleteFunction(w http.ResponseWriter, userID int64, name string) {
+	deleted, err := h.db.DeleteFunction(userID, name)
+	if err != nil {
+		log.Printf("Ошибка удаления функции '%s' пользователя %d: %v", name, userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при удалении функции", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, fmt.Sprintf("Функция '%s' не найдена", name), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,6 +3,8 @@ package orchestrator
 import (
 	"calculator/internal/database"
 	pb "calculator/internal/grpc/calculator" // Обновленный импорт gRPC кода
+	"calculator/internal/interval"
+	"calculator/internal/tensor"
 	"context"
 	"log"
 
@@ -13,22 +15,25 @@ import (
 type grpcServer struct {
 	pb.UnimplementedCalculatorAgentServiceServer // Встраивание для обратной совместимости
 	dbStore                                      *database.Store
-	opTimes                                      *OperationTimes // Нужны для заполнения operation_time_ms в задаче
-	scheduler                                    *Scheduler      // Добавляем планировщик для обработки завершения
+	scheduler                                    *Scheduler // Добавляем планировщик для обработки завершения
 }
 
-func NewCalculatorGRPCServer(db *database.Store, opTimes *OperationTimes, scheduler *Scheduler) *grpcServer {
+func NewCalculatorGRPCServer(db *database.Store, scheduler *Scheduler) *grpcServer {
 	return &grpcServer{
 		dbStore:   db,
-		opTimes:   opTimes,
 		scheduler: scheduler, // Сохраняем планировщик
 	}
 }
 
 func (s *grpcServer) GetTask(ctx context.Context, req *pb.GetTaskRequest) (*pb.GetTaskResponse, error) {
 	log.Printf("gRPC: Получен запрос GetTask от агента ID: %s", req.AgentId)
+	caps, err := agentCapabilities(req)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	s.scheduler.AgentSeen(req.AgentId, caps)
 
-	task, err := s.dbStore.GetAndLeasePendingTask()
+	task, err := s.dbStore.GetAndLeasePendingTask(caps)
 	if err != nil {
 		log.Printf("gRPC: Ошибка получения задачи из БД: %v", err)
 		return nil, status.Errorf(codes.Internal, "ошибка БД при получении задачи: %v", err)
@@ -45,18 +50,34 @@ func (s *grpcServer) GetTask(ctx context.Context, req *pb.GetTaskRequest) (*pb.G
 		}, nil
 	}
 
+	arg1Tensor, err := tensor.Parse(task.Arg1Tensor)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "некорректный аргумент задачи ID %d: %v", task.ID, err)
+	}
+	arg2Tensor, err := tensor.Parse(task.Arg2Tensor)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "некорректный аргумент задачи ID %d: %v", task.ID, err)
+	}
+
+	pbTask := &pb.Task{
+		Id:              task.ID,
+		Arg1:            task.Arg1,
+		Arg2:            task.Arg2,
+		Operation:       task.Operation,
+		OperationTimeMs: s.getOperationTimeMs(task.Operation), // Получаем время для операции
+		Arg1Tensor:      arg1Tensor.ToProto(),
+		Arg2Tensor:      arg2Tensor.ToProto(),
+		Arg1Complex:     complexArg(task.Arg1, task.Arg1Imag),
+		Arg2Complex:     complexArg(task.Arg2, task.Arg2Imag),
+	}
+	pbTask.AllowSpecialValues = task.SpecialValues == database.SpecialIEEE
+	if task.Mode == database.ModeInterval {
+		pbTask.Arg1Interval = &pb.Interval{Lo: task.Arg1, Hi: task.Arg1Hi}
+		pbTask.Arg2Interval = &pb.Interval{Lo: task.Arg2, Hi: task.Arg2Hi}
+	}
+
 	log.Printf("gRPC: Отправка задачи ID %d агенту %s", task.ID, req.AgentId)
-	return &pb.GetTaskResponse{
-		TaskInfo: &pb.GetTaskResponse_Task{
-			Task: &pb.Task{
-				Id:              task.ID,
-				Arg1:            task.Arg1,
-				Arg2:            task.Arg2,
-				Operation:       task.Operation,
-				OperationTimeMs: s.getOperationTimeMs(task.Operation), // Получаем время для операции
-			},
-		},
-	}, nil
+	return &pb.GetTaskResponse{TaskInfo: &pb.GetTaskResponse_Task{Task: pbTask}}, nil
 }
 
 func (s *grpcServer) SubmitResult(ctx context.Context, req *pb.SubmitResultRequest) (*pb.SubmitResultResponse, error) {
@@ -71,9 +92,43 @@ func (s *grpcServer) SubmitResult(ctx context.Context, req *pb.SubmitResultReque
 		} else {
 			log.Printf("gRPC: Ошибка завершения задачи ID %d в БД: %v", req.TaskId, taskErr)
 		}
+	case *pb.SubmitResultRequest_TensorResult:
+		t, err := tensor.FromProto(result.TensorResult)
+		if err != nil || t == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "некорректный результат-вектор: %v", err)
+		}
+		taskErr = s.dbStore.CompleteTensorTask(req.TaskId, t.String())
+		if taskErr != nil {
+			log.Printf("gRPC: Ошибка завершения задачи ID %d в БД: %v", req.TaskId, taskErr)
+		}
+	case *pb.SubmitResultRequest_ComplexResult:
+		c := result.ComplexResult
+		if c == nil {
+			return nil, status.Error(codes.InvalidArgument, "пустой комплексный результат")
+		}
+		if c.Im == 0 {
+			taskErr = s.dbStore.CompleteTask(req.TaskId, c.Re)
+		} else {
+			taskErr = s.dbStore.CompleteComplexTask(req.TaskId, c.Re, c.Im)
+		}
+		if taskErr != nil {
+			log.Printf("gRPC: Ошибка завершения задачи ID %d в БД: %v", req.TaskId, taskErr)
+		}
+	case *pb.SubmitResultRequest_IntervalResult:
+		r, ok := interval.FromProto(result.IntervalResult)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "пустой результат-интервал")
+		}
+		if _, err := interval.New(r.Lo, r.Hi); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "некорректный результат-интервал: %v", err)
+		}
+		taskErr = s.dbStore.CompleteIntervalTask(req.TaskId, r.Lo, r.Hi)
+		if taskErr != nil {
+			log.Printf("gRPC: Ошибка завершения задачи ID %d в БД: %v", req.TaskId, taskErr)
+		}
 	case *pb.SubmitResultRequest_Error:
 		log.Printf("gRPC: Задача ID %d завершилась ошибкой: %s", req.TaskId, result.Error.Message)
-		taskErr = s.dbStore.FailTask(req.TaskId)
+		taskErr = s.dbStore.FailTask(req.TaskId, result.Error.Message, result.Error.Permanent)
 		if taskErr != nil {
 			log.Printf("gRPC: Ошибка отметки задачи ID %d как ошибочной в БД: %v", req.TaskId, taskErr)
 		}
@@ -91,19 +146,16 @@ func (s *grpcServer) SubmitResult(ctx context.Context, req *pb.SubmitResultReque
 	return &pb.SubmitResultResponse{Acknowledged: true}, nil
 }
 
-func (s *grpcServer) getOperationTimeMs(op string) int32 {
-	var t int
-	switch op {
-	case "+":
-		t = s.opTimes.Addition
-	case "-":
-		t = s.opTimes.Subtraction
-	case "*":
-		t = s.opTimes.Multiplication
-	case "/":
-		t = s.opTimes.Division
-	default:
-		t = 1000 // Время по умолчанию
+// complexArg передает аргумент агенту комплексным числом, только если у него есть мнимая часть.
+func complexArg(re, im float64) *pb.Complex {
+	if im == 0 {
+		return nil
 	}
-	return int32(t)
+	return &pb.Complex{Re: re, Im: im}
+}
+
+// getOperationTimeMs берет время из текущего снимка: изменение через API действует на задачи,
+// выданные после него.
+func (s *grpcServer) getOperationTimeMs(op string) int32 {
+	return int32(s.scheduler.GetOperationTimes().For(op))
 }
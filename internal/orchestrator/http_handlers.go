@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type HTTPHandlers struct {
@@ -127,6 +128,18 @@ func (h *HTTPHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 type CalculateRequest struct {
 	Expression string `json:"expression"`
+	Lenient    bool   `json:"lenient,omitempty"`  // Нестрогий разбор: 2(3+4), 6 × 7, 3π
+	NoCache    bool   `json:"no_cache,omitempty"` // Не использовать кэш результатов
+	Mode       string `json:"mode,omitempty"`     // Числовой режим: "interval" - интервальная арифметика
+	// Политика для Inf и NaN: "strict" (по умолчанию) - ошибка, "ieee" - результат строкой "+Inf", "NaN"
+	SpecialValues string `json:"special_values,omitempty"`
+	// Приоритет в очереди задач; ограничен потолком роли пользователя
+	Priority int `json:"priority,omitempty"`
+	// Срок вычисления: момент в RFC 3339 или время от отправки в мс; задается что-то одно
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	TimeoutMs int64      `json:"timeout_ms,omitempty"`
+	// Метки, которые должны быть у агента, например {"region": "eu"}
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 func (h *HTTPHandlers) CalculateHandler(w http.ResponseWriter, r *http.Request) {
@@ -153,28 +166,91 @@ func (h *HTTPHandlers) CalculateHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Пустое выражение недопустимо", http.StatusBadRequest)
 		return
 	}
-
-	exprID, err := h.db.CreateExpression(userID, exprStr) // userID и exprStr теперь определены
+	if err := validateMode(req.Mode); err != nil {
+		http.Error(w, "Некорректный режим: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateSpecialValues(req.SpecialValues); err != nil {
+		http.Error(w, "Некорректное значение special_values: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	role, err := h.auth.UserRole(userID)
 	if err != nil {
-		log.Printf("Ошибка создания выражения в БД для пользователя %d: %v", userID, err)
-		http.Error(w, "Внутренняя ошибка сервера при сохранении выражения", http.StatusInternalServerError)
+		log.Printf("Ошибка получения роли пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	priority, err := capPriority(role, req.Priority)
+	if err != nil {
+		http.Error(w, "Некорректный приоритет: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	deadline, err := resolveDeadline(req.Deadline, req.TimeoutMs, time.Now())
+	if err != nil {
+		http.Error(w, "Некорректный срок: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		http.Error(w, "Некорректные метки: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Создано выражение ID %d для пользователя %d: %s", exprID, userID, exprStr)
+	opts := database.ExpressionOptions{NoCache: req.NoCache, Mode: req.Mode, SpecialValues: req.SpecialValues, Priority: priority,
+		Deadline: deadline, Labels: req.Labels}
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		h.previewPlan(w, userID, exprStr, req.Lenient, opts)
+		return
+	}
 
-	go func(id int64, expression string) {
-		err := h.scheduler.ScheduleTasks(id, expression)
+	var canonical string
+	if req.Lenient {
+		// Сохраняем ASCII-форму, чтобы повторный разбор в планировщике работал строгим парсером.
+		ast, err := h.newParser(userID, exprStr, true).Parse()
 		if err != nil {
-			log.Printf("Асинхронная ошибка планирования задач для выражения ID %d: %v", id, err)
+			http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusBadRequest)
+			return
 		}
-	}(exprID, exprStr)
+		exprStr = ast.Pretty()
+		canonical = CanonicalKey(ast)
+	} else if ast, err := h.newParser(userID, exprStr, false).Parse(); err == nil {
+		// Ошибку разбора строгого выражения сообщит планировщик, как и раньше.
+		canonical = CanonicalKey(ast)
+	}
+
+	duplicate, err := h.db.FindExpressionByCanonical(userID, canonical, req.Mode)
+	if err != nil {
+		log.Printf("Ошибка поиска повторного выражения для пользователя %d: %v", userID, err)
+	}
+
+	exprID, err := h.submitExpression(&database.Expression{
+		UserID:            userID,
+		Expression:        exprStr,
+		Canonical:         canonical,
+		ExpressionOptions: opts,
+	})
+	if err != nil {
+		log.Printf("Ошибка создания выражения в БД для пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при сохранении выражения", http.StatusInternalServerError)
+		return
+	}
 
 	respData := map[string]interface{}{
 		"id":         exprID,
 		"expression": exprStr,
 		"status":     database.StatusPending, // Начальный статус
 	}
+	if canonical != "" {
+		respData["canonical"] = canonical
+	}
+	if duplicate != nil {
+		respData["duplicate_of"] = duplicate.ID // То же выражение с точностью до порядка операндов
+	}
+	if req.Priority != 0 {
+		respData["priority"] = priority // Может быть снижен до потолка роли
+	}
+	if deadline != nil {
+		respData["deadline"] = deadline
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated) // 200 Created
@@ -183,6 +259,110 @@ func (h *HTTPHandlers) CalculateHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// submitExpression сохраняет выражение и асинхронно планирует его задачи.
+func (h *HTTPHandlers) submitExpression(expr *database.Expression) (int64, error) {
+	exprID, err := h.db.CreateExpression(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("Создано выражение ID %d для пользователя %d: %s", exprID, expr.UserID, expr.Expression)
+
+	go func(id int64, expression string, opts database.ExpressionOptions) {
+		err := h.scheduler.ScheduleTasks(id, expression, opts)
+		if err != nil {
+			log.Printf("Асинхронная ошибка планирования задач для выражения ID %d: %v", id, err)
+		}
+	}(exprID, expr.Expression, expr.ExpressionOptions)
+
+	return exprID, nil
+}
+
+type DeriveRequest struct {
+	Expression string             `json:"expression"`
+	Var        string             `json:"var"`
+	Lenient    bool               `json:"lenient,omitempty"`
+	At         map[string]float64 `json:"at,omitempty"` // Точка, в которой производную нужно вычислить через /calculate
+}
+
+type DeriveResponse struct {
+	Expression   string `json:"expression"`
+	Var          string `json:"var"`
+	Derivative   string `json:"derivative"`
+	LaTeX        string `json:"latex"`
+	ExpressionID int64  `json:"expression_id,omitempty"` // ID выражения для численного вычисления в точке
+}
+
+// DeriveHandler возвращает символьную производную выражения. Если задана точка "at",
+// производная с подставленными значениями отправляется на вычисление как обычное выражение.
+func (h *HTTPHandlers) DeriveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(r.Context())
+	if !ok {
+		log.Println("Ошибка: не удалось получить userID из контекста в DeriveHandler")
+		http.Error(w, "Внутренняя ошибка сервера (контекст пользователя)", http.StatusInternalServerError)
+		return
+	}
+
+	var req DeriveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Var = strings.TrimSpace(req.Var)
+	if req.Var == "" {
+		http.Error(w, "Не указана переменная дифференцирования", http.StatusBadRequest)
+		return
+	}
+
+	ast, err := h.newParser(userID, req.Expression, req.Lenient).Parse()
+	if err != nil {
+		http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	derivative, err := Derive(ast, req.Var)
+	if err != nil {
+		http.Error(w, "Ошибка дифференцирования: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	resp := DeriveResponse{
+		Expression: ast.Pretty(),
+		Var:        req.Var,
+		Derivative: derivative.Pretty(),
+		LaTeX:      ExportLaTeX(derivative),
+	}
+
+	if req.At != nil {
+		point := Substitute(derivative, req.At)
+		if vars := FreeVariables(point); len(vars) > 0 {
+			http.Error(w, fmt.Sprintf("Не задано значение переменной '%s' в точке вычисления", vars[0]), http.StatusBadRequest)
+			return
+		}
+		exprID, err := h.submitExpression(&database.Expression{
+			UserID:     userID,
+			Expression: point.Pretty(),
+			Canonical:  CanonicalKey(point),
+		})
+		if err != nil {
+			log.Printf("Ошибка создания выражения производной для пользователя %d: %v", userID, err)
+			http.Error(w, "Внутренняя ошибка сервера при сохранении выражения", http.StatusInternalServerError)
+			return
+		}
+		resp.ExpressionID = exprID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Ошибка записи JSON ответа для DeriveHandler: %v", err)
+	}
+}
+
 func EnableCORS(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")                                                                                   // Разрешаем все источники (для разработки)
@@ -214,6 +394,11 @@ func (h *HTTPHandlers) ExpressionsHandler(w http.ResponseWriter, r *http.Request
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1/expressions")
 	idStr := strings.Trim(path, "/")
 
+	if astID, ok := strings.CutSuffix(idStr, "/ast"); ok {
+		h.expressionASTHandler(w, r, userID, astID)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	if idStr == "" { // Запрос списка выражений
@@ -226,6 +411,10 @@ func (h *HTTPHandlers) ExpressionsHandler(w http.ResponseWriter, r *http.Request
 		if expressions == nil {
 			expressions = []database.Expression{}
 		}
+		now := time.Now()
+		for i := range expressions {
+			expressions[i].ETA = h.scheduler.EstimateCompletion(&expressions[i], now)
+		}
 		if err := json.NewEncoder(w).Encode(expressions); err != nil {
 			log.Printf("Ошибка записи JSON ответа для списка выражений (userID: %d): %v", userID, err)
 		}
@@ -249,8 +438,156 @@ func (h *HTTPHandlers) ExpressionsHandler(w http.ResponseWriter, r *http.Request
 		http.Error(w, fmt.Sprintf("Выражение с ID %d не найдено или доступ запрещен", id), http.StatusNotFound)
 		return
 	}
+	expression.ETA = h.scheduler.EstimateCompletion(expression, time.Now())
 
 	if err := json.NewEncoder(w).Encode(expression); err != nil {
 		log.Printf("Ошибка записи JSON ответа для выражения ID %d (userID: %d): %v", id, userID, err)
 	}
 }
+
+// previewPlan отвечает на POST /api/v1/calculate?dry_run=true: план задач и оценка времени
+// без сохранения выражения.
+func (h *HTTPHandlers) previewPlan(w http.ResponseWriter, userID int64, exprStr string, lenient bool, opts database.ExpressionOptions) {
+	if lenient {
+		ast, err := h.newParser(userID, exprStr, true).Parse()
+		if err != nil {
+			http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		exprStr = ast.Pretty()
+	}
+	preview, err := h.scheduler.PreviewPlan(userID, exprStr, opts)
+	if err != nil {
+		http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+func (h *HTTPHandlers) CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.scheduler.GetCache().Stats()); err != nil {
+		log.Printf("Ошибка записи JSON ответа для статистики кэша: %v", err)
+	}
+}
+
+// expressionASTHandler отдает дерево сохраненного выражения (после оптимизации, в том виде,
+// в котором по нему созданы задачи) с привязкой узлов к задачам. Для уравнения решателя
+// отдается дерево функции lhs - rhs, корень которой ищется.
+func (h *HTTPHandlers) expressionASTHandler(w http.ResponseWriter, r *http.Request, userID int64, idStr string) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Неверный ID выражения: "+idStr, http.StatusBadRequest)
+		return
+	}
+
+	expression, err := h.db.GetExpressionByID(id, userID)
+	if err != nil {
+		log.Printf("Ошибка получения выражения ID %d для пользователя %d: %v", id, userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении выражения", http.StatusInternalServerError)
+		return
+	}
+	if expression == nil {
+		http.Error(w, fmt.Sprintf("Выражение с ID %d не найдено или доступ запрещен", id), http.StatusNotFound)
+		return
+	}
+
+	if expression.Equation {
+		// Своих задач у уравнения нет: значения функции считаются отдельными выражениями.
+		lhs, rhs, err := NewParser(expression.Expression).ParseEquation()
+		if err != nil {
+			http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		writeAST(w, r.URL.Query().Get("format"), binary("-", lhs, rhs), nil)
+		return
+	}
+
+	// Дерево не строится заново: ссылки и функции пользователя могли измениться после планирования.
+	scheduled, err := h.db.GetExpressionAST(id)
+	if err != nil {
+		log.Printf("Ошибка получения дерева выражения ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении выражения", http.StatusInternalServerError)
+		return
+	}
+	if scheduled == "" {
+		http.Error(w, fmt.Sprintf("Выражение с ID %d еще не запланировано (статус %s)", id, expression.Status), http.StatusConflict)
+		return
+	}
+	var node ASTNode
+	if err := json.Unmarshal([]byte(scheduled), &node); err != nil {
+		log.Printf("Ошибка разбора дерева выражения ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении выражения", http.StatusInternalServerError)
+		return
+	}
+
+	tasks, err := h.db.GetAllTasksForExpression(id)
+	if err != nil {
+		log.Printf("Ошибка получения задач выражения ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении задач", http.StatusInternalServerError)
+		return
+	}
+	byID := make(map[int64]database.Task, len(tasks))
+	for _, t := range tasks {
+		byID[t.ID] = t
+	}
+
+	ast, byKey := importJSON(&node, byID)
+	writeAST(w, r.URL.Query().Get("format"), ast, byKey)
+}
+
+type ParseRequest struct {
+	Expression string `json:"expression"`
+	Lenient    bool   `json:"lenient,omitempty"`
+	Optimize   bool   `json:"optimize,omitempty"` // Показать дерево после оптимизатора
+}
+
+// ParseHandler разбирает выражение без сохранения и возвращает его дерево.
+func (h *HTTPHandlers) ParseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ParseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := GetUserIDFromContext(r.Context())
+	ast, err := h.newParser(userID, req.Expression, req.Lenient).Parse()
+	if err != nil {
+		http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Optimize {
+		ast, _ = h.scheduler.optimizer.Optimize(ast)
+	}
+
+	writeAST(w, r.URL.Query().Get("format"), ast, nil)
+}
+
+func writeAST(w http.ResponseWriter, format string, ast *Node, tasks map[string]database.Task) {
+	switch format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ExportJSON(ast, tasks)); err != nil {
+			log.Printf("Ошибка записи JSON ответа для AST: %v", err)
+		}
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		fmt.Fprint(w, ExportDOT(ast, tasks))
+	case "latex":
+		w.Header().Set("Content-Type", "application/x-latex; charset=utf-8")
+		fmt.Fprint(w, ExportLaTeX(ast))
+	default:
+		http.Error(w, "Неизвестный формат: "+format+" (ожидается json, dot или latex)", http.StatusBadRequest)
+	}
+}
@@ -3,10 +3,12 @@ package orchestrator
 import (
 	"calculator/internal/database"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func setupHandlers(t *testing.T) *HTTPHandlers {
@@ -65,7 +67,7 @@ func TestRegisterLoginCalculateFlow(t *testing.T) {
 	rec = httptest.NewRecorder()
 	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression":"2+2"}`))
 	req.Header.Set("Content-Type", "application/json")
-	h.CalculateHandler(rec, req)
+	h.auth.JWTMiddleware(http.HandlerFunc(h.CalculateHandler)).ServeHTTP(rec, req)
 	if rec.Code != http.StatusUnauthorized {
 		t.Fatalf("Calculate without auth expected %d, got %d", http.StatusUnauthorized, rec.Code)
 	}
@@ -74,7 +76,7 @@ func TestRegisterLoginCalculateFlow(t *testing.T) {
 	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression":"2+2"}`))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+loginResp.Token)
-	h.CalculateHandler(rec, req)
+	h.auth.JWTMiddleware(http.HandlerFunc(h.CalculateHandler)).ServeHTTP(rec, req)
 	if rec.Code != http.StatusCreated {
 		t.Fatalf("Calculate with auth expected %d, got %d body=%s", http.StatusCreated, rec.Code, rec.Body.String())
 	}
@@ -93,7 +95,7 @@ func TestRegisterLoginCalculateFlow(t *testing.T) {
 	rec = httptest.NewRecorder()
 	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil)
 	req.Header.Set("Authorization", "Bearer "+loginResp.Token)
-	h.ExpressionsHandler(rec, req)
+	h.auth.JWTMiddleware(http.HandlerFunc(h.ExpressionsHandler)).ServeHTTP(rec, req)
 	if rec.Code != http.StatusOK {
 		t.Fatalf("Expressions list expected %d, got %d", http.StatusOK, rec.Code)
 	}
@@ -104,4 +106,332 @@ func TestRegisterLoginCalculateFlow(t *testing.T) {
 	if len(list) != 1 {
 		t.Fatalf("Expected 1 expression, got %d", len(list))
 	}
-}
\ No newline at end of file
+}
+
+// serveAs выполняет запрос к handler через JWTMiddleware с токеном пользователя userID.
+func serveAs(t *testing.T, h *HTTPHandlers, userID int64, handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := h.auth.GenerateJWT(userID)
+	if err != nil {
+		t.Fatalf("GenerateJWT error: %v", err)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.auth.JWTMiddleware(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFunctionsHandler(t *testing.T) {
+	h := setupHandlers(t)
+	userID, _ := h.db.CreateUser("funcs", "hash")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		return serveAs(t, h, userID, h.FunctionsHandler, method, path, body)
+	}
+
+	if rec := do(http.MethodPut, "/api/v1/functions/tax", `{"params":["x"],"body":"x * 0.13"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT tax expected %d, got %d body=%s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/api/v1/functions/gross", `{"params":["x"],"body":"x + tax(x)"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT gross expected %d, got %d body=%s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	bad := map[string]string{
+		"/api/v1/functions/tax":  `{"params":["x"],"body":"gross(x)"}`, // Цикл tax -> gross -> tax
+		"/api/v1/functions/f":    `{"params":["x"],"body":"x + y"}`,    // y - не параметр
+		"/api/v1/functions/sin":  `{"params":["x"],"body":"x"}`,        // Встроенная функция
+		"/api/v1/functions/g":    `{"params":["x","x"],"body":"x"}`,
+		"/api/v1/functions/h":    `{"params":["x"],"body":"tax(x, 1)"}`,
+		"/api/v1/functions/2bad": `{"params":[],"body":"1"}`,
+	}
+	for path, body := range bad {
+		if rec := do(http.MethodPut, path, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s %s expected %d, got %d body=%s", path, body, http.StatusBadRequest, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := do(http.MethodGet, "/api/v1/functions", "")
+	var list struct{ Functions []database.Function }
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Functions) != 2 || list.Functions[1].Body != "x * 0.13" {
+		t.Fatalf("GET functions = %+v, %v", list, err)
+	}
+
+	// Выражение с функцией пользователя разбирается при отправке.
+	calc := serveAs(t, h, userID, h.CalculateHandler, http.MethodPost, "/api/v1/calculate", `{"expression":"gross(1000) + 50"}`)
+	var calcResp struct{ Canonical string }
+	json.NewDecoder(calc.Body).Decode(&calcResp)
+	if calc.Code != http.StatusCreated || calcResp.Canonical == "" {
+		t.Fatalf("Calculate with function expected %d and canonical form, got %d body=%s", http.StatusCreated, calc.Code, calc.Body.String())
+	}
+
+	if rec := do(http.MethodDelete, "/api/v1/functions/gross", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE expected %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/v1/functions/gross", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET deleted function expected %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestPriorityAndAdminUsers(t *testing.T) {
+	h := setupHandlers(t)
+	userID, _ := h.db.CreateUser("user", "hash")
+	adminID, _ := h.db.CreateUser("root", "hash")
+	h.auth.adminLogins["root"] = true
+	do := func(userID int64, handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+		return serveAs(t, h, userID, handler, method, path, body)
+	}
+	priority := func(userID int64, body string) int {
+		rec := do(userID, h.CalculateHandler, http.MethodPost, "/api/v1/calculate", body)
+		var resp struct{ Priority int }
+		if err := json.NewDecoder(rec.Body).Decode(&resp); rec.Code != http.StatusCreated || err != nil {
+			t.Fatalf("calculate %s: code=%d err=%v", body, rec.Code, err)
+		}
+		return resp.Priority
+	}
+
+	if got := priority(userID, `{"expression":"1+1","priority":3}`); got != 3 {
+		t.Errorf("user priority 3: got %d", got)
+	}
+	if got := priority(userID, `{"expression":"1+2","priority":50}`); got != maxPriority[database.RoleUser] {
+		t.Errorf("user priority 50: got %d, want cap %d", got, maxPriority[database.RoleUser])
+	}
+	if got := priority(adminID, `{"expression":"1+3","priority":50}`); got != maxPriority[database.RoleAdmin] {
+		t.Errorf("admin priority 50: got %d, want cap %d", got, maxPriority[database.RoleAdmin])
+	}
+	if rec := do(userID, h.CalculateHandler, http.MethodPost, "/api/v1/calculate", `{"expression":"1+4","priority":-1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative priority expected %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	if rec := do(userID, h.AdminUsersHandler, http.MethodGet, "/api/v1/admin/users", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("GET admin/users by user expected %d, got %d", http.StatusForbidden, rec.Code)
+	}
+	path := fmt.Sprintf("/api/v1/admin/users/%d", userID)
+	for _, body := range []string{`{"weight":0}`, `{"weight":101}`, `{"role":"root"}`} {
+		if rec := do(adminID, h.AdminUsersHandler, http.MethodPut, path, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s expected %d, got %d", body, http.StatusBadRequest, rec.Code)
+		}
+	}
+	if rec := do(adminID, h.AdminUsersHandler, http.MethodPut, path, `{"role":"admin","weight":3}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT expected %d, got %d body=%s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec := do(userID, h.AdminUsersHandler, http.MethodGet, "/api/v1/admin/users", "")
+	var list struct{ Users []database.User }
+	if err := json.NewDecoder(rec.Body).Decode(&list); rec.Code != http.StatusOK || err != nil || len(list.Users) != 2 {
+		t.Fatalf("GET admin/users by new admin: code=%d users=%+v err=%v", rec.Code, list, err)
+	}
+	if u := list.Users[0]; u.ID != userID || u.Role != database.RoleAdmin || u.Weight != 3 {
+		t.Errorf("updated user = %+v", u)
+	}
+}
+
+func TestAdminOperationTimes(t *testing.T) {
+	h := setupHandlers(t)
+	userID, _ := h.db.CreateUser("user", "hash")
+	adminID, _ := h.db.CreateUser("root", "hash")
+	h.auth.adminLogins["root"] = true
+	do := func(userID int64, method, body string) *httptest.ResponseRecorder {
+		return serveAs(t, h, userID, h.AdminSettingsHandler, method, "/api/v1/admin/settings/operation-times", body)
+	}
+
+	if rec := do(userID, http.MethodPut, `{"addition_ms":5}`); rec.Code != http.StatusForbidden {
+		t.Errorf("PUT by user expected %d, got %d", http.StatusForbidden, rec.Code)
+	}
+	for _, body := range []string{`{"addition_ms":-1}`, `{"addition_ms":3600001}`, `{"additon_ms":5}`} {
+		if rec := do(adminID, http.MethodPut, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s expected %d, got %d", body, http.StatusBadRequest, rec.Code)
+		}
+	}
+
+	before := *h.scheduler.GetOperationTimes()
+	rec := do(adminID, http.MethodPut, `{"addition_ms":5,"power_ms":7}`)
+	var resp struct {
+		OperationTimes
+		History []database.SettingChange
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("PUT: code=%d err=%v", rec.Code, err)
+	}
+	want := before
+	want.Addition, want.Power = 5, 7
+	if resp.OperationTimes != want || *h.scheduler.GetOperationTimes() != want {
+		t.Errorf("operation times = %+v (applied %+v), want %+v", resp.OperationTimes, *h.scheduler.GetOperationTimes(), want)
+	}
+	if len(resp.History) != 1 || resp.History[0].UserID != adminID || resp.History[0].Login != "root" || resp.History[0].OldValue != nil {
+		t.Errorf("history = %+v, want one change by root", resp.History)
+	}
+
+	do(adminID, http.MethodPut, `{"addition_ms":9}`)
+	rec = do(adminID, http.MethodGet, "")
+	if err := json.NewDecoder(rec.Body).Decode(&resp); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("GET: code=%d err=%v", rec.Code, err)
+	}
+	var old OperationTimes
+	if len(resp.History) != 2 || json.Unmarshal(resp.History[0].OldValue, &old) != nil || old.Addition != 5 || resp.Addition != 9 {
+		t.Errorf("history after second change = %+v", resp.History)
+	}
+
+	// Сохраненное время переживает перезапуск оркестратора.
+	if times := NewScheduler(h.db).GetOperationTimes(); times.Addition != 9 || times.Power != 7 {
+		t.Errorf("reloaded operation times = %+v", *times)
+	}
+}
+
+func TestCalculateDeadline(t *testing.T) {
+	h := setupHandlers(t)
+	userID, _ := h.db.CreateUser("deadline", "hash")
+	calculate := func(body string) *httptest.ResponseRecorder {
+		return serveAs(t, h, userID, h.CalculateHandler, http.MethodPost, "/api/v1/calculate", body)
+	}
+
+	bad := []string{
+		`{"expression":"1+1","timeout_ms":-1}`,
+		`{"expression":"1+1","deadline":"2000-01-01T00:00:00Z"}`,
+		`{"expression":"1+1","deadline":"2100-01-01T00:00:00Z","timeout_ms":1000}`,
+	}
+	for _, body := range bad {
+		if rec := calculate(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s expected %d, got %d", body, http.StatusBadRequest, rec.Code)
+		}
+	}
+
+	before := time.Now()
+	rec := calculate(`{"expression":"1+1","timeout_ms":60000}`)
+	var resp struct {
+		ID       int64
+		Deadline time.Time
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); rec.Code != http.StatusCreated || err != nil {
+		t.Fatalf("timeout_ms: code=%d err=%v", rec.Code, err)
+	}
+	if resp.Deadline.Before(before.Add(time.Minute).Truncate(time.Millisecond)) || resp.Deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("deadline %v is not a minute after submission", resp.Deadline)
+	}
+	expr, _ := h.db.GetExpressionByID(resp.ID, userID)
+	if expr == nil || expr.Deadline == nil || !expr.Deadline.Equal(resp.Deadline) {
+		t.Errorf("stored deadline = %v, want %v", expr, resp.Deadline)
+	}
+}
+
+func TestCalculateLabels(t *testing.T) {
+	h := setupHandlers(t)
+	userID, _ := h.db.CreateUser("labels", "hash")
+	calculate := func(body string) *httptest.ResponseRecorder {
+		return serveAs(t, h, userID, h.CalculateHandler, http.MethodPost, "/api/v1/calculate", body)
+	}
+
+	for _, body := range []string{`{"expression":"1+1","labels":{"region":""}}`, `{"expression":"1+1","labels":{"a=b":"c"}}`} {
+		if rec := calculate(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s expected %d, got %d", body, http.StatusBadRequest, rec.Code)
+		}
+	}
+	rec := calculate(`{"expression":"1+1","labels":{"region":"eu"}}`)
+	var resp struct{ ID int64 }
+	if err := json.NewDecoder(rec.Body).Decode(&resp); rec.Code != http.StatusCreated || err != nil {
+		t.Fatalf("labels: code=%d err=%v", rec.Code, err)
+	}
+	if expr, _ := h.db.GetExpressionByID(resp.ID, userID); expr == nil || expr.Labels["region"] != "eu" {
+		t.Errorf("stored expression = %+v, want label region=eu", expr)
+	}
+}
+
+func TestCalculateDryRun(t *testing.T) {
+	h := setupHandlers(t)
+	userID, _ := h.db.CreateUser("dryrun", "hash")
+	calculate := func(body string) *httptest.ResponseRecorder {
+		return serveAs(t, h, userID, h.CalculateHandler, http.MethodPost, "/api/v1/calculate?dry_run=true", body)
+	}
+
+	rec := calculate(`{"expression":"(2+3)*(4-1)"}`)
+	var preview PlanPreview
+	if err := json.NewDecoder(rec.Body).Decode(&preview); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("dry run: code=%d err=%v", rec.Code, err)
+	}
+	// Оптимизатор по умолчанию сворачивает константы: агентам ничего не достается.
+	if preview.Expression != "15" || len(preview.Tasks) != 0 || len(preview.Folded) != 3 {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+	if rec := calculate(`{"expression":"(2+"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid expression expected %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if exprs, _ := h.db.GetExpressionsByUserID(userID); len(exprs) != 0 {
+		t.Errorf("dry run saved %d expressions", len(exprs))
+	}
+
+	refID, _ := h.db.CreateExpression(&database.Expression{UserID: userID, Expression: "2+2", Status: database.StatusPending})
+	rec = calculate(fmt.Sprintf(`{"expression":"$%d*3"}`, refID))
+	preview = PlanPreview{}
+	if err := json.NewDecoder(rec.Body).Decode(&preview); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("dry run with pending reference: code=%d err=%v", rec.Code, err)
+	}
+	if len(preview.Waiting) != 1 || preview.Waiting[0] != fmt.Sprintf("$%d", refID) || len(preview.Tasks) != 0 {
+		t.Errorf("expected pending reference in preview, got %+v", preview)
+	}
+}
+
+func TestSchedulesHandler(t *testing.T) {
+	h := setupHandlers(t)
+	userID, _ := h.db.CreateUser("schedules", "hash")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		return serveAs(t, h, userID, h.SchedulesHandler, method, path, body)
+	}
+
+	bad := []string{
+		`{"cron":"* * *","expression":"1+1"}`,
+		`{"cron":"0 0 30 2 *","expression":"1+1"}`,
+		`{"cron":"@hourly","expression":"x+1"}`,
+		`{"cron":"@hourly","expression":"now(1)"}`,
+		`{"cron":"@hourly","expression":"1+1","mode":"exact"}`,
+	}
+	for _, body := range bad {
+		if rec := do(http.MethodPost, "/api/v1/schedules", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s expected %d, got %d", body, http.StatusBadRequest, rec.Code)
+		}
+	}
+
+	rec := do(http.MethodPost, "/api/v1/schedules", `{"cron":"@hourly","expression":"now() / 3600","priority":2}`)
+	var sch database.Schedule
+	if err := json.NewDecoder(rec.Body).Decode(&sch); rec.Code != http.StatusCreated || err != nil {
+		t.Fatalf("POST expected %d, got %d err=%v", http.StatusCreated, rec.Code, err)
+	}
+	if sch.Status != database.ScheduleActive || sch.Priority != 2 || sch.NextRun.Minute() != 0 || !sch.NextRun.After(time.Now()) {
+		t.Errorf("created schedule = %+v", sch)
+	}
+
+	path := fmt.Sprintf("/api/v1/schedules/%d", sch.ID)
+	if rec := do(http.MethodPost, path+"/pause", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"paused"`) {
+		t.Errorf("pause: code=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, path+"/resume", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"active"`) {
+		t.Errorf("resume: code=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, path, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"runs":[]`) {
+		t.Errorf("GET: code=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodDelete, path, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE expected %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := do(http.MethodGet, path, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET deleted expected %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestSolveExpressionAST(t *testing.T) {
+	h := setupHandlers(t)
+	userID, _ := h.db.CreateUser("solver", "hash")
+
+	rec := serveAs(t, h, userID, h.SolveHandler, http.MethodPost, "/api/v1/solve", `{"equation":"x^2 = 2","from":0,"to":2}`)
+	var solve struct {
+		ExpressionID int64 `json:"expression_id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&solve); rec.Code != http.StatusCreated || err != nil {
+		t.Fatalf("solve: code=%d err=%v", rec.Code, err)
+	}
+
+	rec = serveAs(t, h, userID, h.ExpressionsHandler, http.MethodGet, fmt.Sprintf("/api/v1/expressions/%d/ast?format=latex", solve.ExpressionID), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("solve expression AST expected %d, got %d body=%s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if got := rec.Body.String(); got != "{x}^{2} - 2" {
+		t.Errorf("solve expression AST = %q, want {x}^{2} - 2", got)
+	}
+}
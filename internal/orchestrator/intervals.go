@@ -0,0 +1,58 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"calculator/internal/interval"
+	"fmt"
+)
+
+// validateMode проверяет числовой режим, заданный клиентом.
+func validateMode(mode string) error {
+	if mode != "" && mode != database.ModeInterval {
+		return fmt.Errorf("неизвестный режим вычислений '%s'", mode)
+	}
+	return nil
+}
+
+// applyMode готовит дерево к вычислению в режиме mode. В режиме интервалов запись [lo, hi]
+// означает интервал, а не вектор, и каждое число тоже становится интервалом, содержащим его
+// десятичную запись; векторы, матрицы и комплексные числа там не поддерживаются.
+// В обычном режиме не допускаются интервалы, попавшие в дерево по ссылкам $id.
+func applyMode(n *Node, mode string) (*Node, error) {
+	if n == nil {
+		return nil, nil
+	}
+	intervals := mode == database.ModeInterval
+	switch {
+	case n.Interval != nil && !intervals:
+		return nil, fmt.Errorf("интервал %s можно использовать только в режиме '%s'", n.Interval, database.ModeInterval)
+	case n.Tensor != nil && intervals:
+		if n.Tensor.Rank() != 1 || len(n.Tensor.Data) != 2 {
+			return nil, fmt.Errorf("в режиме интервалов [lo, hi] задает интервал, векторы и матрицы не поддерживаются: %s", n.Tensor)
+		}
+		i, err := interval.New(n.Tensor.Data[0], n.Tensor.Data[1])
+		if err != nil {
+			return nil, err
+		}
+		i = interval.Interval{Lo: interval.Decimal(i.Lo).Lo, Hi: interval.Decimal(i.Hi).Hi}
+		return &Node{Interval: &i}, nil
+	case n.Value != nil && intervals:
+		i := interval.Decimal(*n.Value)
+		return &Node{Interval: &i}, nil
+	case n.Complex != nil && intervals:
+		return nil, fmt.Errorf("комплексные числа в режиме интервалов не поддерживаются")
+	case binaryFunctions[n.Op] && intervals:
+		return nil, fmt.Errorf("функция '%s' в режиме интервалов не поддерживается", n.Op)
+	case n.IsLeaf():
+		return n, nil
+	}
+	left, err := applyMode(n.Left, mode)
+	if err != nil {
+		return nil, err
+	}
+	right, err := applyMode(n.Right, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Op: n.Op, Left: left, Right: right, Unit: n.Unit}, nil
+}
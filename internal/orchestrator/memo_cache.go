@@ -0,0 +1,150 @@
+package orchestrator
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Числовой режим, в котором считаются задачи. Входит в ключ кэша, чтобы результаты
+// разных режимов не смешивались.
+const defaultNumericMode = "real"
+
+type memoKey struct {
+	op   string
+	arg1 float64
+	arg2 float64
+	mode string
+}
+
+// newMemoKey строит ключ кэша; у коммутативных операций аргументы упорядочиваются,
+// так что 2*3 и 3*2 попадают в одну запись.
+func newMemoKey(op string, arg1, arg2 float64, mode string) memoKey {
+	if (op == "+" || op == "*") && arg2 < arg1 {
+		arg1, arg2 = arg2, arg1
+	}
+	return memoKey{op, arg1, arg2, mode}
+}
+
+type memoEntry struct {
+	key       memoKey
+	result    float64
+	expiresAt time.Time
+}
+
+// MemoCache хранит результаты выполненных задач между выражениями разных пользователей.
+// Вытесняются записи с истекшим TTL и самые давно использованные при превышении размера.
+type MemoCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[memoKey]*list.Element
+	lru     *list.List // Начало списка - недавно использованные записи
+	hits    int64
+	misses  int64
+}
+
+type MemoCacheStats struct {
+	Size    int   `json:"size"`
+	MaxSize int   `json:"max_size"`
+	TTLSec  int   `json:"ttl_seconds"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+// NewMemoCache создает кэш. maxSize <= 0 отключает кэширование, ttl <= 0 - записи без срока жизни.
+func NewMemoCache(ttl time.Duration, maxSize int) *MemoCache {
+	return &MemoCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[memoKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *MemoCache) Get(op string, arg1, arg2 float64, mode string) (float64, bool) {
+	if c.maxSize <= 0 {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[newMemoKey(op, arg1, arg2, mode)]
+	if !ok {
+		c.misses++
+		return 0, false
+	}
+	entry := el.Value.(*memoEntry)
+	if c.ttl > 0 && time.Now().After(entry.expiresAt) {
+		c.remove(el)
+		c.misses++
+		return 0, false
+	}
+	c.lru.MoveToFront(el)
+	c.hits++
+	return entry.result, true
+}
+
+// Peek ищет результат, не меняя статистику и порядок вытеснения: для предпросмотра плана.
+func (c *MemoCache) Peek(op string, arg1, arg2 float64, mode string) (float64, bool) {
+	if c.maxSize <= 0 {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[newMemoKey(op, arg1, arg2, mode)]
+	if !ok {
+		return 0, false
+	}
+	entry := el.Value.(*memoEntry)
+	if c.ttl > 0 && time.Now().After(entry.expiresAt) {
+		return 0, false
+	}
+	return entry.result, true
+}
+
+func (c *MemoCache) Put(op string, arg1, arg2 float64, mode string, result float64) {
+	if c.maxSize <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newMemoKey(op, arg1, arg2, mode)
+	expiresAt := time.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*memoEntry)
+		entry.result = result
+		entry.expiresAt = expiresAt
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	for c.lru.Len() >= c.maxSize {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&memoEntry{key: key, result: result, expiresAt: expiresAt})
+}
+
+func (c *MemoCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*memoEntry).key)
+}
+
+func (c *MemoCache) Stats() MemoCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return MemoCacheStats{
+		Size:    c.lru.Len(),
+		MaxSize: c.maxSize,
+		TTLSec:  int(c.ttl / time.Second),
+		Hits:    c.hits,
+		Misses:  c.misses,
+	}
+}
+
+func initMemoCache() *MemoCache {
+	ttl := time.Duration(readTimeEnv("MEMO_CACHE_TTL_SECONDS", 600)) * time.Second
+	return NewMemoCache(ttl, readTimeEnv("MEMO_CACHE_SIZE", 10000))
+}
@@ -0,0 +1,200 @@
+package orchestrator
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OptimizerConfig задает, какие преобразования дерева выполняются до планирования задач.
+type OptimizerConfig struct {
+	FoldOps                    map[string]bool // Операции, которые дешевле посчитать локально, чем отправлять агенту
+	EliminateIdentities        bool            // x+0, x*1, 0*x, x/1 и т.п.
+	DetectCommonSubexpressions bool            // Отмечать в шагах повторяющиеся поддеревья
+}
+
+// Optimizer упрощает AST между Parse и planTasksRecursive.
+// Результат детерминирован, поэтому одинаковое выражение всегда дает одинаковое дерево -
+// на этом держится сопоставление задач в ProcessTaskCompletion.
+type Optimizer struct {
+	cfg OptimizerConfig
+}
+
+func NewOptimizer(cfg OptimizerConfig) *Optimizer {
+	return &Optimizer{cfg: cfg}
+}
+
+// Optimize возвращает упрощенное дерево и список шагов с описанием выполненных преобразований.
+// Исходное дерево может быть изменено.
+func (o *Optimizer) Optimize(root *Node) (*Node, []string) {
+	var steps []string
+	root = o.optimize(root, &steps)
+	if o.cfg.DetectCommonSubexpressions {
+		steps = append(steps, commonSubexpressions(root)...)
+	}
+	return root, steps
+}
+
+func (o *Optimizer) optimize(n *Node, steps *[]string) *Node {
+	if n == nil || n.IsLeaf() {
+		return n
+	}
+	n.Left = o.optimize(n.Left, steps)
+	n.Right = o.optimize(n.Right, steps)
+
+	if n.Left != nil && n.Left.Value != nil && n.Right != nil && n.Right.Value != nil && o.cfg.FoldOps[n.Op] {
+		if v, ok := evalLocal(n.Op, *n.Left.Value, *n.Right.Value); ok {
+			folded := &Node{Value: &v, Unit: siUnit(n)}
+			*steps = append(*steps, strings.TrimSpace(fmt.Sprintf("Folded: %s = %v %s", n.String(), v, folded.Unit)))
+			return folded
+		}
+	}
+
+	if o.cfg.EliminateIdentities {
+		if r := eliminateIdentity(n); r != nil {
+			*steps = append(*steps, fmt.Sprintf("Simplified: %s -> %s", n.String(), r.String()))
+			return r
+		}
+	}
+	return n
+}
+
+// evalLocal вычисляет операцию на оркестраторе. Деление на ноль и переполнение не сворачиваются,
+// чтобы ошибка (или Inf в политике ieee) возникла там же, где и без оптимизации.
+// При переполнении возвращается и само значение.
+func evalLocal(op string, a, b float64) (float64, bool) {
+	var v float64
+	switch op {
+	case "+":
+		v = a + b
+	case "-":
+		v = a - b
+	case "*":
+		v = a * b
+	case "/":
+		if b == 0 {
+			return 0, false
+		}
+		v = a / b
+	default:
+		return 0, false
+	}
+	return v, isFinite(v)
+}
+
+func isFinite(v float64) bool {
+	return !math.IsInf(v, 0) && !math.IsNaN(v)
+}
+
+func isConst(n *Node, v float64) bool {
+	return n != nil && n.Value != nil && *n.Value == v
+}
+
+func eliminateIdentity(n *Node) *Node {
+	switch n.Op {
+	case "+":
+		if isConst(n.Left, 0) {
+			return n.Right
+		}
+		if isConst(n.Right, 0) {
+			return n.Left
+		}
+	case "-":
+		if isConst(n.Right, 0) {
+			return n.Left
+		}
+	case "*":
+		if isConst(n.Left, 1) {
+			return n.Right
+		}
+		if isConst(n.Right, 1) {
+			return n.Left
+		}
+		// 0*x заменяем нулем, только если x не может завершиться ошибкой и это не вектор:
+		// 0*[1,2] - нулевой вектор, а не число.
+		if hasTensor(n) {
+			break
+		}
+		if (isConst(n.Left, 0) && !canFail(n.Right)) || (isConst(n.Right, 0) && !canFail(n.Left)) {
+			zero := 0.0
+			return &Node{Value: &zero, Unit: siUnit(n)}
+		}
+	case "/":
+		if isConst(n.Right, 1) {
+			return n.Left
+		}
+	}
+	return nil
+}
+
+// canFail сообщает, может ли вычисление поддерева завершиться ошибкой или дать не конечное
+// число: кроме деления на ноль и корня из отрицательного, любая операция может переполниться
+// (1e308*10), а 0*Inf - это NaN, а не 0.
+func canFail(n *Node) bool {
+	if n == nil {
+		return false
+	}
+	if n.IsLeaf() {
+		return n.Value != nil && !isFinite(*n.Value)
+	}
+	return true
+}
+
+// commonSubexpressions находит операции, встречающиеся в дереве более одного раза.
+func commonSubexpressions(root *Node) []string {
+	counts := make(map[string]int)
+	var order []string
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil || n.IsLeaf() {
+			return
+		}
+		walk(n.Left)
+		walk(n.Right)
+		key := n.String()
+		if counts[key] == 0 {
+			order = append(order, key)
+		}
+		counts[key]++
+	}
+	walk(root)
+
+	var steps []string
+	for _, key := range order {
+		if counts[key] > 1 {
+			steps = append(steps, fmt.Sprintf("Common subexpression: %s x%d", key, counts[key]))
+		}
+	}
+	return steps
+}
+
+func initOptimizerConfig() OptimizerConfig {
+	cfg := OptimizerConfig{
+		FoldOps:                    make(map[string]bool),
+		EliminateIdentities:        readBoolEnv("OPTIMIZER_IDENTITIES", true),
+		DetectCommonSubexpressions: readBoolEnv("OPTIMIZER_CSE", true),
+	}
+	ops := "+,-,*"
+	if v, ok := os.LookupEnv("OPTIMIZER_FOLD_OPS"); ok {
+		ops = v
+	}
+	for _, op := range strings.Split(ops, ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			cfg.FoldOps[op] = true
+		}
+	}
+	return cfg
+}
+
+func readBoolEnv(key string, defaultValue bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		} else {
+			fmt.Printf("Предупреждение: Неверное значение для %s ('%s'), используется значение по умолчанию %t\n", key, v, defaultValue)
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,46 @@
+package orchestrator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptimizer(t *testing.T) {
+	cfg := OptimizerConfig{
+		FoldOps:                    map[string]bool{"+": true, "-": true, "*": true},
+		EliminateIdentities:        true,
+		DetectCommonSubexpressions: true,
+	}
+	tests := []struct {
+		input, want string
+		steps       int
+	}{
+		{"2*3", "6", 1},
+		{"(2*3)/(1+1)", "(6/2)", 2},
+		{"(1/3)*1", "(1/3)", 1},
+		{"0*(4/2)", "(0*(4/2))", 0},
+		{"(1/3)+0", "(1/3)", 1},
+		{"5/0", "(5/0)", 0},
+		{"0*(" + strings.Repeat("9", 308) + "*10)", "(0*(1e+308*10))", 0}, // Переполнение не сворачивается
+		{"(8/2)+(8/2)", "((8/2)+(8/2))", 1},
+	}
+	for _, tc := range tests {
+		ast, err := NewParser(tc.input).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", tc.input, err)
+		}
+		got, steps := NewOptimizer(cfg).Optimize(ast)
+		if got.String() != tc.want {
+			t.Errorf("Optimize(%q) = %q, want %q", tc.input, got.String(), tc.want)
+		}
+		if len(steps) != tc.steps {
+			t.Errorf("Optimize(%q) steps = %q, want %d steps", tc.input, steps, tc.steps)
+		}
+	}
+
+	ast, _ := NewParser("2*3").Parse()
+	got, steps := NewOptimizer(OptimizerConfig{}).Optimize(ast)
+	if got.String() != "(2*3)" || len(steps) != 0 {
+		t.Errorf("disabled optimizer changed tree: %q, steps %q", got.String(), steps)
+	}
+}
@@ -1,22 +1,93 @@
 package orchestrator
 
 import (
+	"calculator/internal/interval"
+	"calculator/internal/tensor"
+	"calculator/internal/units"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
 
 type Node struct {
-	Op    string   // Операция (+, -, *, /) или пустая строка для числа
-	Value *float64 // Значение, если узел - число (лист дерева)
-	Left  *Node    // Левый дочерний узел
-	Right *Node    // Правый дочерний узел
+	Op       string             // Операция (+, -, *, /, ^), имя функции (sin, dot, ...) или пустая строка для листа
+	Value    *float64           // Значение, если узел - число (лист дерева)
+	Tensor   *tensor.Tensor     // Значение, если узел - вектор или матрица (лист дерева)
+	Complex  *complex128        // Значение, если узел - комплексное число (лист дерева)
+	Interval *interval.Interval // Значение в режиме интервалов, если узел - интервал [lo, hi] (лист дерева)
+	Var      string             // Имя переменной, если узел - переменная (лист дерева)
+	Unit     string             // Единица измерения числа ("km/h") или целевая единица перевода (узел "in")
+	Left     *Node              // Левый дочерний узел (аргумент функции)
+	Right    *Node              // Правый дочерний узел (nil у функций одного аргумента)
+}
+
+// unaryFunctions - функции одного аргумента, которые понимают парсер и агенты.
+var unaryFunctions = map[string]bool{
+	"sin":  true,
+	"cos":  true,
+	"tan":  true,
+	"exp":  true,
+	"ln":   true,
+	"sqrt": true,
+	"abs":  true,
+	// median от числа - само число, от вектора - медиана его элементов.
+	"median": true,
+}
+
+// binaryFunctions - функции двух аргументов, записываемые как вызов: dot(u, v).
+// merge объединяет числа и векторы в один упорядоченный вектор.
+var binaryFunctions = map[string]bool{
+	"dot":   true,
+	"merge": true,
+}
+
+// IsFunction сообщает, является ли узел вызовом функции одного аргумента.
+func (n *Node) IsFunction() bool {
+	return n != nil && unaryFunctions[n.Op]
+}
+
+// IsLeaf сообщает, является ли узел числом, вектором/матрицей, интервалом или переменной.
+func (n *Node) IsLeaf() bool {
+	return n.Value != nil || n.Tensor != nil || n.Complex != nil || n.Interval != nil || n.Var != ""
+}
+
+// hasTensor сообщает, есть ли в поддереве векторы или матрицы.
+func hasTensor(n *Node) bool {
+	if n == nil {
+		return false
+	}
+	return n.Tensor != nil || hasTensor(n.Left) || hasTensor(n.Right)
 }
 
 type Parser struct {
-	input string
-	pos   int
-	ch    byte
+	input   string
+	pos     int
+	ch      byte
+	lenient bool // Нестрогий режим: неявное умножение и Unicode-операторы
+	lastTok byte // Вид последнего разобранного множителя: 'n' - число, ')' - скобка, 'i' - идентификатор
+
+	functions map[string]UserFunction // Функции пользователя, вызовы которых раскрываются при разборе
+	callStack []string                // Функции пользователя, тело которых сейчас разбирается
+	variables map[string]bool         // Имена, связанные в текущем контексте: они не считаются единицами
+}
+
+// WithFunctions подключает функции пользователя: вызов tax(1000) заменяется телом функции
+// с подставленными аргументами.
+func (p *Parser) WithFunctions(functions map[string]UserFunction) *Parser {
+	p.functions = functions
+	return p
+}
+
+// WithVariables объявляет имена, связанные в текущем контексте (переменные перебора, параметры
+// функции, now): после числа такое имя - переменная, а не единица измерения, и "2h" в нестрогом
+// режиме означает 2*h.
+func (p *Parser) WithVariables(names ...string) *Parser {
+	p.variables = make(map[string]bool, len(names))
+	for _, name := range names {
+		p.variables[name] = true
+	}
+	return p
 }
 
 func NewParser(input string) *Parser {
@@ -25,6 +96,27 @@ func NewParser(input string) *Parser {
 	return p
 }
 
+// NewLenientParser создает парсер в нестрогом режиме: Unicode-операторы (×, ÷, −, π)
+// приводятся к ASCII, а соседние множители без оператора (2(3+4), 3π) перемножаются.
+func NewLenientParser(input string) *Parser {
+	p := &Parser{input: unicodeOperators.Replace(input), pos: -1, lenient: true}
+	p.next()
+	return p
+}
+
+var unicodeOperators = strings.NewReplacer(
+	"×", "*",
+	"·", "*",
+	"⋅", "*",
+	"∙", "*",
+	"÷", "/",
+	"∕", "/",
+	"−", "-",
+	"–", "-",
+	"π", "pi",
+	"\u00a0", " ",
+)
+
 
 func (p *Parser) next() {
 	p.pos++
@@ -55,20 +147,76 @@ func (p *Parser) Parse() (*Node, error) {
 	}
 
 	p.skipWhitespace()
+	if node != nil && p.peekWord() == "in" {
+		// Перевод результата в другие единицы: "60 mph in km/h".
+		p.seek(p.pos + 2)
+		unit, err := p.parseUnit()
+		if err != nil {
+			return nil, err
+		}
+		if unit == "" {
+			return nil, fmt.Errorf("ожидалась единица измерения после 'in'")
+		}
+		node = &Node{Op: "in", Left: node, Unit: unit}
+		p.skipWhitespace()
+	}
 	if p.ch != 0 {
 		return nil, fmt.Errorf("неожиданный символ '%c' в конце выражения", p.ch)
 	}
 
+	// Размерности проверяются сразу, чтобы "3 m + 2 s" не доходило до планировщика.
+	// Единицы результатов по ссылкам ($42) известны только при планировании, там их проверит toSI.
+	if len(References(node)) == 0 {
+		if _, err := Dimension(node); err != nil {
+			return nil, err
+		}
+	}
 	return node, nil
 }
 
+// ParseEquation разбирает уравнение "lhs = rhs". Без знака '=' правая часть считается нулем.
+func (p *Parser) ParseEquation() (*Node, *Node, error) {
+	if len(strings.TrimSpace(p.input)) == 0 {
+		return nil, nil, fmt.Errorf("пустое уравнение")
+	}
+	p.pos = -1
+	p.next()
+
+	lhs, err := p.parseExpression()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.skipWhitespace()
+	zero := 0.0
+	rhs := &Node{Value: &zero}
+	if p.ch == '=' {
+		p.next()
+		if rhs, err = p.parseExpression(); err != nil {
+			return nil, nil, err
+		}
+		if rhs == nil {
+			return nil, nil, fmt.Errorf("ожидалась правая часть уравнения после '='")
+		}
+		p.skipWhitespace()
+	}
+	if p.ch != 0 {
+		return nil, nil, fmt.Errorf("неожиданный символ '%c' в конце уравнения", p.ch)
+	}
+	if f := binary("-", lhs, rhs); len(References(f)) == 0 {
+		if _, err := Dimension(f); err != nil {
+			return nil, nil, err
+		}
+	}
+	return lhs, rhs, nil
+}
+
 func (p *Parser) parseExpression() (*Node, error) {
 	left, err := p.parseTerm()
 	if err != nil {
 		return nil, err
 	}
 	if p.pos < len(p.input) && left == nil {
-		if p.ch == '+' || p.ch == '-' || p.ch == '/' || p.ch == '*' || p.ch == ')' {
+		if p.ch == '+' || p.ch == '-' || p.ch == '/' || p.ch == '*' || p.ch == '^' || p.ch == ')' {
 			return nil, fmt.Errorf("ожидался операнд перед '%c'", p.ch)
 		}
 		return nil, fmt.Errorf("некорректное выражение, ожидался операнд")
@@ -86,6 +234,11 @@ func (p *Parser) parseExpression() (*Node, error) {
 			if right == nil {
 				return nil, fmt.Errorf("ожидался операнд после '%s'", op)
 			}
+			left = lowerPercent(left)
+			if right.Op == "%" {
+				// a + b% = a + a*b/100: процент берется от левого операнда.
+				right = binary("/", binary("*", Substitute(left, nil), right.Left), num(100))
+			}
 			left = &Node{
 				Op:    op,
 				Left:  left,
@@ -95,7 +248,21 @@ func (p *Parser) parseExpression() (*Node, error) {
 			break
 		}
 	}
-	return left, nil
+	return lowerPercent(left), nil
+}
+
+// percent помечает операнд с постфиксным '%'. Смысл зависит от контекста и определяется
+// при сборке выражения: справа от '+' или '-' это доля левого операнда, в остальных
+// случаях - просто b/100 (см. lowerPercent). Агентам такие узлы не попадают.
+func percent(n *Node) *Node {
+	return &Node{Op: "%", Left: n}
+}
+
+func lowerPercent(n *Node) *Node {
+	if n != nil && n.Op == "%" {
+		return binary("/", n.Left, num(100))
+	}
+	return n
 }
 
 func (p *Parser) parseTerm() (*Node, error) {
@@ -106,9 +273,12 @@ func (p *Parser) parseTerm() (*Node, error) {
 
 	for {
 		p.skipWhitespace()
-		if p.ch == '*' || p.ch == '/' {
-			op := string(p.ch)
-			p.next()
+		if p.ch == '*' || p.ch == '/' || p.implicitMultiplication() {
+			op := "*"
+			if p.ch == '/' || p.ch == '*' {
+				op = string(p.ch)
+				p.next()
+			}
 			right, err := p.parseFactor()
 			if err != nil {
 				return nil, err
@@ -116,6 +286,8 @@ func (p *Parser) parseTerm() (*Node, error) {
 			if right == nil {
 				return nil, fmt.Errorf("ожидался операнд после '%s'", op)
 			}
+			// 50 * 10% = 50 * 0.1: в произведении процент - обычное число.
+			left, right = lowerPercent(left), lowerPercent(right)
 			left = &Node{
 				Op:    op,
 				Left:  left,
@@ -128,6 +300,27 @@ func (p *Parser) parseTerm() (*Node, error) {
 	return left, nil
 }
 
+// implicitMultiplication сообщает, начинается ли в текущей позиции множитель,
+// который в нестрогом режиме умножается на предыдущий без явного '*'.
+// Два числа подряд ("2 3") по-прежнему считаются ошибкой.
+func (p *Parser) implicitMultiplication() bool {
+	if !p.lenient {
+		return false
+	}
+	switch {
+	case p.ch == '(' || isLetter(p.ch):
+		return p.peekWord() != "in"
+	case (p.ch >= '0' && p.ch <= '9') || p.ch == '.' || p.ch == '$':
+		return p.lastTok != 'n'
+	}
+	return false
+}
+
+func isLetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_'
+}
+
+// parseFactor разбирает унарный минус. Он связывает слабее степени: -2^2 = -(2^2).
 func (p *Parser) parseFactor() (*Node, error) {
 	p.skipWhitespace()
 
@@ -140,19 +333,65 @@ func (p *Parser) parseFactor() (*Node, error) {
 		if factor == nil {
 			return nil, fmt.Errorf("ожидался операнд после унарного минуса")
 		}
-		if factor.Value != nil {
-			*factor.Value = -(*factor.Value)
-			return factor, nil
-		} else {
-			minusOne := -1.0
-			return &Node{
-				Op:    "*",
-				Left:  &Node{Value: &minusOne},
-				Right: factor,
-			}, nil
+		if factor.Op == "%" {
+			return percent(negate(factor.Left)), nil // -5% остается процентом: 200 + -5% = 190
 		}
+		return negate(factor), nil
 	}
 
+	return p.parsePower()
+}
+
+func negate(factor *Node) *Node {
+	if factor.Value != nil {
+		*factor.Value = -(*factor.Value)
+		return factor
+	}
+	if factor.Complex != nil {
+		*factor.Complex = -(*factor.Complex)
+		return factor
+	}
+	minusOne := -1.0
+	return &Node{
+		Op:    "*",
+		Left:  &Node{Value: &minusOne},
+		Right: factor,
+	}
+}
+
+// parsePower разбирает возведение в степень; оно правоассоциативно: 2^3^2 = 2^(3^2).
+// Постфиксный '%' относится к ближайшему операнду и связывает сильнее степени.
+func (p *Parser) parsePower() (*Node, error) {
+	base, err := p.parsePrimary()
+	if err != nil || base == nil {
+		return base, err
+	}
+
+	p.skipWhitespace()
+	if p.ch == '%' {
+		p.next()
+		base = percent(base)
+		p.skipWhitespace()
+	}
+	if p.ch != '^' {
+		return base, nil
+	}
+	base = lowerPercent(base)
+	p.next()
+	exponent, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	exponent = lowerPercent(exponent)
+	if exponent == nil {
+		return nil, fmt.Errorf("ожидался операнд после '^'")
+	}
+	return &Node{Op: "^", Left: base, Right: exponent}, nil
+}
+
+func (p *Parser) parsePrimary() (*Node, error) {
+	p.skipWhitespace()
+
 	if p.ch == '(' {
 		p.next()
 		node, err := p.parseExpression() // Рекурсия для выражения в скобках
@@ -164,9 +403,22 @@ func (p *Parser) parseFactor() (*Node, error) {
 			return nil, fmt.Errorf("ожидалась ')', получено '%c'", p.ch)
 		}
 		p.next()
+		p.lastTok = ')'
 		return node, nil
 	}
 
+	if isLetter(p.ch) {
+		return p.parseIdentifier()
+	}
+
+	if p.ch == '$' {
+		return p.parseReference()
+	}
+
+	if p.ch == '[' {
+		return p.parseTensor()
+	}
+
 	start := p.pos
 	hasDecimal := false
 	for (p.ch >= '0' && p.ch <= '9') || p.ch == '.' {
@@ -188,7 +440,258 @@ func (p *Parser) parseFactor() (*Node, error) {
 	if err != nil {
 		return nil, fmt.Errorf("ошибка преобразования '%s' в число: %w", numStr, err)
 	}
-	return &Node{Value: &val}, nil
+	if p.peekWord() == "i" {
+		// Мнимое число записывается слитно с коэффициентом: 4i, 1i. Отдельное "i" - обычная переменная.
+		p.next()
+		p.lastTok = 'n'
+		c := complex(0, val)
+		return &Node{Complex: &c}, nil
+	}
+	unit, err := p.parseUnit()
+	if err != nil {
+		return nil, err
+	}
+	p.lastTok = 'n'
+	return &Node{Value: &val, Unit: unit}, nil
+}
+
+// parseUnit разбирает единицу измерения после числа: "5 km", "9.8 m/s^2", "3 kg*m".
+// Если в текущей позиции нет известной единицы, позиция не меняется и возвращается пустая строка.
+// Имя единицы имеет приоритет над свободной переменной с тем же именем, но не над связанной (WithVariables).
+func (p *Parser) parseUnit() (string, error) {
+	var unit strings.Builder
+	for {
+		save := p.pos
+		p.skipWhitespace()
+		if unit.Len() > 0 {
+			if p.ch != '*' && p.ch != '/' {
+				p.seek(save)
+				break
+			}
+			op := p.ch
+			p.next()
+			p.skipWhitespace()
+			if !p.isUnit(p.peekWord()) {
+				p.seek(save) // "6 m / 2": деление, а не часть единицы
+				break
+			}
+			unit.WriteByte(op)
+		}
+		name := p.peekWord()
+		if !p.isUnit(name) {
+			p.seek(save)
+			break
+		}
+		p.seek(p.pos + len(name))
+		unit.WriteString(name)
+
+		if p.ch == '^' {
+			powStart := p.pos
+			p.next()
+			digits := p.pos
+			if p.ch == '-' {
+				p.next()
+			}
+			for p.ch >= '0' && p.ch <= '9' {
+				p.next()
+			}
+			if p.pos == digits || p.input[p.pos-1] == '-' {
+				p.seek(powStart) // "(5 m)^x": степень всей величины, а не единицы
+			} else {
+				unit.WriteString(p.input[powStart:p.pos])
+			}
+		}
+	}
+	if unit.Len() == 0 {
+		return "", nil
+	}
+	if _, err := units.Parse(unit.String()); err != nil {
+		return "", err
+	}
+	return unit.String(), nil
+}
+
+func (p *Parser) isUnit(name string) bool {
+	return units.IsUnit(name) && !p.variables[name]
+}
+
+// peekWord возвращает идентификатор, начинающийся в текущей позиции, не сдвигая ее.
+func (p *Parser) peekWord() string {
+	if p.pos >= len(p.input) || !isLetter(p.ch) {
+		return ""
+	}
+	end := p.pos
+	for end < len(p.input) && (isLetter(p.input[end]) || (p.input[end] >= '0' && p.input[end] <= '9')) {
+		end++
+	}
+	return p.input[p.pos:end]
+}
+
+// seek переводит разбор в позицию pos.
+func (p *Parser) seek(pos int) {
+	p.pos = pos - 1
+	p.next()
+}
+
+// parseIdentifier разбирает константу pi, вызов функции f(...) или переменную.
+func (p *Parser) parseIdentifier() (*Node, error) {
+	start := p.pos
+	for isLetter(p.ch) || (p.ch >= '0' && p.ch <= '9') {
+		p.next()
+	}
+	name := p.input[start:p.pos]
+	p.lastTok = 'i'
+
+	if strings.ToLower(name) == "pi" {
+		val := math.Pi
+		return &Node{Value: &val}, nil
+	}
+
+	p.skipWhitespace()
+	if p.ch != '(' {
+		return &Node{Var: name}, nil
+	}
+	if name == nowVariable {
+		// now() - переменная now, ее значение подставляет расписание при срабатывании.
+		p.next()
+		p.skipWhitespace()
+		if p.ch != ')' {
+			return nil, fmt.Errorf("функция '%s' не принимает аргументов", name)
+		}
+		p.next()
+		p.lastTok = ')'
+		return &Node{Var: name}, nil
+	}
+	// Встроенные функции имеют приоритет над функциями пользователя.
+	arity := 1
+	var userFn UserFunction
+	isUser := false
+	switch {
+	case binaryFunctions[name]:
+		arity = 2
+	case aggregateFunctions[name]:
+		arity = -1 // Любое число аргументов
+	case unaryFunctions[name]:
+	default:
+		if userFn, isUser = p.functions[name]; !isUser {
+			return nil, fmt.Errorf("неизвестная функция '%s'", name)
+		}
+		arity = len(userFn.Params)
+	}
+	p.next()
+
+	var args []*Node
+	p.skipWhitespace()
+	for arity != 0 || p.ch != ')' {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if arg == nil {
+			return nil, fmt.Errorf("ожидался аргумент функции '%s'", name)
+		}
+		args = append(args, arg)
+		p.skipWhitespace()
+		if p.ch != ',' || len(args) == arity {
+			break
+		}
+		p.next()
+	}
+	if arity >= 0 && len(args) != arity {
+		return nil, fmt.Errorf("функция '%s' принимает аргументов: %d, передано: %d", name, arity, len(args))
+	}
+	if p.ch != ')' {
+		return nil, fmt.Errorf("ожидалась ')' после аргумента функции '%s'", name)
+	}
+	p.next()
+	p.lastTok = ')'
+	if arity < 0 {
+		return expandAggregate(name, args), nil
+	}
+	if isUser {
+		return p.expandUserFunction(name, userFn, args)
+	}
+	node := &Node{Op: name, Left: args[0]}
+	if arity == 2 {
+		node.Right = args[1]
+	}
+	return node, nil
+}
+
+// parseTensor разбирает литерал вектора [1, 2, 3] или матрицы [[1, 2], [3, 4]].
+// Элементы - числа (возможно, отрицательные); вычисляемые элементы не поддерживаются.
+func (p *Parser) parseTensor() (*Node, error) {
+	var parseList func() (interface{}, error)
+	parseList = func() (interface{}, error) {
+		p.skipWhitespace()
+		if p.ch == '[' {
+			p.next()
+			var list []interface{}
+			for {
+				elem, err := parseList()
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, elem)
+				p.skipWhitespace()
+				if p.ch == ']' {
+					p.next()
+					return list, nil
+				}
+				if p.ch != ',' {
+					return nil, fmt.Errorf("ожидалась ',' или ']' в литерале вектора")
+				}
+				p.next()
+			}
+		}
+		sign := 1.0
+		if p.ch == '-' {
+			sign = -1
+			p.next()
+			p.skipWhitespace()
+		}
+		start := p.pos
+		for (p.ch >= '0' && p.ch <= '9') || p.ch == '.' {
+			p.next()
+		}
+		val, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("элемент вектора должен быть числом")
+		}
+		return sign * val, nil
+	}
+
+	nested, err := parseList()
+	if err != nil {
+		return nil, err
+	}
+	t, err := tensor.FromNested(nested)
+	if err != nil {
+		return nil, err
+	}
+	p.lastTok = ')'
+	return &Node{Tensor: t}, nil
+}
+
+// FreeVariables возвращает имена переменных выражения в порядке первого появления.
+// Ссылки на результаты выражений ($42) переменными не считаются.
+func FreeVariables(n *Node) []string {
+	var vars []string
+	seen := make(map[string]bool)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil {
+			return
+		}
+		if n.Var != "" && !isReference(n.Var) && !seen[n.Var] {
+			seen[n.Var] = true
+			vars = append(vars, n.Var)
+		}
+		walk(n.Left)
+		walk(n.Right)
+	}
+	walk(n)
+	return vars
 }
 
 func (n *Node) String() string {
@@ -198,10 +701,39 @@ func (n *Node) String() string {
 	if n.Value != nil {
 		v := *n.Value
 		s := fmt.Sprintf("%v", v)
+		if n.Unit != "" {
+			// Величина всегда в скобках: иначе "(5 m)^2" и "5 m^2" дали бы один ключ.
+			return "(" + s + " " + n.Unit + ")"
+		}
 		if v < 0 {
 			return "(" + s + ")"
 		}
 		return s
 	}
+	if n.Tensor != nil {
+		return n.Tensor.String()
+	}
+	if n.Complex != nil {
+		s := formatComplex(*n.Complex, DefaultPrintOptions)
+		if real(*n.Complex) != 0 || imag(*n.Complex) < 0 {
+			return "(" + s + ")"
+		}
+		return s
+	}
+	if n.Interval != nil {
+		return n.Interval.String()
+	}
+	if n.Var != "" {
+		return n.Var
+	}
+	if n.IsFunction() {
+		return fmt.Sprintf("%s(%s)", n.Op, n.Left.String())
+	}
+	if n.Op == "in" {
+		return n.Left.String() + " in " + n.Unit
+	}
+	if binaryFunctions[n.Op] {
+		return fmt.Sprintf("%s(%s,%s)", n.Op, n.Left.String(), n.Right.String())
+	}
 	return fmt.Sprintf("(%s%s%s)", n.Left.String(), n.Op, n.Right.String())
 }
\ No newline at end of file
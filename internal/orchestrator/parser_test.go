@@ -1,6 +1,7 @@
 package orchestrator
 
 import (
+	"math"
 	"testing"
 )
 
@@ -11,6 +12,25 @@ func TestParserAST(t *testing.T) {
 		{"-5+10", "((-5)+10)"},
 		{"4*(3-1)", "(4*(3-1))"},
 		{" 7 - 2 / 1 ", "(7-(2/1))"},
+		{"2^3^2", "(2^(3^2))"},
+		{"-2^2", "((-1)*(2^2))"},
+		{"(-2)^2", "((-2)^2)"},
+		{"2*x^2", "(2*(x^2))"},
+		{"sin(x)+cos(2*pi)", "(sin(x)+cos((2*3.141592653589793)))"},
+		{"[1, 2, 3] * 2", "([1,2,3]*2)"},
+		{"[[1,2],[-3,4.5]]", "[[1,2],[-3,4.5]]"},
+		{"dot([1,2], [3,4]) + 1", "(dot([1,2],[3,4])+1)"},
+		{"sum(1, 2, 3, 4)", "((1+2)+(3+4))"},
+		{"avg(1, 2, 3)", "((1+(2+3))/3)"},
+		{"median(3, 1, 2)", "median(merge(3,merge(1,2)))"},
+		{"stddev(1, 3)", "sqrt(((((1-((1+3)/2))^2)+((3-((1+3)/2))^2))/2))"},
+		{"5 km + 300 m", "((5 km)+(300 m))"},
+		{"9.8 m/s^2 * 2 s", "((9.8 m/s^2)*(2 s))"},
+		{"6 m / 2", "((6 m)/2)"},
+		{"-3 kg", "(-3 kg)"},
+		{"60 mph in km/h", "(60 mph) in km/h"},
+		{"(5 m)^2", "((5 m)^2)"},
+		{"5 m^2", "(5 m^2)"},
 	}
 	for _, tc := range tests {
 		p := NewParser(tc.input)
@@ -24,4 +44,356 @@ func TestParserAST(t *testing.T) {
 			t.Errorf("Parse(%q).String() = %q, want %q", tc.input, got, tc.want)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestLenientParser(t *testing.T) {
+	tests := []struct{ input, want string }{
+		{"2(3+4)", "(2*(3+4))"},
+		{"(1+2)(3+4)", "((1+2)*(3+4))"},
+		{"3π", "(3*3.141592653589793)"},
+		{"6 × 7", "(6*7)"},
+		{"8 ÷ 2", "(8/2)"},
+		{"5 − 3", "(5-3)"},
+		{"-2(3)", "((-2)*3)"},
+		{"(2)3", "(2*3)"},
+		{"2*3", "(2*3)"},
+	}
+	for _, tc := range tests {
+		node, err := NewLenientParser(tc.input).Parse()
+		if err != nil {
+			t.Errorf("lenient Parse(%q) returned error: %v", tc.input, err)
+			continue
+		}
+		got := node.String()
+		if got != tc.want {
+			t.Errorf("lenient Parse(%q).String() = %q, want %q", tc.input, got, tc.want)
+		}
+		if _, err := NewParser(got).Parse(); err != nil {
+			t.Errorf("canonical form %q of %q is not accepted by strict parser: %v", got, tc.input, err)
+		}
+	}
+
+	for _, input := range []string{"2(3+4)", "6 × 7", "3π", "2 3"} {
+		if _, err := NewParser(input).Parse(); err == nil {
+			t.Errorf("strict Parse(%q) expected error", input)
+		}
+	}
+	if _, err := NewLenientParser("2 3").Parse(); err == nil {
+		t.Error("lenient Parse(\"2 3\") expected error for adjacent numbers")
+	}
+
+	// Связанные переменные важнее имен единиц: 2h - это 2*h.
+	for input, want := range map[string]string{"2h": "(2*h)", "3 s + 1": "((3*s)+1)", "2 m": "(2*m)"} {
+		node, err := NewLenientParser(input).WithVariables("h", "s", "m").Parse()
+		if err != nil {
+			t.Errorf("lenient Parse(%q) with bound variables returned error: %v", input, err)
+			continue
+		}
+		if got := node.String(); got != want {
+			t.Errorf("lenient Parse(%q) with bound variables = %q, want %q", input, got, want)
+		}
+	}
+	if node, err := NewLenientParser("2h").Parse(); err != nil || node.Unit != "h" {
+		t.Errorf("lenient Parse(\"2h\") without bound h = %+v, %v; want quantity in hours", node, err)
+	}
+}
+
+func TestPrettyPrinter(t *testing.T) {
+	tests := []struct{ input, want string }{
+		{"((2+3)*4)", "(2+3)*4"},
+		{"2+3*4", "2+3*4"},
+		{"1-(2-3)", "1-(2-3)"},
+		{"(1-2)-3", "1-2-3"},
+		{"8/(4/2)", "8/(4/2)"},
+		{"2*(3*4)", "2*(3*4)"},
+		{"2*-3", "2*(-3)"},
+		{"-5+10", "-5+10"},
+		{"2.50*1", "2.5*1"},
+		{"(2^3)^2", "(2^3)^2"},
+		{"2^3^2", "2^(3^2)"},
+		{"dot([1,2],[3,4])*[[1,0],[0,1]]", "dot([1,2],[3,4])*[[1,0],[0,1]]"},
+		{"(-2)^2", "(-2)^2"},
+		{"sqrt(x+1)*2", "sqrt(x+1)*2"},
+		{"(5 km + 300 m) in mi", "5 km+300 m in mi"},
+	}
+	for _, tc := range tests {
+		ast, err := NewParser(tc.input).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", tc.input, err)
+		}
+		got := ast.Pretty()
+		if got != tc.want {
+			t.Errorf("Pretty(%q) = %q, want %q", tc.input, got, tc.want)
+		}
+		back, err := NewParser(got).Parse()
+		if err != nil || back.String() != ast.String() {
+			t.Errorf("Pretty(%q) = %q does not round-trip: %v", tc.input, got, err)
+		}
+	}
+
+	ast, _ := NewParser("(2+3)*0.5").Parse()
+	opts := PrintOptions{Spaces: true, NumberFormat: 'f', Precision: 2}
+	if got, want := ast.Format(opts), "(2.00 + 3.00) * 0.50"; got != want {
+		t.Errorf("Format = %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	same := [][]string{
+		{"2+3", "3+2", "(3+2)"},
+		{"1+2+3", "3+(2+1)", "2+3+1"},
+		{"2*(3+4)", "(4+3)*2"},
+		{"10-2.50", "10.0-2.5"},
+	}
+	for _, group := range same {
+		var first string
+		for i, input := range group {
+			ast, err := NewParser(input).Parse()
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", input, err)
+			}
+			key := CanonicalKey(ast)
+			if i == 0 {
+				first = key
+			} else if key != first {
+				t.Errorf("CanonicalKey(%q) = %q, want %q", input, key, first)
+			}
+		}
+	}
+
+	a, _ := NewParser("10-2").Parse()
+	b, _ := NewParser("2-10").Parse()
+	if CanonicalKey(a) == CanonicalKey(b) {
+		t.Error("subtraction must not be treated as commutative")
+	}
+}
+
+func TestParseEquation(t *testing.T) {
+	tests := []struct {
+		input    string
+		lhs, rhs string
+		wantErr  bool
+	}{
+		{input: "x*x = 2", lhs: "(x*x)", rhs: "2"},
+		{input: "sin(x)", lhs: "sin(x)", rhs: "0"},
+		{input: "x = ", wantErr: true},
+		{input: "x = 1 = 2", wantErr: true},
+	}
+	for _, tt := range tests {
+		lhs, rhs, err := NewParser(tt.input).ParseEquation()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseEquation(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (lhs.String() != tt.lhs || rhs.String() != tt.rhs) {
+			t.Errorf("ParseEquation(%q) = %s, %s; want %s, %s", tt.input, lhs, rhs, tt.lhs, tt.rhs)
+		}
+	}
+}
+
+func TestParserTensorErrors(t *testing.T) {
+	for _, input := range []string{"[1,2", "[[1,2],[3]]", "[]", "[1+2]", "dot([1,2])", "[[[1]]]", "sum()", "sum(1,)", "dot(1,2,3)"} {
+		if _, err := NewParser(input).Parse(); err == nil {
+			t.Errorf("Parse(%q): expected error", input)
+		}
+	}
+}
+
+func TestParserUnits(t *testing.T) {
+	tests := []struct {
+		input, unit string
+	}{
+		{"5 km + 300 m", "m"},
+		{"60 mph in km/h", "km/h"},
+		{"10 N * 2 m", "kg*m^2/s^2"},
+		{"sqrt(16 m^2)", "m"},
+		{"(3 m)^2 / 2 s", "m^2/s"},
+		{"2 * 3", ""},
+	}
+	for _, tc := range tests {
+		ast, err := NewLenientParser(tc.input).Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tc.input, err)
+			continue
+		}
+		if _, unit, err := toSI(ast); err != nil || unit != tc.unit {
+			t.Errorf("toSI(%q) unit = %q, %v; want %q", tc.input, unit, err, tc.unit)
+		}
+	}
+
+	for _, input := range []string{"3 m + 2 s", "60 mph in kg", "sin(2 m)", "2^(3 s)", "(2 m)^x", "5 m in", "sqrt(2 s)"} {
+		if _, err := NewParser(input).Parse(); err == nil {
+			t.Errorf("Parse(%q): expected dimension error", input)
+		}
+	}
+}
+
+// evalNode вычисляет дерево без переменных прямо в тесте.
+func evalNode(t *testing.T, n *Node) float64 {
+	t.Helper()
+	if n.Value != nil {
+		return *n.Value
+	}
+	a := evalNode(t, n.Left)
+	if n.IsFunction() {
+		v, ok := evalFunction(n.Op, a)
+		if !ok {
+			t.Fatalf("cannot evaluate %s", n)
+		}
+		return v
+	}
+	v, ok := evalSymbolic(n.Op, a, evalNode(t, n.Right))
+	if !ok {
+		t.Fatalf("cannot evaluate %s", n)
+	}
+	return v
+}
+
+func TestParserPercent(t *testing.T) {
+	tests := []struct {
+		input string
+		tree  string
+		want  float64
+	}{
+		// Справа от '+' и '-' процент берется от левого операнда.
+		{"200 + 15%", "(200+((200*15)/100))", 230},
+		{"200 - 15%", "(200-((200*15)/100))", 170},
+		{"200 + -5%", "(200+((200*(-5))/100))", 190},
+		{"100 + 10% + 10%", "((100+((100*10)/100))+(((100+((100*10)/100))*10)/100))", 121},
+		{"2*100 + 15 %", "((2*100)+(((2*100)*15)/100))", 230},
+		// В произведении, частном и степени процент - просто число.
+		{"50 * 10%", "(50*(10/100))", 5},
+		{"10% * 50", "((10/100)*50)", 5},
+		{"30 / 50%", "(30/(50/100))", 60},
+		{"200 + 15% * 2", "(200+((15/100)*2))", 200.3},
+		{"4^50%", "(4^(50/100))", 2},
+		// Отдельно стоящий процент - доля единицы, в том числе в скобках и аргументах функций.
+		{"15%", "(15/100)", 0.15},
+		{"-15%", "((-15)/100)", -0.15},
+		{"200 + (15%)", "(200+(15/100))", 200.15},
+		{"15% + 200", "((15/100)+200)", 200.15},
+		{"sqrt(25%)", "sqrt((25/100))", 0.5},
+		{"(100 + 20)%", "((100+20)/100)", 1.2},
+	}
+	for _, tc := range tests {
+		node, err := NewParser(tc.input).Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", tc.input, err)
+			continue
+		}
+		if got := node.String(); got != tc.tree {
+			t.Errorf("Parse(%q).String() = %q, want %q", tc.input, got, tc.tree)
+		}
+		if got := evalNode(t, node); math.Abs(got-tc.want) > 1e-12 {
+			t.Errorf("%q = %v, want %v", tc.input, got, tc.want)
+		}
+	}
+
+	for _, input := range []string{"%5", "5%%", "200 +%"} {
+		if _, err := NewParser(input).Parse(); err == nil {
+			t.Errorf("Parse(%q): expected error", input)
+		}
+	}
+}
+
+func TestParserUserFunctions(t *testing.T) {
+	functions := map[string]UserFunction{
+		"tax":   {Params: []string{"x"}, Body: "x * 0.13"},
+		"gross": {Params: []string{"x"}, Body: "x + tax(x)"},
+		"hyp":   {Params: []string{"a", "b"}, Body: "sqrt(a^2 + b^2)"},
+		"rate":  {Params: []string{}, Body: "0.2"},
+		"loop":  {Params: []string{"x"}, Body: "loop2(x)"},
+		"loop2": {Params: []string{"x"}, Body: "loop(x) + 1"},
+	}
+	tests := []struct {
+		input, tree string
+		want        float64
+	}{
+		{"tax(1000) + 50", "((1000*0.13)+50)", 180},
+		{"gross(100)", "(100+(100*0.13))", 113},
+		{"hyp(3, 4)", "sqrt(((3^2)+(4^2)))", 5},
+		{"rate() * 10", "(0.2*10)", 2},
+		{"tax(2 + 3)", "((2+3)*0.13)", 0.65},
+	}
+	for _, tc := range tests {
+		node, err := NewParser(tc.input).WithFunctions(functions).Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", tc.input, err)
+			continue
+		}
+		if got := node.String(); got != tc.tree {
+			t.Errorf("Parse(%q).String() = %q, want %q", tc.input, got, tc.tree)
+		}
+		if got := evalNode(t, node); math.Abs(got-tc.want) > 1e-12 {
+			t.Errorf("%q = %v, want %v", tc.input, got, tc.want)
+		}
+	}
+
+	for _, input := range []string{"loop(1)", "tax(1, 2)", "tax()", "unknown(1)", "rate(1)"} {
+		if _, err := NewParser(input).WithFunctions(functions).Parse(); err == nil {
+			t.Errorf("Parse(%q): expected error", input)
+		}
+	}
+	if _, err := NewParser("tax(1)").Parse(); err == nil {
+		t.Error("user functions must not be visible without WithFunctions")
+	}
+}
+
+func TestParserReferences(t *testing.T) {
+	node, err := NewParser("$42 * 1.2 + $7 - $42").Parse()
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if got, want := node.String(), "((($42*1.2)+$7)-$42)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := References(node); len(got) != 2 || got[0] != 42 || got[1] != 7 {
+		t.Errorf("References = %v, want [42 7]", got)
+	}
+	if vars := FreeVariables(node); len(vars) != 0 {
+		t.Errorf("FreeVariables = %v, references are not variables", vars)
+	}
+	// Единицы результата по ссылке неизвестны до планирования.
+	if _, err := NewParser("$1 + 5 km").Parse(); err != nil {
+		t.Errorf("Parse($1 + 5 km) returned error: %v", err)
+	}
+
+	for _, input := range []string{"$", "$x", "$0 + 1", "2 $1", "$1 2"} {
+		if _, err := NewLenientParser(input).Parse(); err == nil {
+			t.Errorf("Parse(%q): expected error", input)
+		}
+	}
+}
+
+func TestParserComplex(t *testing.T) {
+	tests := []struct{ input, tree, pretty string }{
+		{"(3+4i) * (1-2i)", "((3+4i)*(1-2i))", "(3+4i)*(1-2i)"},
+		{"-2i", "(-2i)", "-2i"},
+		{"2 - -1.5i", "(2-(-1.5i))", "2-(-1.5i)"},
+		{"abs(3+4i)", "abs((3+4i))", "abs(3+4i)"},
+		{"2*i", "(2*i)", "2*i"}, // Отдельное i - переменная
+	}
+	for _, tc := range tests {
+		node, err := NewParser(tc.input).Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", tc.input, err)
+			continue
+		}
+		if got := node.String(); got != tc.tree {
+			t.Errorf("Parse(%q).String() = %q, want %q", tc.input, got, tc.tree)
+		}
+		if got := node.Pretty(); got != tc.pretty {
+			t.Errorf("Parse(%q).Pretty() = %q, want %q", tc.input, got, tc.pretty)
+		}
+	}
+
+	c := complex(3, -4)
+	if got := (&Node{Op: "*", Left: num(2), Right: &Node{Complex: &c}}).Pretty(); got != "2*(3-4i)" {
+		t.Errorf("Pretty of complex leaf = %q, want %q", got, "2*(3-4i)")
+	}
+	for _, input := range []string{"4i 2", "2 4i"} {
+		if _, err := NewLenientParser(input).Parse(); err == nil {
+			t.Errorf("Parse(%q): expected error", input)
+		}
+	}
+}
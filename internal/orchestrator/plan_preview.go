@@ -0,0 +1,107 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// PlanPreview - план вычисления выражения без его сохранения (POST /api/v1/calculate?dry_run=true).
+type PlanPreview struct {
+	Expression   string         `json:"expression"` // После раскрытия функций, ссылок и оптимизации
+	Tasks        []PreviewTask  `json:"tasks"`      // DAG задач: аргументы раньше потребителей
+	Operations   map[string]int `json:"operations"` // Число задач по операциям
+	Depth        int            `json:"depth"`      // Задач в самой длинной цепочке
+	SequentialMs int64          `json:"sequential_ms"`
+	ParallelMs   int64          `json:"parallel_ms"`       // Время при неограниченном числе агентов - длина критического пути
+	Folded       []string       `json:"folded"`            // Преобразования оптимизатора
+	Cached       []string       `json:"cached"`            // Поддеревья, результат которых уже есть в кэше
+	Waiting      []string       `json:"waiting,omitempty"` // Ссылки на незавершенные выражения: задач пока нет
+}
+
+type PreviewTask struct {
+	ID           int64   `json:"id"` // Номер задачи в плане, а не ID в БД
+	Operation    string  `json:"operation"`
+	NodeKey      string  `json:"node_key"`
+	DependsOn    []int64 `json:"depends_on,omitempty"`
+	CriticalPath int64   `json:"critical_path"`
+}
+
+// PreviewPlan строит задачи выражения так же, как ScheduleTasks, но ничего не сохраняет и
+// не меняет кэш. Ошибки разбора и нарушение политики strict возвращаются сразу. Если выражение
+// ссылается на незавершенные выражения, ScheduleTasks только поставило бы его в ожидание:
+// план возвращается без задач, а ссылки перечисляются в Waiting.
+func (s *Scheduler) PreviewPlan(userID int64, expression string, opts database.ExpressionOptions) (*PlanPreview, error) {
+	// Выражения еще нет, поэтому ссылаться можно на любое существующее.
+	ast, unit, steps, err := s.prepareAST(math.MaxInt64, expression, userID, opts.Mode)
+	if err == nil && !opts.AllowSpecialValues() {
+		err = checkFinite(ast)
+	}
+	var waiting *waitingError
+	if errors.As(err, &waiting) {
+		preview := &PlanPreview{
+			Expression: expression,
+			Tasks:      []PreviewTask{},
+			Operations: make(map[string]int),
+			Folded:     []string{},
+			Cached:     []string{},
+		}
+		for _, id := range waiting.ids {
+			preview.Waiting = append(preview.Waiting, fmt.Sprintf("$%d", id))
+		}
+		return preview, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &PlanPreview{
+		Expression: ast.Pretty(),
+		Tasks:      []PreviewTask{},
+		Operations: make(map[string]int),
+		Folded:     steps,
+		Cached:     []string{},
+	}
+	if preview.Folded == nil {
+		preview.Folded = []string{}
+	}
+	plan := &taskPlan{
+		mode:      opts.Mode,
+		special:   opts.SpecialValues,
+		useCache:  !opts.NoCache && opts.Mode != database.ModeInterval,
+		dryRun:    true,
+		planned:   make(map[string]int64),
+		nodeTasks: make(map[*Node]int64),
+		root:      ast,
+		rootUnit:  unit,
+	}
+	if _, err := s.planTasksRecursive(ast, plan); err != nil {
+		return nil, err
+	}
+	preview.Cached = append(preview.Cached, plan.steps...)
+
+	times := s.GetOperationTimes()
+	paths := criticalPaths(plan.tasks, times)
+	depth := make(map[int64]int, len(plan.tasks))
+	finish := make(map[int64]int64, len(plan.tasks)) // Когда задача будет готова при неограниченном числе агентов
+	for _, t := range plan.tasks {
+		task := PreviewTask{ID: t.id, Operation: t.op, NodeKey: t.key, CriticalPath: paths[t.id]}
+		cost := int64(times.For(t.op))
+		depth[t.id], finish[t.id] = 1, cost
+		for i, child := range t.children {
+			if child == 0 || (i == 1 && child == t.children[0]) {
+				continue
+			}
+			task.DependsOn = append(task.DependsOn, child)
+			depth[t.id] = max(depth[t.id], depth[child]+1)
+			finish[t.id] = max(finish[t.id], finish[child]+cost)
+		}
+		preview.Tasks = append(preview.Tasks, task)
+		preview.Operations[t.op]++
+		preview.Depth = max(preview.Depth, depth[t.id])
+		preview.SequentialMs += cost
+		preview.ParallelMs = max(preview.ParallelMs, finish[t.id])
+	}
+	return preview, nil
+}
@@ -0,0 +1,193 @@
+package orchestrator
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrintOptions управляет видом выражения, которое выводит Format.
+type PrintOptions struct {
+	Spaces       bool // Пробелы вокруг бинарных операторов: "2 + 3"
+	NumberFormat byte // Формат strconv.FormatFloat: 'g' (по умолчанию), 'f' или 'e'
+	Precision    int  // Знаков после запятой; -1 - кратчайшее точное представление
+}
+
+// DefaultPrintOptions - формат, в котором выражения сохраняются и показываются пользователю.
+var DefaultPrintOptions = PrintOptions{Spaces: false, NumberFormat: 'g', Precision: -1}
+
+// Pretty выводит выражение с минимально необходимыми скобками: "(2+3)*4" вместо "((2+3)*4)".
+// В отличие от String результат не годится как ключ поддерева, но разбирается обратно в то же дерево.
+func (n *Node) Pretty() string {
+	return n.Format(DefaultPrintOptions)
+}
+
+func (n *Node) Format(opts PrintOptions) string {
+	if n == nil {
+		return ""
+	}
+	if opts.NumberFormat == 0 {
+		opts.NumberFormat = 'g'
+	}
+	var b strings.Builder
+	n.format(&b, opts)
+	return b.String()
+}
+
+func (n *Node) format(b *strings.Builder, opts PrintOptions) {
+	if n.Value != nil {
+		b.WriteString(formatLiteral(*n.Value, opts))
+		if n.Unit != "" {
+			b.WriteString(" " + n.Unit)
+		}
+		return
+	}
+	if n.Tensor != nil {
+		b.WriteString(n.Tensor.String())
+		return
+	}
+	if n.Complex != nil {
+		// Число с действительной частью всегда в скобках: 2*(3+4i), а не 2*3+4i.
+		if real(*n.Complex) != 0 {
+			b.WriteString("(" + formatComplex(*n.Complex, opts) + ")")
+		} else {
+			b.WriteString(formatComplex(*n.Complex, opts))
+		}
+		return
+	}
+	if n.Interval != nil {
+		b.WriteString(n.Interval.String())
+		return
+	}
+	if n.Var != "" {
+		b.WriteString(n.Var)
+		return
+	}
+	if n.IsFunction() || binaryFunctions[n.Op] {
+		b.WriteString(n.Op + "(")
+		n.Left.format(b, opts)
+		if n.Right != nil {
+			b.WriteByte(',')
+			n.Right.format(b, opts)
+		}
+		b.WriteByte(')')
+		return
+	}
+	if n.Op == "in" {
+		n.Left.format(b, opts)
+		b.WriteString(" in " + n.Unit)
+		return
+	}
+
+	p := opPrecedence(n)
+	writeOperand := func(child *Node, parens bool) {
+		if parens {
+			b.WriteByte('(')
+		}
+		child.format(b, opts)
+		if parens {
+			b.WriteByte(')')
+		}
+	}
+
+	// Степень правоассоциативна, поэтому левый операнд-степень и отрицательное
+	// основание берутся в скобки: (2^3)^2, (-2)^2. Величина с единицей тоже: (5 m)^2, а не 5 m^2.
+	leftParens := opPrecedence(n.Left) < p
+	if n.Op == "^" {
+		leftParens = opPrecedence(n.Left) <= p || isNegativeNumber(n.Left) || n.Left.Unit != ""
+	}
+	writeOperand(n.Left, leftParens)
+	if opts.Spaces {
+		b.WriteString(" " + n.Op + " ")
+	} else {
+		b.WriteString(n.Op)
+	}
+	// Правый операнд с тем же приоритетом тоже берется в скобки: для '-' и '/' это меняет
+	// смысл (a-(b-c)), а для '+' и '*' сохраняет форму дерева, т.е. порядок вычислений.
+	// Отрицательное число справа берем в скобки, чтобы не получить "2--3".
+	writeOperand(n.Right, opPrecedence(n.Right) <= p || isNegativeNumber(n.Right))
+}
+
+func formatLiteral(v float64, opts PrintOptions) string {
+	if v == 0 {
+		v = 0 // -0 печатается как 0
+	}
+	return strconv.FormatFloat(v, opts.NumberFormat, opts.Precision, 64)
+}
+
+// formatComplex выводит комплексное число в записи, которую понимает парсер: 3+4i, -2i.
+// Мнимая единица пишется с коэффициентом: 1i.
+func formatComplex(c complex128, opts PrintOptions) string {
+	im := formatLiteral(imag(c), opts) + "i"
+	if real(c) == 0 {
+		return im
+	}
+	if imag(c) >= 0 {
+		im = "+" + im
+	}
+	return formatLiteral(real(c), opts) + im
+}
+
+// Canonicalize приводит дерево к канонической форме: цепочки коммутативных операций (+, *)
+// разворачиваются, операнды сортируются и дерево собирается заново слева направо.
+// Два выражения, отличающиеся лишь порядком слагаемых/множителей, получают одинаковую форму.
+// Исходное дерево не изменяется.
+func Canonicalize(n *Node) *Node {
+	if n == nil {
+		return nil
+	}
+	if n.Value != nil {
+		v := *n.Value
+		if v == 0 {
+			v = 0
+		}
+		return &Node{Value: &v, Unit: n.Unit}
+	}
+	if n.Tensor != nil {
+		return &Node{Tensor: n.Tensor}
+	}
+	if n.Complex != nil {
+		c := *n.Complex
+		return &Node{Complex: &c}
+	}
+	if n.Interval != nil {
+		return &Node{Interval: n.Interval}
+	}
+	if n.Var != "" {
+		return &Node{Var: n.Var}
+	}
+	// Произведение матриц некоммутативно, поэтому множители с векторами не переставляются.
+	if n.Op != "+" && (n.Op != "*" || hasTensor(n)) {
+		return &Node{Op: n.Op, Left: Canonicalize(n.Left), Right: Canonicalize(n.Right), Unit: n.Unit}
+	}
+
+	var operands []*Node
+	var collect func(c *Node)
+	collect = func(c *Node) {
+		if c.Value == nil && c.Op == n.Op {
+			collect(c.Left)
+			collect(c.Right)
+			return
+		}
+		operands = append(operands, Canonicalize(c))
+	}
+	collect(n)
+
+	keys := make(map[*Node]string, len(operands))
+	for _, o := range operands {
+		keys[o] = o.String()
+	}
+	sort.SliceStable(operands, func(i, j int) bool { return keys[operands[i]] < keys[operands[j]] })
+
+	root := operands[0]
+	for _, o := range operands[1:] {
+		root = &Node{Op: n.Op, Left: root, Right: o}
+	}
+	return root
+}
+
+// CanonicalKey возвращает строку, одинаковую для эквивалентных с точностью до перестановки
+// операндов выражений. Используется для поиска повторных отправок.
+func CanonicalKey(n *Node) string {
+	return Canonicalize(n).Pretty()
+}
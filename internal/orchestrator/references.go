@@ -0,0 +1,141 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"calculator/internal/interval"
+	"calculator/internal/tensor"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// В дереве ссылка $42 на результат выражения 42 - переменная с именем "$42". Ее значение
+// не задается клиентом, а берется планировщиком из БД перед построением задач.
+const referencePrefix = "$"
+
+func isReference(name string) bool {
+	return strings.HasPrefix(name, referencePrefix)
+}
+
+// parseReference разбирает ссылку $<id> на результат ранее отправленного выражения.
+func (p *Parser) parseReference() (*Node, error) {
+	p.next() // '$'
+	start := p.pos
+	for p.ch >= '0' && p.ch <= '9' {
+		p.next()
+	}
+	if start == p.pos {
+		return nil, fmt.Errorf("ожидался ID выражения после '$'")
+	}
+	id, err := strconv.ParseInt(p.input[start:p.pos], 10, 64)
+	if err != nil || id <= 0 {
+		return nil, fmt.Errorf("некорректная ссылка на выражение '$%s'", p.input[start:p.pos])
+	}
+	p.lastTok = 'n' // Как и два числа подряд, "$1 2" - ошибка, а не произведение
+	return &Node{Var: referencePrefix + strconv.FormatInt(id, 10)}, nil
+}
+
+// References возвращает ID выражений, на которые ссылается дерево, в порядке первого появления.
+func References(n *Node) []int64 {
+	var ids []int64
+	seen := make(map[string]bool)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil {
+			return
+		}
+		if isReference(n.Var) && !seen[n.Var] {
+			seen[n.Var] = true
+			id, _ := strconv.ParseInt(strings.TrimPrefix(n.Var, referencePrefix), 10, 64)
+			ids = append(ids, id)
+		}
+		walk(n.Left)
+		walk(n.Right)
+	}
+	walk(n)
+	return ids
+}
+
+// waitingError означает, что выражения, на которые ссылается дерево, еще вычисляются.
+type waitingError struct {
+	ids []int64
+}
+
+func (e *waitingError) Error() string {
+	return "результаты выражений " + e.refs() + " еще не готовы"
+}
+
+func (e *waitingError) refs() string {
+	refs := make([]string, len(e.ids))
+	for i, id := range e.ids {
+		refs[i] = fmt.Sprintf("$%d", id)
+	}
+	return strings.Join(refs, ", ")
+}
+
+// resolveReferences подставляет вместо ссылок результаты выражений пользователя userID.
+// Ссылаться можно только на выражения, отправленные раньше expressionID, поэтому циклов
+// ожидания не бывает. Если какие-то из них еще не завершены, возвращается *waitingError.
+func (s *Scheduler) resolveReferences(n *Node, expressionID, userID int64) (*Node, error) {
+	ids := References(n)
+	if len(ids) == 0 {
+		return n, nil
+	}
+	values := make(map[string]*Node, len(ids))
+	var waiting []int64
+	for _, id := range ids {
+		if id >= expressionID {
+			return nil, fmt.Errorf("ссылаться можно только на ранее отправленные выражения, получено $%d", id)
+		}
+		ref, err := s.dbStore.GetExpressionByID(id, userID)
+		if err != nil {
+			return nil, err
+		}
+		if ref == nil {
+			return nil, fmt.Errorf("выражение $%d не найдено", id)
+		}
+		switch ref.Status {
+		case database.StatusDone:
+			value, err := resultNode(ref)
+			if err != nil {
+				return nil, err
+			}
+			values[referencePrefix+strconv.FormatInt(id, 10)] = value
+		case database.StatusError:
+			return nil, fmt.Errorf("выражение $%d завершилось с ошибкой", id)
+		case database.StatusTimeout:
+			return nil, fmt.Errorf("срок выражения $%d истек до получения результата", id)
+		default:
+			waiting = append(waiting, id)
+		}
+	}
+	if len(waiting) > 0 {
+		return nil, &waitingError{ids: waiting}
+	}
+	return bindParams(n, values), nil
+}
+
+// resultNode превращает результат завершенного выражения в лист дерева вместе с его единицей.
+func resultNode(expr *database.Expression) (*Node, error) {
+	if len(expr.ResultTensor) > 0 {
+		t := &tensor.Tensor{}
+		if err := json.Unmarshal(expr.ResultTensor, t); err != nil {
+			return nil, fmt.Errorf("некорректный результат выражения $%d: %w", expr.ID, err)
+		}
+		return &Node{Tensor: t}, nil
+	}
+	if expr.ResultInterval != nil {
+		i := interval.Interval{Lo: expr.ResultInterval.Lo, Hi: expr.ResultInterval.Hi}
+		return &Node{Interval: &i}, nil
+	}
+	if expr.ResultComplex != nil {
+		c := complex(expr.ResultComplex.Re, expr.ResultComplex.Im)
+		return &Node{Complex: &c}, nil
+	}
+	if !expr.Result.Valid {
+		return nil, fmt.Errorf("у выражения $%d нет результата", expr.ID)
+	}
+	v := expr.Result.Float64
+	return &Node{Value: &v, Unit: expr.Unit}, nil
+}
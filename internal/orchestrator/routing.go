@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	pb "calculator/internal/grpc/calculator"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxLabels ограничивает число меток выражения и агента.
+const maxLabels = 16
+
+// validLabelPart проверяет ключ или значение метки: непустое, без пробелов, '=' и ','.
+func validLabelPart(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "=, \t\n")
+}
+
+// validateLabels проверяет метки, которые выражение требует от агента.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("не больше %d меток, получено %d", maxLabels, len(labels))
+	}
+	for k, v := range labels {
+		if !validLabelPart(k) || !validLabelPart(v) {
+			return fmt.Errorf("некорректная метка '%s=%s': ключ и значение не могут быть пустыми и содержать пробелы, '=' и ','", k, v)
+		}
+	}
+	return nil
+}
+
+// agentCapabilities разбирает операции и метки key=value из запроса задачи агентом.
+func agentCapabilities(req *pb.GetTaskRequest) (database.AgentCapabilities, error) {
+	caps := database.AgentCapabilities{}
+	for _, op := range req.Operations {
+		if op = strings.TrimSpace(op); op != "" {
+			caps.Operations = append(caps.Operations, op)
+		}
+	}
+	if len(req.Labels) > maxLabels {
+		return caps, fmt.Errorf("не больше %d меток, получено %d", maxLabels, len(req.Labels))
+	}
+	for _, label := range req.Labels {
+		k, v, ok := strings.Cut(label, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || !validLabelPart(k) || !validLabelPart(v) {
+			return caps, fmt.Errorf("некорректная метка агента '%s', ожидалось key=value", label)
+		}
+		caps.Labels = append(caps.Labels, k+"="+v)
+	}
+	return caps, nil
+}
+
+// RejectUnservable завершает с ошибкой выражения, задачу которых не может взять ни один из
+// активных агентов: иначе такое выражение ждало бы бесконечно. Пока агенты известны меньше
+// agentActiveWindow, ничего не отклоняется.
+func (s *Scheduler) RejectUnservable(now time.Time) {
+	routes, err := s.dbStore.PendingTaskRoutes()
+	if err != nil {
+		log.Printf("Scheduler: %v", err)
+		return
+	}
+	for _, r := range routes {
+		if ok, known := s.agents.serves(r.Operation, r.Labels, now); ok || !known {
+			continue
+		}
+		msg := fmt.Sprintf("нет агента для операции '%s'", r.Operation)
+		if len(r.Labels) > 0 {
+			msg += " с метками " + strings.Join(r.Labels, ",")
+		}
+		rejected, err := s.dbStore.RejectTask(r.TaskID, msg)
+		if err != nil {
+			log.Printf("Scheduler: %v", err)
+			continue
+		}
+		if rejected {
+			log.Printf("Scheduler: Задача ID %d выражения ID %d отклонена: %s", r.TaskID, r.ExpressionID, msg)
+			s.ProcessTaskCompletion(r.TaskID)
+		}
+	}
+}
+
+// WatchAgents раз в interval отклоняет задачи, для которых нет подходящего агента.
+// Запускается в отдельной горутине.
+func (s *Scheduler) WatchAgents(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.RejectUnservable(now)
+	}
+}
@@ -2,127 +2,419 @@ package orchestrator
 
 import (
 	"calculator/internal/database"
+	"calculator/internal/interval"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 type OperationTimes struct {
-	Addition       int
-	Subtraction    int
-	Multiplication int
-	Division       int
+	Addition       int `json:"addition_ms"`
+	Subtraction    int `json:"subtraction_ms"`
+	Multiplication int `json:"multiplication_ms"`
+	Division       int `json:"division_ms"`
+	Power          int `json:"power_ms"`
+	Function       int `json:"function_ms"` // sin, cos, ln и другие функции одного аргумента
+}
+
+// For возвращает время выполнения операции op в миллисекундах.
+func (t *OperationTimes) For(op string) int {
+	switch op {
+	case "+", "merge":
+		return t.Addition
+	case "-":
+		return t.Subtraction
+	case "*", "dot":
+		return t.Multiplication
+	case "/":
+		return t.Division
+	case "^":
+		return t.Power
+	case "sin", "cos", "tan", "exp", "ln", "sqrt", "abs", "median":
+		return t.Function
+	}
+	return 1000 // Время по умолчанию
 }
 
 type Scheduler struct {
-	dbStore *database.Store
-	opTimes *OperationTimes
+	dbStore   *database.Store
+	opTimes   atomic.Pointer[OperationTimes] // Заменяется целиком, сам снимок не меняется
+	settingMu sync.Mutex                     // Сериализует изменение времени операций
+	optimizer *Optimizer
+	cache     *MemoCache
+	mu        sync.Mutex // Сериализует обновление шагов выражения при параллельных завершениях задач
+	jobMu     sync.Mutex // Сериализует шаги итеративных задач (solve), состояние которых хранится в jobs.params
+	agents    agentTracker
+
+	evalMu       sync.Mutex   // Защищает очередь вычислений решателя
+	evalQueue    []solverEval // Вычисления решателя, ожидающие планирования
+	evalDraining bool         // Очередь уже разбирается: новые вычисления только добавляются в нее
 }
 
 func NewScheduler(db *database.Store) *Scheduler {
-	return &Scheduler{
-		dbStore: db,
-		opTimes: initOperationTimes(),
+	s := &Scheduler{
+		dbStore:   db,
+		optimizer: NewOptimizer(initOptimizerConfig()),
+		cache:     initMemoCache(),
 	}
+	s.opTimes.Store(s.loadOperationTimes(initOperationTimes()))
+	return s
+}
+
+func (s *Scheduler) GetCache() *MemoCache {
+	return s.cache
 }
 
-func (s *Scheduler) ScheduleTasks(expressionID int64, expression string) error {
-	parser := NewParser(expression)
-	ast, err := parser.Parse()
+// prepareAST разбирает выражение, раскрывая функции пользователя userID и подставляя результаты
+// выражений по ссылкам, переводит величины в СИ и прогоняет дерево через оптимизатор.
+// Возвращает также единицу измерения результата (пустую для безразмерного).
+func (s *Scheduler) prepareAST(expressionID int64, expression string, userID int64, mode string) (*Node, string, []string, error) {
+	ast, err := NewParser(expression).WithFunctions(userFunctions(s.dbStore, userID)).Parse()
 	if err != nil {
-		errMsg := fmt.Sprintf("Ошибка парсинга: %v", err)
-		s.dbStore.UpdateExpressionStatusResult(expressionID, database.StatusError, sql.NullFloat64{}, sql.NullString{String: errMsg, Valid: true})
-		return fmt.Errorf("ошибка парсинга выражения ID %d: %w", expressionID, err)
+		return nil, "", nil, err
+	}
+	if ast, err = s.resolveReferences(ast, expressionID, userID); err != nil {
+		return nil, "", nil, err
 	}
+	if vars := FreeVariables(ast); len(vars) > 0 {
+		return nil, "", nil, fmt.Errorf("значение переменной '%s' не задано", vars[0])
+	}
+	ast, unit, err := toSI(ast)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if ast, err = applyMode(ast, mode); err != nil {
+		return nil, "", nil, err
+	}
+	if mode == database.ModeInterval {
+		// Оптимизатор сворачивает константы с округлением к ближайшему, и результат перестал бы
+		// гарантированно содержать точное значение.
+		return ast, unit, nil, nil
+	}
+	ast, steps := s.optimizer.Optimize(ast)
+	return ast, unit, steps, nil
+}
 
-	log.Printf("AST для выражения ID %d построено. Начинаем планирование задач.", expressionID)
+func stepsJSON(steps []string) sql.NullString {
+	if len(steps) == 0 {
+		return sql.NullString{}
+	}
+	data, _ := json.Marshal(steps)
+	return sql.NullString{String: string(data), Valid: true}
+}
 
-	err = s.planTasksRecursive(ast, expressionID)
+func (s *Scheduler) ScheduleTasks(expressionID int64, expression string, opts database.ExpressionOptions) error {
+	// Родительская задача уведомляется уже после снятия блокировки (defer выполняются в обратном порядке):
+	// обработчик может сам планировать новые выражения.
+	finished := false
+	defer func() {
+		if finished {
+			s.expressionFinished(expressionID)
+		}
+	}()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var userID int64
+	if expr, err := s.dbStore.GetExpressionByIDInternal(expressionID); err == nil && expr != nil {
+		userID = expr.UserID
+	}
+	ast, unit, steps, err := s.prepareAST(expressionID, expression, userID, opts.Mode)
+	if err == nil && !opts.AllowSpecialValues() {
+		err = checkFinite(ast)
+	}
+	var waiting *waitingError
+	if errors.As(err, &waiting) {
+		// Выражение будет запланировано заново, когда завершится одно из выражений, на которые оно ссылается.
+		steps := []string{"Waiting for " + waiting.refs()}
+		if err := s.dbStore.WaitForExpressions(expressionID, waiting.ids, stepsJSON(steps)); err != nil {
+			return fmt.Errorf("ошибка постановки выражения ID %d в ожидание: %w", expressionID, err)
+		}
+		log.Printf("Выражение ID %d ожидает результатов %s", expressionID, waiting.refs())
+		return nil
+	}
 	if err != nil {
-		errMsg := fmt.Sprintf("Ошибка планирования задач: %v", err)
+		errMsg := fmt.Sprintf("Ошибка парсинга: %v", err)
 		s.dbStore.UpdateExpressionStatusResult(expressionID, database.StatusError, sql.NullFloat64{}, sql.NullString{String: errMsg, Valid: true})
-		return fmt.Errorf("ошибка планирования задач для выражения ID %d: %w", expressionID, err)
+		finished = true
+		return fmt.Errorf("ошибка парсинга выражения ID %d: %w", expressionID, err)
+	}
+	if unit != "" {
+		if err := s.dbStore.UpdateExpressionUnit(expressionID, unit); err != nil {
+			log.Printf("Ошибка сохранения единицы выражения ID %d: %v", expressionID, err)
+		}
 	}
 
-	if ast.Value == nil {
-		err = s.dbStore.UpdateExpressionStatusResult(expressionID, database.StatusInProgress, sql.NullFloat64{}, sql.NullString{})
+	log.Printf("AST для выражения ID %d построено. Начинаем планирование задач.", expressionID)
+
+	nodeTasks := make(map[*Node]int64)
+	if !ast.IsLeaf() {
+		// Статус выставляется до создания задач: иначе быстрый агент может завершить выражение
+		// раньше, чем мы перезапишем его статус на in_progress.
+		err = s.dbStore.UpdateExpressionStatusResult(expressionID, database.StatusInProgress, sql.NullFloat64{}, stepsJSON(steps))
 		if err != nil {
 			log.Printf("Ошибка обновления статуса на in_progress для выражения ID %d: %v", expressionID, err)
 		}
-	} else {
-		log.Printf("Выражение ID %d является числом (%f), завершаем сразу.", expressionID, *ast.Value)
-		stepsJSON, _ := json.Marshal([]string{fmt.Sprintf("Result: %f", *ast.Value)})
+
+		plan := &taskPlan{
+			expressionID: expressionID,
+			mode:         opts.Mode,
+			special:      opts.SpecialValues,
+			useCache:     !opts.NoCache && opts.Mode != database.ModeInterval, // Кэш хранит одно число, а не границы
+			planned:      make(map[string]int64),
+			nodeTasks:    nodeTasks,
+			root:         ast,
+			rootUnit:     unit,
+		}
+		_, err = s.planTasksRecursive(ast, plan)
+		if err != nil {
+			errMsg := fmt.Sprintf("Ошибка планирования задач: %v", err)
+			s.dbStore.UpdateExpressionStatusResult(expressionID, database.StatusError, sql.NullFloat64{}, sql.NullString{String: errMsg, Valid: true})
+			finished = true
+			return fmt.Errorf("ошибка планирования задач для выражения ID %d: %w", expressionID, err)
+		}
+		// Листья уже могут быть выданы агентам: до сохранения путей они выбираются по порядку создания.
+		if err := s.dbStore.SetTaskCriticalPaths(criticalPaths(plan.tasks, s.GetOperationTimes())); err != nil {
+			log.Printf("Ошибка сохранения критических путей выражения ID %d: %v", expressionID, err)
+		}
+		steps = append(steps, plan.steps...)
+		if !ast.IsLeaf() && len(plan.steps) > 0 {
+			s.dbStore.UpdateExpressionStatusResult(expressionID, database.StatusInProgress, sql.NullFloat64{}, stepsJSON(steps))
+		}
+	}
+
+	s.saveScheduledAST(expressionID, ast, nodeTasks)
+
+	if ast.Tensor != nil {
+		log.Printf("Выражение ID %d вычислено без агентов (%s), завершаем сразу.", expressionID, ast.Tensor)
+		steps = append(steps, fmt.Sprintf("Result: %s", ast.Tensor))
+		if err := s.dbStore.CompleteExpressionTensor(expressionID, ast.Tensor.String(), stepsJSON(steps)); err != nil {
+			log.Printf("Ошибка обновления статуса на done для выражения ID %d: %v", expressionID, err)
+		}
+		finished = true
+	}
+
+	if ast.Complex != nil {
+		result := formatComplex(*ast.Complex, DefaultPrintOptions)
+		log.Printf("Выражение ID %d вычислено без агентов (%s), завершаем сразу.", expressionID, result)
+		steps = append(steps, "Result: "+result)
+		c := database.Complex{Re: real(*ast.Complex), Im: imag(*ast.Complex)}
+		if err := s.dbStore.CompleteExpressionComplex(expressionID, c, stepsJSON(steps)); err != nil {
+			log.Printf("Ошибка обновления статуса на done для выражения ID %d: %v", expressionID, err)
+		}
+		finished = true
+	}
+
+	if ast.Interval != nil {
+		log.Printf("Выражение ID %d вычислено без агентов (%s), завершаем сразу.", expressionID, ast.Interval)
+		steps = append(steps, "Result: "+ast.Interval.String())
+		result := database.Interval{Lo: ast.Interval.Lo, Hi: ast.Interval.Hi, Mid: ast.Interval.Mid()}
+		if err := s.dbStore.CompleteExpressionInterval(expressionID, result, stepsJSON(steps)); err != nil {
+			log.Printf("Ошибка обновления статуса на done для выражения ID %d: %v", expressionID, err)
+		}
+		finished = true
+	}
+
+	if ast.Value != nil {
+		log.Printf("Выражение ID %d вычислено без агентов (%f), завершаем сразу.", expressionID, *ast.Value)
+		steps = append(steps, fmt.Sprintf("Result: %f", *ast.Value))
 		err = s.dbStore.UpdateExpressionStatusResult(expressionID,
 			database.StatusDone,
 			sql.NullFloat64{Float64: *ast.Value, Valid: true},
-			sql.NullString{String: string(stepsJSON), Valid: true},
+			stepsJSON(steps),
 		)
 		if err != nil {
 			log.Printf("Ошибка обновления статуса на done для числового выражения ID %d: %v", expressionID, err)
 		}
+		finished = true
 	}
 
 	log.Printf("Планирование задач для выражения ID %d завершено.", expressionID)
 	return nil
 }
 
-func (s *Scheduler) planTasksRecursive(node *Node, expressionID int64) error {
-	if node == nil || node.Value != nil {
-		return nil
+// saveScheduledAST сохраняет дерево в том виде, в котором по нему созданы задачи: его отдает
+// GET /expressions/{id}/ast, не разбирая выражение заново.
+func (s *Scheduler) saveScheduledAST(expressionID int64, ast *Node, nodeTasks map[*Node]int64) {
+	scheduled := exportJSON(ast, func(n *Node) (database.Task, bool) {
+		id, ok := nodeTasks[n]
+		return database.Task{ID: id}, ok
+	})
+	data, err := json.Marshal(scheduled)
+	if err == nil {
+		err = s.dbStore.SetExpressionAST(expressionID, string(data))
+	}
+	if err != nil {
+		log.Printf("Ошибка сохранения дерева выражения ID %d: %v", expressionID, err)
 	}
+}
 
-	if err := s.planTasksRecursive(node.Left, expressionID); err != nil {
-		return err
+// taskPlan - состояние планирования одного выражения.
+type taskPlan struct {
+	expressionID int64
+	mode         string // Числовой режим выражения: "" или database.ModeInterval
+	special      string // Политика выражения для Inf и NaN
+	useCache     bool
+	dryRun       bool             // Только построить план: задачи не создаются, кэш не меняется
+	planned      map[string]int64 // Уже созданные задачи по ключу поддерева
+	nodeTasks    map[*Node]int64  // Задачи узлов дерева: ключ поддерева меняется, когда его аргументы берутся из кэша
+	tasks        []plannedTask    // Созданные задачи в порядке создания: аргументы раньше потребителей
+	steps        []string         // Какие поддеревья взяты из кэша
+	root         *Node
+	rootUnit     string // Единица результата выражения: у корня она может отличаться от СИ ("in km/h")
+}
+
+// planTasksRecursive создает задачи для всего дерева сразу и возвращает ID задачи, вычисляющей
+// node (0, если значение node уже известно). Структурно одинаковые поддеревья получают одну
+// общую задачу, так что дерево превращается в DAG. Операции с известными аргументами сначала
+// ищутся в кэше результатов: при попадании узел становится числом и задача не создается.
+func (s *Scheduler) planTasksRecursive(node *Node, plan *taskPlan) (int64, error) {
+	if node == nil || node.Value != nil || node.Tensor != nil || node.Complex != nil || node.Interval != nil {
+		return 0, nil
 	}
-	if err := s.planTasksRecursive(node.Right, expressionID); err != nil {
-		return err
+
+	key := node.String()
+	if id, ok := plan.planned[key]; ok {
+		plan.nodeTasks[node] = id
+		return id, nil
+	}
+	// Единица считается до обхода детей: попадание в кэш превращает их в числа без единиц.
+	unit := siUnit(node)
+	if node == plan.root {
+		unit = plan.rootUnit
 	}
 
-	leftReady := node.Left != nil && node.Left.Value != nil
-	rightReady := node.Right != nil && node.Right.Value != nil
+	leftID, err := s.planTasksRecursive(node.Left, plan)
+	if err != nil {
+		return 0, err
+	}
+	rightID, err := s.planTasksRecursive(node.Right, plan)
+	if err != nil {
+		return 0, err
+	}
 
-	if leftReady && rightReady {
-		_, err := s.dbStore.CreateTask(
-			expressionID,
-			node.Op,
-			*node.Left.Value,
-			*node.Right.Value,
-		)
+	// У функций одного аргумента второй остается нулем.
+	task := &database.Task{ExpressionID: plan.expressionID, Operation: node.Op, NodeKey: key, Unit: unit, Mode: plan.mode,
+		SpecialValues: plan.special}
+	if leftID == 0 {
+		task.Arg1, task.Arg1Imag, task.Arg1Tensor = leafArg(node.Left)
+		task.Arg1Hi = leafHi(node.Left)
+	}
+	if rightID == 0 && node.Right != nil {
+		task.Arg2, task.Arg2Imag, task.Arg2Tensor = leafArg(node.Right)
+		task.Arg2Hi = leafHi(node.Right)
+	}
+
+	// Кэш хранит только действительные числовые результаты.
+	if leftID == 0 && rightID == 0 && plan.useCache && !isTensorTask(task) && !isComplexTask(task) {
+		get := s.cache.Get
+		if plan.dryRun {
+			get = s.cache.Peek
+		}
+		if v, ok := get(node.Op, task.Arg1, task.Arg2, defaultNumericMode); ok {
+			plan.steps = append(plan.steps, fmt.Sprintf("Cached: %s = %v", key, v))
+			node.Value = &v
+			return 0, nil
+		}
+	}
+
+	id := int64(len(plan.tasks) + 1) // В плане без БД задачи нумеруются по порядку
+	if !plan.dryRun {
+		id, err = s.dbStore.CreateTask(task, [2]int64{leftID, rightID})
 		if err != nil {
-			return fmt.Errorf("ошибка создания задачи для операции '%s' выражения ID %d: %w", node.Op, expressionID, err)
+			return 0, fmt.Errorf("ошибка создания задачи для операции '%s' выражения ID %d: %w", node.Op, plan.expressionID, err)
 		}
 	}
+	plan.planned[key] = id
+	plan.nodeTasks[node] = id
+	plan.tasks = append(plan.tasks, plannedTask{id: id, op: node.Op, key: key, children: [2]int64{leftID, rightID}})
+	return id, nil
+}
 
-	return nil
+// plannedTask - задача DAG выражения и ID задач, вычисляющих ее аргументы (0 - аргумент известен).
+type plannedTask struct {
+	id       int64
+	op       string
+	key      string // Ключ поддерева, которое вычисляет задача
+	children [2]int64
 }
 
-func (s *Scheduler) GetOperationTimes() *OperationTimes {
-	return s.opTimes
+// criticalPaths считает для каждой задачи длину оставшегося критического пути: время ее операции
+// плюс самую долгую цепочку потребителей до корня выражения. Обратный порядок создания обходит
+// потребителей раньше их аргументов. Время операции не меньше 1 мс, чтобы при нулевых
+// TIME_*_MS путь определялся глубиной задачи.
+func criticalPaths(tasks []plannedTask, times *OperationTimes) map[int64]int64 {
+	paths := make(map[int64]int64, len(tasks))
+	for i := len(tasks) - 1; i >= 0; i-- {
+		t := tasks[i]
+		path := paths[t.id] + int64(max(times.For(t.op), 1)) // paths[t.id] - самый долгий путь потребителей
+		paths[t.id] = path
+		for _, child := range t.children {
+			if child != 0 && paths[child] < path {
+				paths[child] = path
+			}
+		}
+	}
+	return paths
 }
 
-func fillASTValues(node *Node, doneTasks []database.Task) {
-	if node == nil {
-		return
+// leafArg возвращает аргумент задачи для известного значения: число (действительную и мнимую
+// части, нижнюю границу интервала) или JSON вектора/матрицы.
+func leafArg(n *Node) (float64, float64, string) {
+	if n.Tensor != nil {
+		return 0, 0, n.Tensor.String()
 	}
-	if node.Value != nil {
-		return
+	if n.Complex != nil {
+		return real(*n.Complex), imag(*n.Complex), ""
 	}
-	fillASTValues(node.Left, doneTasks)
-	fillASTValues(node.Right, doneTasks)
-	if node.Op != "" && node.Left != nil && node.Left.Value != nil && node.Right != nil && node.Right.Value != nil {
-		for _, t := range doneTasks {
-			if t.Operation == node.Op && t.Arg1 == *node.Left.Value && t.Arg2 == *node.Right.Value {
-				val := t.Result.Float64
-				node.Value = &val
-				break
-			}
-		}
+	if n.Interval != nil {
+		return n.Interval.Lo, 0, ""
 	}
+	return *n.Value, 0, ""
 }
 
+// leafHi возвращает верхнюю границу аргумента-интервала (в режиме интервалов числа в дереве
+// тоже интервалы) или 0.
+func leafHi(n *Node) float64 {
+	if n.Interval != nil {
+		return n.Interval.Hi
+	}
+	return 0
+}
+
+// isTensorTask сообщает, работает ли задача с векторами: такие задачи не кэшируются.
+// merge возвращает вектор даже из двух чисел.
+func isTensorTask(t *database.Task) bool {
+	return t.Arg1Tensor != "" || t.Arg2Tensor != "" || t.ResultTensor != "" || t.Operation == "merge"
+}
+
+// isComplexTask сообщает, есть ли у задачи комплексный аргумент или результат. Ключ кэша
+// состоит только из действительных частей, поэтому такие задачи тоже не кэшируются:
+// sqrt(-4) с действительным аргументом дает 2i.
+func isComplexTask(t *database.Task) bool {
+	return t.Arg1Imag != 0 || t.Arg2Imag != 0 || t.ResultImag != 0
+}
+
+// isIntervalTask сообщает, считается ли задача в режиме интервалов: ее результат - пара границ,
+// а кэш хранит одно число.
+func isIntervalTask(t *database.Task) bool {
+	return t.Mode == database.ModeInterval
+}
+
+// GetOperationTimes возвращает текущий снимок времени операций. Снимок не меняется: новое
+// время применяется заменой всего снимка.
+func (s *Scheduler) GetOperationTimes() *OperationTimes {
+	return s.opTimes.Load()
+}
+
+// ProcessTaskCompletion передает результат задачи всем ее потребителям в DAG.
+// Если потребителей нет, задача корневая и ее результат - результат выражения.
 func (s *Scheduler) ProcessTaskCompletion(taskID int64) {
 	log.Printf("Scheduler: Обработка завершения/ошибки задачи ID %d", taskID)
 
@@ -135,64 +427,169 @@ func (s *Scheduler) ProcessTaskCompletion(taskID int64) {
 		log.Printf("Scheduler: Задача ID %d не найдена", taskID)
 		return
 	}
-
-	expr, err := s.dbStore.GetExpressionByIDInternal(task.ExpressionID)
-	if err != nil {
-		log.Printf("Scheduler: Ошибка получения выражения ID %d из БД: %v", task.ExpressionID, err)
+	if task.Status == database.StatusError {
+		s.failExpression(task)
 		return
 	}
-	if expr == nil {
-		log.Printf("Scheduler: Выражение ID %d для задачи ID %d не найдено", task.ExpressionID, taskID)
+	if task.Status != database.StatusDone || !task.Result.Valid {
+		log.Printf("Scheduler: Задача ID %d в статусе '%s', результат пока не готов", taskID, task.Status)
 		return
 	}
 
-	parser := NewParser(expr.Expression)
-	ast, err := parser.Parse()
+	// Inf и NaN не кэшируются: ключ кэша не учитывает политику выражения.
+	if !isTensorTask(task) && !isComplexTask(task) && !isIntervalTask(task) && isFinite(task.Result.Float64) {
+		s.cache.Put(task.Operation, task.Arg1, task.Arg2, defaultNumericMode, task.Result.Float64)
+	}
+
+	parents, err := s.dbStore.ResolveTaskConsumers(task)
 	if err != nil {
-		errMsg := fmt.Sprintf("Ошибка парсинга выражения при обработке задачи ID %d: %v", taskID, err)
-		log.Printf("Scheduler: %s", errMsg)
-		s.dbStore.UpdateExpressionStatusResult(expr.ID,
-			database.StatusError,
-			sql.NullFloat64{},
-			sql.NullString{String: errMsg, Valid: true},
-		)
+		log.Printf("Scheduler: Ошибка передачи результата задачи ID %d потребителям: %v", taskID, err)
 		return
 	}
+	if len(parents) > 0 {
+		log.Printf("Scheduler: Результат задачи ID %d передан задачам %v", taskID, parents)
+	}
 
-	allTasks, err := s.dbStore.GetAllTasksForExpression(expr.ID)
-	if err != nil {
-		log.Printf("Scheduler: Ошибка получения задач для выражения ID %d: %v", expr.ID, err)
+	expr := s.recordTaskResult(task, len(parents) == 0)
+	if expr == nil {
+		return
+	}
+	if len(parents) == 0 {
+		s.expressionFinished(expr.ID)
+	}
+	if expr.NoCache || isIntervalTask(task) {
+		return
 	}
-	var doneTasks []database.Task
-	for _, t := range allTasks {
-		if t.Status == database.StatusDone {
-			doneTasks = append(doneTasks, t)
+
+	// Потребители, у которых теперь известны оба аргумента, могут найтись в кэше.
+	for _, parentID := range parents {
+		parent, err := s.dbStore.GetTaskByID(parentID)
+		if err != nil || parent == nil || parent.Status != database.StatusPending || isTensorTask(parent) || isComplexTask(parent) {
+			continue
+		}
+		v, ok := s.cache.Get(parent.Operation, parent.Arg1, parent.Arg2, defaultNumericMode)
+		if !ok {
+			continue
+		}
+		if done, err := s.dbStore.CompleteCachedTask(parent.ID, v); err != nil {
+			log.Printf("Scheduler: %v", err)
+		} else if done {
+			log.Printf("Scheduler: Задача ID %d взята из кэша: %f", parent.ID, v)
+			s.ProcessTaskCompletion(parent.ID)
 		}
 	}
+}
 
-	fillASTValues(ast, doneTasks)
+// recordTaskResult дописывает результат задачи в шаги выражения, а для корневой задачи
+// завершает выражение.
+func (s *Scheduler) recordTaskResult(task *database.Task, isRoot bool) *database.Expression {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	err = s.planTasksRecursive(ast, expr.ID)
+	expr, err := s.dbStore.GetExpressionByIDInternal(task.ExpressionID)
 	if err != nil {
-		log.Printf("Scheduler: Ошибка планирования задач для выражения ID %d: %v", expr.ID, err)
-		return
+		log.Printf("Scheduler: Ошибка получения выражения ID %d из БД: %v", task.ExpressionID, err)
+		return nil
+	}
+	if expr == nil {
+		log.Printf("Scheduler: Выражение ID %d для задачи ID %d не найдено", task.ExpressionID, task.ID)
+		return nil
+	}
+	if expr.Status == database.StatusTimeout {
+		log.Printf("Scheduler: Срок выражения ID %d истек, результат задачи ID %d не используется", expr.ID, task.ID)
+		return nil
 	}
 
-	if ast.Value != nil {
-		result := *ast.Value
+	var steps []string
+	if expr.Steps.Valid {
+		json.Unmarshal([]byte(expr.Steps.String), &steps)
+	}
+	resultInterval := interval.Interval{Lo: task.Result.Float64, Hi: task.ResultHi}
+	switch {
+	case isIntervalTask(task):
+		steps = append(steps, "Result: "+resultInterval.String())
+	case task.ResultTensor != "":
+		steps = append(steps, fmt.Sprintf("Result: %s", task.ResultTensor))
+	case task.ResultImag != 0:
+		steps = append(steps, fmt.Sprintf("Result: %s", formatComplex(complex(task.Result.Float64, task.ResultImag), DefaultPrintOptions)))
+	default:
+		steps = append(steps, fmt.Sprintf("Result: %f", task.Result.Float64))
+	}
+
+	if isRoot && isIntervalTask(task) {
+		result := database.Interval{Lo: resultInterval.Lo, Hi: resultInterval.Hi, Mid: resultInterval.Mid()}
+		s.dbStore.CompleteExpressionInterval(expr.ID, result, stepsJSON(steps))
+		log.Printf("Scheduler: Выражение ID %d успешно завершено с результатом %s.", expr.ID, resultInterval)
+	} else if isRoot && task.ResultTensor != "" {
+		s.dbStore.CompleteExpressionTensor(expr.ID, task.ResultTensor, stepsJSON(steps))
+		log.Printf("Scheduler: Выражение ID %d успешно завершено с результатом %s.", expr.ID, task.ResultTensor)
+	} else if isRoot && task.ResultImag != 0 {
+		s.dbStore.CompleteExpressionComplex(expr.ID, database.Complex{Re: task.Result.Float64, Im: task.ResultImag}, stepsJSON(steps))
+		log.Printf("Scheduler: Выражение ID %d успешно завершено с результатом %v%+vi.", expr.ID, task.Result.Float64, task.ResultImag)
+	} else if isRoot {
+		result := task.Result.Float64
 		s.dbStore.UpdateExpressionStatusResult(expr.ID,
 			database.StatusDone,
 			sql.NullFloat64{Float64: result, Valid: true},
-			sql.NullString{},
+			stepsJSON(steps),
 		)
 		log.Printf("Scheduler: Выражение ID %d успешно завершено с результатом %f.", expr.ID, result)
 	} else {
 		s.dbStore.UpdateExpressionStatusResult(expr.ID,
 			database.StatusInProgress,
 			sql.NullFloat64{},
-			sql.NullString{},
+			stepsJSON(steps),
 		)
 	}
+	return expr
+}
+
+// failExpression завершает выражение ошибкой, когда одна из его задач завершилась постоянной ошибкой.
+func (s *Scheduler) failExpression(task *database.Task) {
+	s.mu.Lock()
+	expr, err := s.dbStore.GetExpressionByIDInternal(task.ExpressionID)
+	if err != nil || expr == nil || expr.Status == database.StatusError {
+		s.mu.Unlock()
+		return
+	}
+	errMsg := fmt.Sprintf("Ошибка выполнения задачи ID %d (%s): %s", task.ID, task.NodeKey, task.Error)
+	s.dbStore.UpdateExpressionStatusResult(expr.ID, database.StatusError, sql.NullFloat64{}, sql.NullString{String: errMsg, Valid: true})
+	s.mu.Unlock()
+
+	log.Printf("Scheduler: Выражение ID %d завершено с ошибкой: %s", expr.ID, errMsg)
+	s.expressionFinished(expr.ID)
+}
+
+// expressionFinished вызывается после того, как выражение получило статус done или error:
+// планирует выражения, ожидавшие его результата, и обновляет родительскую задачу,
+// если выражение ей принадлежит.
+func (s *Scheduler) expressionFinished(expressionID int64) {
+	dependents, err := s.dbStore.TakeDependentExpressions(expressionID)
+	if err != nil {
+		log.Printf("Scheduler: %v", err)
+	}
+	for _, dep := range dependents {
+		log.Printf("Scheduler: Выражение ID %d завершено, планируем ожидавшее его выражение ID %d", expressionID, dep.ID)
+		if err := s.ScheduleTasks(dep.ID, dep.Expression, dep.ExpressionOptions); err != nil {
+			log.Printf("Scheduler: %v", err)
+		}
+	}
+
+	expr, err := s.dbStore.GetExpressionByIDInternal(expressionID)
+	if err != nil || expr == nil || expr.JobID == 0 {
+		return
+	}
+	job, err := s.dbStore.RefreshJobProgress(expr.JobID)
+	if err != nil {
+		log.Printf("Scheduler: %v", err)
+		return
+	}
+	switch job.Kind {
+	case database.JobKindSweep:
+		s.sweepProgress(job)
+	case database.JobKindSolve:
+		s.solverStep(job.ID, expr)
+	}
 }
 
 func initOperationTimes() *OperationTimes {
@@ -201,6 +598,8 @@ func initOperationTimes() *OperationTimes {
 		Subtraction:    readTimeEnv("TIME_SUBTRACTION_MS", 1000),
 		Multiplication: readTimeEnv("TIME_MULTIPLICATION_MS", 1000),
 		Division:       readTimeEnv("TIME_DIVISION_MS", 1000),
+		Power:          readTimeEnv("TIME_POWER_MS", 1000),
+		Function:       readTimeEnv("TIME_FUNCTION_MS", 1000),
 	}
 }
 
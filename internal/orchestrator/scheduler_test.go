@@ -0,0 +1,880 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"calculator/internal/interval"
+	"calculator/internal/tensor"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupScheduler(t *testing.T) (*Scheduler, *database.Store) {
+	store, err := database.NewStore(":memory:")
+	if err != nil {
+		if strings.Contains(err.Error(), "CGO_ENABLED") {
+			t.Skipf("skip scheduler tests due DB init error: %v", err)
+		}
+		t.Fatalf("NewStore error: %v", err)
+	}
+	if err := store.InitDB(); err != nil {
+		if strings.Contains(err.Error(), "CGO_ENABLED") {
+			t.Skipf("skip scheduler tests due DB migration error: %v", err)
+		}
+		t.Fatalf("InitDB error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	s := NewScheduler(store)
+	s.optimizer = NewOptimizer(OptimizerConfig{})
+	return s, store
+}
+
+// runAgent выполняет все доступные задачи так, как это делал бы агент.
+func runAgent(t *testing.T, s *Scheduler, store *database.Store) int {
+	executed := 0
+	for {
+		task, err := store.GetAndLeasePendingTask(database.AgentCapabilities{})
+		if err != nil {
+			t.Fatalf("GetAndLeasePendingTask error: %v", err)
+		}
+		if task == nil {
+			return executed
+		}
+		switch {
+		case isIntervalTask(task):
+			r, ierr := interval.Apply(task.Operation, interval.Interval{Lo: task.Arg1, Hi: task.Arg1Hi}, interval.Interval{Lo: task.Arg2, Hi: task.Arg2Hi})
+			if ierr != nil {
+				err = store.FailTask(task.ID, ierr.Error(), true)
+			} else {
+				err = store.CompleteIntervalTask(task.ID, r.Lo, r.Hi)
+			}
+		case isTensorTask(task):
+			completeTensorTask(t, store, task)
+		case isComplexTask(task) || (task.Operation == "sqrt" && task.Arg1 < 0):
+			completeComplexTask(t, store, task)
+		default:
+			result, ok := evalTask(task.Operation, task.Arg1, task.Arg2)
+			if !ok && isFinite(result) {
+				t.Fatalf("cannot evaluate task %+v", task)
+			}
+			if !ok && task.SpecialValues != database.SpecialIEEE {
+				err = store.FailTask(task.ID, "переполнение", true) // Как агент в политике strict
+			} else {
+				err = store.CompleteTask(task.ID, result)
+			}
+		}
+		if err != nil {
+			t.Fatalf("complete task %+v error: %v", task, err)
+		}
+		s.ProcessTaskCompletion(task.ID)
+		executed++
+	}
+}
+
+// evalTask вычисляет операцию задачи, включая степень и функции одного аргумента.
+func evalTask(op string, a, b float64) (float64, bool) {
+	if unaryFunctions[op] {
+		return evalFunction(op, a)
+	}
+	return evalSymbolic(op, a, b)
+}
+
+// completeTensorTask выполняет задачу с векторными аргументами так же, как агент.
+func completeTensorTask(t *testing.T, store *database.Store, task *database.Task) {
+	arg := func(v float64, encoded string) *tensor.Tensor {
+		if encoded == "" {
+			return tensor.Scalar(v)
+		}
+		parsed, err := tensor.Parse(encoded)
+		if err != nil {
+			t.Fatalf("bad tensor argument %q: %v", encoded, err)
+		}
+		return parsed
+	}
+	result, err := tensor.Apply(task.Operation, arg(task.Arg1, task.Arg1Tensor), arg(task.Arg2, task.Arg2Tensor),
+		func(a, b float64, op string) (float64, error) {
+			v, ok := evalTask(op, a, b)
+			if !ok {
+				return 0, fmt.Errorf("cannot evaluate %v %s %v", a, op, b)
+			}
+			return v, nil
+		})
+	if err != nil {
+		t.Fatalf("cannot evaluate task %+v: %v", task, err)
+	}
+	if result.IsScalar() {
+		err = store.CompleteTask(task.ID, result.Data[0])
+	} else {
+		err = store.CompleteTensorTask(task.ID, result.String())
+	}
+	if err != nil {
+		t.Fatalf("complete task error: %v", err)
+	}
+}
+
+// completeComplexTask выполняет задачу в комплексных числах так же, как агент.
+func completeComplexTask(t *testing.T, store *database.Store, task *database.Task) {
+	a, b := complex(task.Arg1, task.Arg1Imag), complex(task.Arg2, task.Arg2Imag)
+	z, ok := map[string]complex128{"+": a + b, "-": a - b, "*": a * b, "sqrt": cmplx.Sqrt(a), "abs": complex(cmplx.Abs(a), 0)}[task.Operation]
+	if !ok {
+		t.Fatalf("cannot evaluate complex task %+v", task)
+	}
+	var err error
+	if imag(z) == 0 {
+		err = store.CompleteTask(task.ID, real(z))
+	} else {
+		err = store.CompleteComplexTask(task.ID, real(z), imag(z))
+	}
+	if err != nil {
+		t.Fatalf("complete task error: %v", err)
+	}
+}
+
+func TestSchedulerSharesCommonSubexpressions(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("dag", "hash")
+	exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: "((1+2)*(1+2))/(1+2)"})
+
+	if err := s.ScheduleTasks(exprID, "((1+2)*(1+2))/(1+2)", database.ExpressionOptions{}); err != nil {
+		t.Fatalf("ScheduleTasks error: %v", err)
+	}
+	tasks, _ := store.GetAllTasksForExpression(exprID)
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 tasks (+, *, /), got %d", len(tasks))
+	}
+	edges, _ := store.GetTaskEdges(exprID)
+	if len(edges) != 4 {
+		t.Fatalf("expected 4 edges, got %d", len(edges))
+	}
+
+	if executed := runAgent(t, s, store); executed != 3 {
+		t.Fatalf("expected 3 executed tasks, got %d", executed)
+	}
+	expr, _ := store.GetExpressionByIDInternal(exprID)
+	if expr.Status != database.StatusDone || !expr.Result.Valid || expr.Result.Float64 != 3 {
+		t.Fatalf("expected done with result 3, got status=%s result=%v", expr.Status, expr.Result)
+	}
+}
+
+func TestSchedulerMemoCache(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("memo", "hash")
+
+	schedule := func(opts database.ExpressionOptions) int {
+		exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: "(1024*1024)+1", ExpressionOptions: opts})
+		s.ScheduleTasks(exprID, "(1024*1024)+1", opts)
+		executed := runAgent(t, s, store)
+		if expr := mustExpression(t, store, exprID); expr.Status != database.StatusDone || expr.Result.Float64 != 1048577 {
+			t.Errorf("expression %d: status=%s result=%v", exprID, expr.Status, expr.Result)
+		}
+		return executed
+	}
+
+	if executed := schedule(database.ExpressionOptions{}); executed != 2 {
+		t.Fatalf("expected 2 executed tasks, got %d", executed)
+	}
+	if executed := schedule(database.ExpressionOptions{}); executed != 0 {
+		t.Fatalf("expected cached expression to need no agents, got %d tasks", executed)
+	}
+	if executed := schedule(database.ExpressionOptions{NoCache: true}); executed != 2 {
+		t.Fatalf("expected no_cache expression to run 2 tasks, got %d", executed)
+	}
+	if stats := s.GetCache().Stats(); stats.Hits != 2 || stats.Size != 2 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+}
+
+func TestSchedulerScheduledAST(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("ast", "hash")
+	schedule := func(expression string) int64 {
+		exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: expression})
+		if err := s.ScheduleTasks(exprID, expression, database.ExpressionOptions{}); err != nil {
+			t.Fatalf("ScheduleTasks(%q) error: %v", expression, err)
+		}
+		return exprID
+	}
+	schedule("(1+2)*3")
+	runAgent(t, s, store)
+
+	// 1+2 берется из кэша: задача корня создана с ключом ((1+2)*4), а в дереве остается 3*4.
+	exprID := schedule("(1+2)*4")
+	tasks, _ := store.GetAllTasksForExpression(exprID)
+	data, err := store.GetExpressionAST(exprID)
+	var node ASTNode
+	if err != nil || json.Unmarshal([]byte(data), &node) != nil || len(tasks) != 1 {
+		t.Fatalf("scheduled AST %q: err=%v tasks=%d", data, err, len(tasks))
+	}
+	ast, byKey := importJSON(&node, map[int64]database.Task{tasks[0].ID: tasks[0]})
+	if ast.String() != "(3*4)" || byKey["(3*4)"].ID != tasks[0].ID {
+		t.Errorf("scheduled AST = %s, tasks %v; want (3*4) bound to task %d", ast, byKey, tasks[0].ID)
+	}
+}
+
+func TestMemoCacheLimits(t *testing.T) {
+	c := NewMemoCache(time.Hour, 2)
+	c.Put("+", 1, 1, defaultNumericMode, 2)
+	c.Put("+", 2, 2, defaultNumericMode, 4)
+	c.Get("+", 1, 1, defaultNumericMode)
+	c.Put("+", 3, 3, defaultNumericMode, 6)
+	if _, ok := c.Get("+", 2, 2, defaultNumericMode); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if v, ok := c.Get("+", 1, 1, defaultNumericMode); !ok || v != 2 {
+		t.Errorf("Get(1+1) = %v, %v", v, ok)
+	}
+	if _, ok := c.Get("+", 1, 1, "interval"); ok {
+		t.Error("entries of different numeric modes must not match")
+	}
+
+	expired := NewMemoCache(time.Millisecond, 10)
+	expired.Put("*", 2, 3, defaultNumericMode, 6)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := expired.Get("*", 2, 3, defaultNumericMode); ok {
+		t.Error("expired entry returned")
+	}
+}
+
+func TestExpandSweep(t *testing.T) {
+	from, to, step := 0.0, 1.0, 0.25
+	ranges := map[string]SweepRange{
+		"x": {From: &from, To: &to, Step: &step},
+		"y": {Values: []float64{10, 20}},
+	}
+	points, err := expandSweep([]string{"x", "y"}, ranges, 100)
+	if err != nil || len(points) != 10 {
+		t.Fatalf("expandSweep: %d points, err=%v; want 10", len(points), err)
+	}
+	if last := points[9]; last["x"] != 1 || last["y"] != 20 {
+		t.Errorf("unexpected last point: %v", last)
+	}
+	if _, err := expandSweep([]string{"x", "y"}, ranges, 5); err == nil {
+		t.Error("expected error for too many points")
+	}
+	// Огромный диапазон отклоняется до выделения памяти под значения.
+	end, one := math.Inf(1), 1.0
+	if _, err := expandSweep([]string{"x"}, map[string]SweepRange{"x": {From: &from, To: &end, Step: &one}}, 100); err == nil || !strings.Contains(err.Error(), "слишком много точек") {
+		t.Errorf("range 0..+Inf: expected too many points error, got %v", err)
+	}
+}
+
+func TestSchedulerSweepJobProgress(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("sweep", "hash")
+	ast, _ := NewParser("x*x+1").Parse()
+	points, _ := expandSweep([]string{"x"}, map[string]SweepRange{"x": {Values: []float64{1, 2, 3}}}, 100)
+
+	jobID, err := store.CreateJob(&database.Job{UserID: userID, Kind: database.JobKindSweep, Expression: "x*x+1", Total: len(points)})
+	if err != nil {
+		t.Fatalf("CreateJob error: %v", err)
+	}
+	for _, point := range points {
+		expr := Substitute(ast, point).Pretty()
+		exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: expr, JobID: jobID})
+		s.ScheduleTasks(exprID, expr, database.ExpressionOptions{})
+	}
+	runAgent(t, s, store)
+
+	job, _ := store.GetJobByID(jobID, userID)
+	if job.Status != database.StatusDone || job.Completed != 3 || job.Failed != 0 {
+		t.Fatalf("unexpected job state: %+v", job)
+	}
+	expressions, _ := store.GetJobExpressions(jobID)
+	if expressions[2].Result.Float64 != 10 {
+		t.Errorf("point x=3: expected 10, got %v", expressions[2].Result)
+	}
+}
+
+func TestSweepCSVTypedResults(t *testing.T) {
+	resp := SweepResponse{Vars: []string{"x"}, Points: []SweepPoint{
+		{Bindings: map[string]float64{"x": 1}, Status: database.StatusDone, ResultTensor: json.RawMessage(`[1,2]`)},
+		{Bindings: map[string]float64{"x": 2}, Status: database.StatusDone, ResultComplex: &database.Complex{Re: 3, Im: 4}},
+		{Bindings: map[string]float64{"x": 3}, Status: database.StatusDone, Unit: "m",
+			Result: database.Number{NullFloat64: sql.NullFloat64{Float64: 4, Valid: true}}},
+	}}
+	rec := httptest.NewRecorder()
+	writeSweepCSV(rec, resp)
+	want := "x,result,unit,status\n1,\"[1,2]\",,done\n2,3+4i,,done\n3,4,m,done\n"
+	if rec.Body.String() != want {
+		t.Fatalf("unexpected CSV:\n%s", rec.Body.String())
+	}
+}
+
+func TestSchedulerSolver(t *testing.T) {
+	for _, method := range []string{SolveBisection, SolveNewton} {
+		t.Run(method, func(t *testing.T) {
+			s, store := setupScheduler(t)
+			userID, _ := store.CreateUser("solver", "hash")
+			state := &solverState{
+				Method: method, Var: "x", F: "x*x-2", DF: "2*x",
+				Tolerance: 1e-9, MaxIterations: 100,
+				Lo: 0, Hi: 2, Pending: map[int64]string{},
+			}
+			job, err := s.startSolver(userID, "x*x=2", state)
+			if err != nil {
+				t.Fatalf("startSolver error: %v", err)
+			}
+			if expr, _ := store.GetExpressionByIDInternal(state.ExpressionID); expr.Status != database.StatusWaiting {
+				t.Fatalf("expected waiting equation while solving, got %s", expr.Status)
+			}
+			runAgent(t, s, store)
+
+			job, _ = store.GetJobByID(job.ID, userID)
+			expr, _ := store.GetExpressionByIDInternal(state.ExpressionID)
+			if job.Status != database.StatusDone || expr.Status != database.StatusDone || math.Abs(expr.Result.Float64-math.Sqrt2) > 1e-6 {
+				t.Fatalf("expected root sqrt(2), got job=%s status=%s result=%v", job.Status, expr.Status, expr.Result)
+			}
+			if !strings.Contains(expr.Steps.String, "Iteration 1:") {
+				t.Errorf("expected iteration history in steps, got %s", expr.Steps.String)
+			}
+		})
+	}
+}
+
+func TestSchedulerSolverNoSignChange(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("solver", "hash")
+	state := &solverState{Method: SolveBisection, Var: "x", F: "x*x+1", Tolerance: 1e-9, MaxIterations: 100, Lo: -1, Hi: 1, Pending: map[int64]string{}}
+	job, err := s.startSolver(userID, "x*x+1=0", state)
+	if err != nil {
+		t.Fatalf("startSolver error: %v", err)
+	}
+	runAgent(t, s, store)
+
+	job, _ = store.GetJobByID(job.ID, userID)
+	if expr, _ := store.GetExpressionByIDInternal(state.ExpressionID); job.Status != database.StatusError || expr.Status != database.StatusError {
+		t.Fatalf("expected error, got job=%s expression=%s", job.Status, expr.Status)
+	}
+}
+
+func TestSchedulerSolverFoldedEvals(t *testing.T) {
+	s, store := setupScheduler(t)
+	s.optimizer = NewOptimizer(OptimizerConfig{FoldOps: map[string]bool{"-": true, "*": true}})
+	userID, _ := store.CreateUser("solver", "hash")
+	state := &solverState{
+		Method: SolveBisection, Var: "x", F: "x*x-2",
+		MaxIterations: 200, Lo: 0, Hi: 2, Pending: map[int64]string{},
+	}
+	// Все вычисления сворачиваются без агентов: решатель доходит до конца внутри startSolver.
+	job, err := s.startSolver(userID, "x*x=2", state)
+	if err != nil {
+		t.Fatalf("startSolver error: %v", err)
+	}
+	job, _ = store.GetJobByID(job.ID, userID)
+	expr, _ := store.GetExpressionByIDInternal(state.ExpressionID)
+	if job.Status != database.StatusError || !strings.Contains(expr.Steps.String, "не сошелся за 200") {
+		t.Fatalf("expected solver to stop after 200 folded iterations, got job=%s steps=%s", job.Status, expr.Steps.String)
+	}
+}
+
+func TestSchedulerTensorExpressions(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("tensor", "hash")
+
+	for expression, want := range map[string]string{"[[1,2],[3,4]]*[[5,6],[7,8]]+1": "[[20,23],[44,51]]", "[1,2,3]*2": "[2,4,6]"} {
+		if expr := evaluate(t, s, store, userID, expression, database.ExpressionOptions{}); expr.Status != database.StatusDone || string(expr.ResultTensor) != want || expr.Result.Valid {
+			t.Errorf("%q: status=%s result=%v tensor=%s, want %s", expression, expr.Status, expr.Result, expr.ResultTensor, want)
+		}
+	}
+	// dot возвращает число, которое дальше считается обычными задачами.
+	if expr := evaluate(t, s, store, userID, "dot([1,2],[3,4])*2", database.ExpressionOptions{}); expr.Status != database.StatusDone || expr.Result.Float64 != 22 || expr.ResultTensor != nil {
+		t.Errorf("dot: status=%s result=%v tensor=%s", expr.Status, expr.Result, expr.ResultTensor)
+	}
+}
+
+func TestSchedulerAggregates(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("aggregates", "hash")
+
+	tests := map[string]float64{"avg(3, 5, 8, 13)": 7.25, "median(5, 1, 4, 2)": 3, "stddev(2, 4, 4, 4, 5, 5, 7, 9)": 2, "sum(2*3, 4) + avg(1)": 11}
+	for expression, want := range tests {
+		if expr := evaluate(t, s, store, userID, expression, database.ExpressionOptions{}); expr.Status != database.StatusDone || math.Abs(expr.Result.Float64-want) > 1e-9 {
+			t.Errorf("%q: status=%s result=%v, want %v", expression, expr.Status, expr.Result, want)
+		}
+	}
+
+	// Частичные суммы независимы: все попарные суммы листьев доступны агентам сразу.
+	exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: "sum(1,2,3,4,5,6,7,8)"})
+	s.ScheduleTasks(exprID, "sum(1,2,3,4,5,6,7,8)", database.ExpressionOptions{})
+	ready := 0
+	for task, _ := store.GetAndLeasePendingTask(database.AgentCapabilities{}); task != nil; task, _ = store.GetAndLeasePendingTask(database.AgentCapabilities{}) {
+		ready++
+	}
+	if ready != 4 {
+		t.Errorf("ready tasks = %d, want 4", ready)
+	}
+}
+
+func TestSchedulerUnits(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("units", "hash")
+
+	tests := []struct {
+		expression string
+		want       float64
+		unit       string
+	}{
+		{"5 km + 300 m", 5300, "m"},
+		{"60 mph in km/h", 96.56064, "km/h"},
+		{"2 kg * 9.8 m/s^2", 19.6, "kg*m/s^2"},
+	}
+	for _, tc := range tests {
+		if expr := evaluate(t, s, store, userID, tc.expression, database.ExpressionOptions{}); expr.Status != database.StatusDone || math.Abs(expr.Result.Float64-tc.want) > 1e-9 || expr.Unit != tc.unit {
+			t.Errorf("%q: status=%s result=%v %q, want %v %q", tc.expression, expr.Status, expr.Result, expr.Unit, tc.want, tc.unit)
+		}
+	}
+
+	// Промежуточные задачи несут единицу результата в СИ, корневая - единицу выражения.
+	exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: "(2 km + 3 km) in mi"})
+	s.ScheduleTasks(exprID, "(2 km + 3 km) in mi", database.ExpressionOptions{})
+	if tasks, _ := store.GetAllTasksForExpression(exprID); len(tasks) != 2 || tasks[0].Unit != "m" || tasks[1].Unit != "mi" {
+		t.Errorf("task units = %+v, want m and mi", tasks)
+	}
+	if err := s.ScheduleTasks(exprID, "3 m + 2 s", database.ExpressionOptions{}); err == nil {
+		t.Error("expected dimension error for 3 m + 2 s")
+	}
+}
+
+func TestSchedulerUserFunctions(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("functions", "hash")
+	otherID, _ := store.CreateUser("other", "hash")
+	store.SaveFunction(&database.Function{UserID: userID, Name: "tax", Params: []string{"x"}, Body: "x * 0.13"})
+
+	if expr := evaluate(t, s, store, userID, "tax(1000) + 50", database.ExpressionOptions{}); expr.Status != database.StatusDone || math.Abs(expr.Result.Float64-180) > 1e-9 {
+		t.Errorf("tax(1000) + 50: status=%s result=%v, want 180", expr.Status, expr.Result)
+	}
+	// Функции видны только своему пользователю.
+	if expr := evaluate(t, s, store, otherID, "tax(1000)", database.ExpressionOptions{}); expr.Status != database.StatusError {
+		t.Errorf("tax(1000) of another user: status=%s, want error", expr.Status)
+	}
+}
+
+func TestSchedulerReferences(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("references", "hash")
+	otherID, _ := store.CreateUser("other", "hash")
+	submit := func(user int64, expression string) (int64, error) {
+		id, _ := store.CreateExpression(&database.Expression{UserID: user, Expression: expression})
+		return id, s.ScheduleTasks(id, expression, database.ExpressionOptions{})
+	}
+
+	first, _ := submit(userID, "60 km + 40 km")
+	// Первое выражение еще вычисляется: второе ждет его, а не завершается ошибкой.
+	second, err := submit(userID, fmt.Sprintf("$%d * 1.5 in km", first))
+	if expr, _ := store.GetExpressionByIDInternal(second); err != nil || expr.Status != database.StatusWaiting {
+		t.Fatalf("status = %s err=%v, want %s", expr.Status, err, database.StatusWaiting)
+	}
+	runAgent(t, s, store)
+	if expr, _ := store.GetExpressionByIDInternal(second); expr.Status != database.StatusDone || math.Abs(expr.Result.Float64-150) > 1e-9 || expr.Unit != "km" {
+		t.Errorf("second: status=%s result=%v unit=%q, want 150 km", expr.Status, expr.Result, expr.Unit)
+	}
+
+	// Чужие, будущие и завершившиеся с ошибкой выражения недоступны.
+	failed, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: "1 / 0"})
+	store.UpdateExpressionStatusResult(failed, database.StatusError, sql.NullFloat64{}, sql.NullString{})
+	for _, input := range []string{fmt.Sprintf("$%d + 1", failed), "$999 + 1"} {
+		if _, err := submit(userID, input); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+	if _, err := submit(otherID, fmt.Sprintf("$%d + 1", first)); err == nil {
+		t.Error("expected error for reference to another user's expression")
+	}
+}
+
+func TestSchedulerComplexNumbers(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("complex", "hash")
+
+	tests := []struct {
+		expression string
+		want       *database.Complex // nil - действительный результат wantReal
+		wantReal   float64
+	}{
+		{"(3+4i) * (1-2i)", &database.Complex{Re: 11, Im: -2}, 0},
+		{"sqrt(-4) + 1", &database.Complex{Re: 1, Im: 2}, 0},
+		{"abs(3+4i)", nil, 5},
+		{"(1+2i) * (1-2i)", nil, 5},
+	}
+	for _, tc := range tests {
+		expr := evaluate(t, s, store, userID, tc.expression, database.ExpressionOptions{})
+		switch {
+		case expr.Status != database.StatusDone:
+			t.Errorf("%q: status=%s", tc.expression, expr.Status)
+		case tc.want == nil && (expr.ResultComplex != nil || math.Abs(expr.Result.Float64-tc.wantReal) > 1e-9),
+			tc.want != nil && (expr.Result.Valid || expr.ResultComplex == nil || *expr.ResultComplex != *tc.want):
+			t.Errorf("%q: result=%v complex=%v, want %v %v", tc.expression, expr.Result, expr.ResultComplex, tc.want, tc.wantReal)
+		}
+	}
+
+	// Корень из отрицательного числа не кэшируется как действительный результат.
+	exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: "sqrt(-4)"})
+	s.ScheduleTasks(exprID, "sqrt(-4)", database.ExpressionOptions{})
+	if n := runAgent(t, s, store); n != 1 {
+		t.Errorf("sqrt(-4) executed %d tasks, want 1", n)
+	}
+}
+
+func TestSchedulerIntervalMode(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("interval", "hash")
+	opts := database.ExpressionOptions{Mode: database.ModeInterval}
+
+	tests := []struct {
+		expression string
+		lo, hi     float64 // Точные границы: результат должен их содержать и быть не намного шире
+	}{
+		{"[9.8, 9.82] * [2, 2.1]", 19.6, 20.622},
+		{"0.1 + 0.2", 0.3, 0.3},
+		{"[-2, 3]^2", 0, 9},
+		{"sqrt([4, 9]) / 2", 1, 1.5},
+	}
+	for _, tc := range tests {
+		expr := evaluate(t, s, store, userID, tc.expression, opts)
+		if r := expr.ResultInterval; expr.Status != database.StatusDone || r == nil {
+			t.Errorf("%q: status=%s interval=%v steps=%v", tc.expression, expr.Status, r, expr.Steps)
+		} else if r.Lo > tc.lo || r.Hi < tc.hi || tc.lo-r.Lo > 1e-9 || r.Hi-tc.hi > 1e-9 || expr.Result.Float64 != r.Mid {
+			t.Errorf("%q: got [%v, %v] mid %v, want enclosure of [%v, %v]", tc.expression, r.Lo, r.Hi, expr.Result, tc.lo, tc.hi)
+		}
+	}
+	for _, input := range []string{"1 / [-1, 1]", "[2, 1]"} {
+		if expr := evaluate(t, s, store, userID, input, opts); expr.Status != database.StatusError {
+			t.Errorf("%q: status=%s, want error", input, expr.Status)
+		}
+	}
+
+	// В обычном режиме интервал по ссылке не допускается.
+	first := evaluate(t, s, store, userID, "[1, 2] * 2", opts)
+	if ref := evaluate(t, s, store, userID, fmt.Sprintf("$%d + 1", first.ID), database.ExpressionOptions{}); ref.Status != database.StatusError {
+		t.Errorf("interval reference in real mode: status=%s", ref.Status)
+	}
+}
+
+func TestSchedulerSpecialValues(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("special", "hash")
+	strict, ieee := database.ExpressionOptions{}, database.ExpressionOptions{SpecialValues: database.SpecialIEEE}
+
+	if expr := evaluate(t, s, store, userID, "10^308 * 10", strict); expr.Status != database.StatusError {
+		t.Errorf("strict overflow: status=%s result=%v, want error", expr.Status, expr.Result)
+	}
+	for expression, want := range map[string]string{"-(10^308) * 10": `"-Inf"`, "10^308 * 10 * 0": `"NaN"`, "1 / (10^308 * 10)": `0`} {
+		expr := evaluate(t, s, store, userID, expression, ieee)
+		if data, err := json.Marshal(expr); expr.Status != database.StatusDone || err != nil || !strings.Contains(string(data), `"result":`+want+`,`) {
+			t.Errorf("ieee %q: status=%s JSON %s err=%v, want result %s", expression, expr.Status, data, err, want)
+		}
+	}
+
+	// Бесконечность по ссылке из выражения с политикой ieee - ошибка в выражении с политикой strict.
+	inf := evaluate(t, s, store, userID, "10^308 * 10", ieee)
+	if ref := evaluate(t, s, store, userID, fmt.Sprintf("$%d + 1", inf.ID), strict); ref.Status != database.StatusError {
+		t.Errorf("strict reference to Inf: status=%s result=%v", ref.Status, ref.Result)
+	}
+}
+
+func TestSchedulerFairShare(t *testing.T) {
+	s, store := setupScheduler(t)
+	alice, _ := store.CreateUser("alice", "hash")
+	bob, _ := store.CreateUser("bob", "hash")
+
+	owner := make(map[int64]int64)
+	submit := func(userID int64, n int, opts database.ExpressionOptions) {
+		for i := 0; i < n; i++ {
+			expression := fmt.Sprintf("%d + %d", userID, i)
+			exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: expression, ExpressionOptions: opts})
+			s.ScheduleTasks(exprID, expression, opts)
+			owner[exprID] = userID
+		}
+	}
+	// lease выдает n задач, не выполняя их, и считает, сколько досталось каждому пользователю.
+	lease := func(n int) map[int64]int {
+		got := make(map[int64]int)
+		for i := 0; i < n; i++ {
+			task, err := store.GetAndLeasePendingTask(database.AgentCapabilities{})
+			if err != nil || task == nil {
+				t.Fatalf("lease %d: task=%v err=%v", i, task, err)
+			}
+			got[owner[task.ExpressionID]]++
+		}
+		return got
+	}
+
+	// Пакет alice отправлен раньше, но bob не ждет его завершения.
+	submit(alice, 20, database.ExpressionOptions{})
+	submit(bob, 10, database.ExpressionOptions{})
+	if got := lease(4); got[alice] != 2 || got[bob] != 2 {
+		t.Errorf("equal weights: got %v, want 2 tasks each", got)
+	}
+
+	if ok, err := store.UpdateUserScheduling(alice, database.RoleUser, 2); !ok || err != nil {
+		t.Fatalf("UpdateUserScheduling: ok=%v err=%v", ok, err)
+	}
+	if got := lease(6); got[alice] != 4 || got[bob] != 2 {
+		t.Errorf("alice weight 2: got %v, want 4:2", got)
+	}
+
+	// Выражение с более высоким приоритетом обходит очередь независимо от доли пользователя.
+	submit(bob, 2, database.ExpressionOptions{Priority: 3})
+	if got := lease(2); got[bob] != 2 {
+		t.Errorf("priority 3: got %v, want both tasks for bob", got)
+	}
+}
+
+func TestSchedulerCriticalPath(t *testing.T) {
+	s, store := setupScheduler(t)
+	s.opTimes.Store(&OperationTimes{Addition: 100, Multiplication: 500})
+	userID, _ := store.CreateUser("critical", "hash")
+
+	// Слева два независимых листа, справа цепочка умножений: она определяет время результата.
+	expression := "((1 + 2) + (3 + 4)) + (((7 * 8) * 9) * 10)"
+	exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: expression})
+	s.ScheduleTasks(exprID, expression, database.ExpressionOptions{})
+
+	task, err := store.GetAndLeasePendingTask(database.AgentCapabilities{})
+	if err != nil || task == nil {
+		t.Fatalf("GetAndLeasePendingTask: task=%v err=%v", task, err)
+	}
+	if task.Operation != "*" || task.Arg1 != 7 || task.CriticalPath != 1600 {
+		t.Errorf("first leased task %s (critical path %d), want 7 * 8 with path 1600", task.NodeKey, task.CriticalPath)
+	}
+	store.CompleteTask(task.ID, 56)
+	s.ProcessTaskCompletion(task.ID)
+
+	// Следующий в цепочке (56 * 9, путь 1100) обходит листья слева (путь 300).
+	if task, _ = store.GetAndLeasePendingTask(database.AgentCapabilities{}); task == nil || task.Operation != "*" || task.Arg1 != 56 {
+		t.Fatalf("second leased task = %+v, want 56 * 9", task)
+	}
+	store.CompleteTask(task.ID, 504)
+	s.ProcessTaskCompletion(task.ID)
+	runAgent(t, s, store)
+	if expr, _ := store.GetExpressionByIDInternal(exprID); expr.Status != database.StatusDone || expr.Result.Float64 != 5050 {
+		t.Errorf("expression status=%s result=%v", expr.Status, expr.Result)
+	}
+}
+
+func TestSchedulerPlanPreview(t *testing.T) {
+	s, store := setupScheduler(t)
+	s.opTimes.Store(&OperationTimes{Addition: 100, Multiplication: 500})
+	userID, _ := store.CreateUser("preview", "hash")
+
+	expression := "((1 + 2) + (3 + 4)) + (((7 * 8) * 9) * 10)"
+	preview, err := s.PreviewPlan(userID, expression, database.ExpressionOptions{})
+	if err != nil {
+		t.Fatalf("PreviewPlan error: %v", err)
+	}
+	if len(preview.Tasks) != 7 || preview.Operations["+"] != 4 || preview.Operations["*"] != 3 {
+		t.Errorf("tasks=%d operations=%v, want 7 tasks: 4 additions and 3 multiplications", len(preview.Tasks), preview.Operations)
+	}
+	if preview.Depth != 4 || preview.SequentialMs != 1900 || preview.ParallelMs != 1600 {
+		t.Errorf("depth=%d sequential=%d parallel=%d, want 4, 1900 and 1600", preview.Depth, preview.SequentialMs, preview.ParallelMs)
+	}
+
+	// Подвыражение из кэша не попадает в план, а сам просмотр не меняет статистику кэша.
+	s.cache.Put("*", 7, 8, defaultNumericMode, 56)
+	before := s.GetCache().Stats()
+	if preview, _ = s.PreviewPlan(userID, expression, database.ExpressionOptions{}); len(preview.Tasks) != 6 || len(preview.Cached) != 1 || preview.ParallelMs != 1100 {
+		t.Errorf("tasks=%d cached=%v parallel=%d, want 6 tasks with 7 * 8 cached", len(preview.Tasks), preview.Cached, preview.ParallelMs)
+	}
+	if after := s.GetCache().Stats(); after != before {
+		t.Errorf("preview changed cache stats: %+v -> %+v", before, after)
+	}
+	if task, _ := store.GetAndLeasePendingTask(database.AgentCapabilities{}); task != nil {
+		t.Errorf("preview created task %+v", task)
+	}
+}
+
+func TestSchedulerDeadlines(t *testing.T) {
+	s, store := setupScheduler(t)
+	s.opTimes.Store(&OperationTimes{Addition: 100, Multiplication: 500})
+	userID, _ := store.CreateUser("deadline", "hash")
+	now := time.Now()
+
+	submit := func(expression string, deadline time.Time) int64 {
+		opts := database.ExpressionOptions{Deadline: &deadline}
+		exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: expression, ExpressionOptions: opts})
+		s.ScheduleTasks(exprID, expression, opts)
+		return exprID
+	}
+	lease := func() *database.Task {
+		task, _ := store.GetAndLeasePendingTask(database.AgentCapabilities{})
+		return task
+	}
+
+	// Задачи просроченного выражения не выдаются.
+	expired := submit("1 + 2", now.Add(-time.Second))
+	if task := lease(); task != nil {
+		t.Fatalf("leased task %s of expired expression", task.NodeKey)
+	}
+
+	exprID := submit("(1 + 2) * (3 + 4)", now.Add(time.Hour))
+	s.AgentSeen("agent-1", database.AgentCapabilities{})
+	s.AgentSeen("agent-2", database.AgentCapabilities{})
+	// Две задачи сложения по 100 мс и умножение 500 мс: критический путь 600 мс длиннее, чем 700 мс на двух агентах.
+	if eta := s.EstimateCompletion(mustExpression(t, store, exprID), now); eta == nil || !eta.Equal(now.Add(600*time.Millisecond)) {
+		t.Errorf("ETA = %v, want %v", eta, now.Add(600*time.Millisecond))
+	}
+
+	first, second := lease(), lease()
+	store.CompleteTask(first.ID, 3)
+	s.ProcessTaskCompletion(first.ID)
+	s.ExpireDeadlines(now.Add(2 * time.Hour))
+	for _, id := range []int64{expired, exprID} {
+		if expr := mustExpression(t, store, id); expr.Status != database.StatusTimeout {
+			t.Errorf("expression %d status=%s, want timeout", id, expr.Status)
+		}
+	}
+	if expr := mustExpression(t, store, exprID); !strings.Contains(expr.Steps.String, "1 of 3 tasks done") {
+		t.Errorf("timeout steps = %s", expr.Steps.String)
+	}
+
+	// Результат задачи, выданной до истечения срока, уже не меняет выражение.
+	store.CompleteTask(second.ID, 7)
+	s.ProcessTaskCompletion(second.ID)
+	if expr := mustExpression(t, store, exprID); expr.Status != database.StatusTimeout || expr.Result.Valid {
+		t.Errorf("after late result: status=%s result=%v", expr.Status, expr.Result)
+	}
+}
+
+func TestSchedulerAgentRouting(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("routing", "hash")
+
+	submit := func(expression string, labels map[string]string) int64 {
+		opts := database.ExpressionOptions{Labels: labels}
+		exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: expression, ExpressionOptions: opts})
+		s.ScheduleTasks(exprID, expression, opts)
+		return exprID
+	}
+	leased := submit("2 ^ 3", nil)
+	eu := submit("4 * 5", map[string]string{"region": "eu"})
+
+	weak := database.AgentCapabilities{Operations: []string{"+", "*"}}
+	if task, _ := store.GetAndLeasePendingTask(weak); task != nil {
+		t.Fatalf("agent without ^ and labels leased %s", task.NodeKey)
+	}
+	if task, _ := store.GetAndLeasePendingTask(database.AgentCapabilities{Operations: []string{"^"}}); task == nil || task.Operation != "^" {
+		t.Fatalf("agent with ^ leased %+v, want 2 ^ 3", task)
+	}
+	if task, _ := store.GetAndLeasePendingTask(database.AgentCapabilities{Labels: []string{"gpu=yes", "region=eu"}}); task == nil || task.ExpressionID != eu {
+		t.Fatalf("agent in eu leased %+v, want task of expression %d", task, eu)
+	} else {
+		store.FailTask(task.ID, "агент остановлен", false) // Вернуть задачу в очередь
+	}
+
+	// Пока агенты известны меньше окна, задачи ждут: остальные агенты могли еще не прийти.
+	power := submit("3 ^ 2", nil)
+	start := time.Now()
+	s.agents.seen("weak", weak, start)
+	s.RejectUnservable(start.Add(time.Second))
+	if expr := mustExpression(t, store, power); expr.Status == database.StatusError {
+		t.Fatalf("expression rejected before all agents could be seen: %s", expr.Steps.String)
+	}
+
+	s.agents.seen("weak", weak, start.Add(agentActiveWindow/2))
+	now := start.Add(agentActiveWindow + time.Second)
+	s.agents.seen("weak", weak, now)
+	s.RejectUnservable(now)
+	for id, want := range map[int64]string{power: "нет агента для операции '^'", eu: "нет агента для операции '*' с метками region=eu"} {
+		if expr := mustExpression(t, store, id); expr.Status != database.StatusError || !strings.Contains(expr.Steps.String, want) {
+			t.Errorf("expression %d: status=%s steps=%q, want error %q", id, expr.Status, expr.Steps.String, want)
+		}
+	}
+	if expr := mustExpression(t, store, leased); expr.Status == database.StatusError {
+		t.Errorf("expression with leased ^ task rejected: %s", expr.Steps.String)
+	}
+}
+
+func mustExpression(t *testing.T, store *database.Store, id int64) *database.Expression {
+	t.Helper()
+	expr, err := store.GetExpressionByIDInternal(id)
+	if err != nil || expr == nil {
+		t.Fatalf("GetExpressionByIDInternal(%d): expr=%v err=%v", id, expr, err)
+	}
+	return expr
+}
+
+// evaluate отправляет выражение пользователя userID, выполняет его задачи и возвращает выражение.
+func evaluate(t *testing.T, s *Scheduler, store *database.Store, userID int64, expression string, opts database.ExpressionOptions) *database.Expression {
+	t.Helper()
+	exprID, _ := store.CreateExpression(&database.Expression{UserID: userID, Expression: expression, ExpressionOptions: opts})
+	s.ScheduleTasks(exprID, expression, opts)
+	runAgent(t, s, store)
+	return mustExpression(t, store, exprID)
+}
+
+func TestParseCron(t *testing.T) {
+	at := func(s string) time.Time {
+		v, _ := time.Parse("2006-01-02 15:04", s)
+		return v
+	}
+	tests := []struct {
+		spec, after, want string
+	}{
+		{"*/15 * * * *", "2026-03-10 12:07", "2026-03-10 12:15"},
+		{"0 9-18 * * 1-5", "2026-03-13 18:30", "2026-03-16 09:00"}, // Пятница вечер -> понедельник
+		{"0 0 13 * 5", "2026-03-10 00:00", "2026-03-13 00:00"},     // 13-е или пятница
+		{"@monthly", "2026-12-31 23:59", "2027-01-01 00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+	}
+	for _, tc := range tests {
+		spec, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Errorf("ParseCron(%q) error: %v", tc.spec, err)
+			continue
+		}
+		if got := spec.Next(at(tc.after)); !got.Equal(at(tc.want)) {
+			t.Errorf("%q after %s = %s, want %s", tc.spec, tc.after, got.Format("2006-01-02 15:04"), tc.want)
+		}
+	}
+	for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "@often"} {
+		if _, err := ParseCron(bad); err == nil {
+			t.Errorf("ParseCron(%q) expected error", bad)
+		}
+	}
+}
+
+func TestSchedulerSchedules(t *testing.T) {
+	s, store := setupScheduler(t)
+	userID, _ := store.CreateUser("cron", "hash")
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	id, err := store.CreateSchedule(&database.Schedule{UserID: userID, Cron: "*/5 * * * *", Expression: "now() + 1",
+		NextRun: start.Add(5 * time.Minute)})
+	if err != nil {
+		t.Fatalf("CreateSchedule error: %v", err)
+	}
+
+	// Оркестратор был остановлен 20 минут: пропущенные срабатывания дают одно выражение.
+	now := start.Add(20*time.Minute + 30*time.Second)
+	stale, _ := store.GetSchedule(id, userID)
+	s.FireSchedules(now)
+	s.FireSchedules(now)
+	if got, _ := store.FireSchedule(stale, now, &database.Expression{UserID: userID, Expression: "1"}); got != 0 {
+		t.Errorf("stale schedule fired again: expression %d", got)
+	}
+	runs, _ := store.GetScheduleRuns(id, 10)
+	if len(runs) != 1 {
+		t.Fatalf("runs after restart = %d, want 1", len(runs))
+	}
+	runAgent(t, s, store)
+	want := float64(start.Add(5*time.Minute).Unix()) + 1
+	if expr := mustExpression(t, store, runs[0].ID); expr.Status != database.StatusDone || expr.Result.Float64 != want || expr.ScheduleID != id {
+		t.Errorf("run %q: status=%s result=%v schedule=%d, want %v", expr.Expression, expr.Status, expr.Result, expr.ScheduleID, want)
+	}
+	if sch, _ := store.GetSchedule(id, userID); sch.Runs != 1 || !sch.NextRun.Equal(start.Add(25*time.Minute)) {
+		t.Errorf("schedule after run = %+v", sch)
+	}
+
+	store.SetScheduleStatus(id, userID, database.SchedulePaused, time.Time{})
+	s.FireSchedules(now.Add(time.Hour))
+	if runs, _ := store.GetScheduleRuns(id, 10); len(runs) != 1 {
+		t.Errorf("paused schedule fired: %d runs", len(runs))
+	}
+}
@@ -0,0 +1,296 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nowVariable - переменная now (или now()) в выражении расписания: Unix-время срабатывания в секундах.
+const nowVariable = "now"
+
+// maxScheduleRuns - сколько последних выражений расписания возвращает GET /api/v1/schedules/{id}.
+const maxScheduleRuns = 100
+
+type ScheduleRequest struct {
+	Cron          string            `json:"cron"`
+	Expression    string            `json:"expression"`
+	NoCache       bool              `json:"no_cache,omitempty"`
+	Mode          string            `json:"mode,omitempty"`
+	SpecialValues string            `json:"special_values,omitempty"`
+	Priority      int               `json:"priority,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// ScheduleResponse - расписание вместе с последними созданными им выражениями.
+type ScheduleResponse struct {
+	database.Schedule
+	Runs []database.Expression `json:"runs"`
+}
+
+// SchedulesHandler: POST /api/v1/schedules создает расписание, GET /api/v1/schedules[/{id}]
+// возвращает расписания или одно расписание с историей запусков, POST /api/v1/schedules/{id}/pause
+// и /resume приостанавливают и возобновляют его, DELETE /api/v1/schedules/{id} удаляет.
+func (h *HTTPHandlers) SchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserIDFromContext(r.Context())
+	if !ok {
+		log.Println("Ошибка: не удалось получить userID из контекста в SchedulesHandler")
+		http.Error(w, "Внутренняя ошибка сервера (контекст пользователя)", http.StatusInternalServerError)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/schedules"), "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			h.listSchedules(w, userID)
+		case http.MethodPost:
+			h.createSchedule(w, r, userID)
+		default:
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	idStr, action, _ := strings.Cut(path, "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Неверный ID расписания: "+idStr, http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		h.getSchedule(w, userID, id)
+	case r.Method == http.MethodPost && action == "pause":
+		h.setScheduleStatus(w, userID, id, database.SchedulePaused)
+	case r.Method == http.MethodPost && action == "resume":
+		h.setScheduleStatus(w, userID, id, database.ScheduleActive)
+	case r.Method == http.MethodDelete && action == "":
+		if ok, err := h.db.DeleteSchedule(id, userID); err != nil {
+			log.Printf("Ошибка удаления расписания ID %d: %v", id, err)
+			http.Error(w, "Внутренняя ошибка сервера при удалении расписания", http.StatusInternalServerError)
+		} else if !ok {
+			http.Error(w, fmt.Sprintf("Расписание ID %d не найдено", id), http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *HTTPHandlers) createSchedule(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	spec, err := ParseCron(req.Cron)
+	if err != nil {
+		http.Error(w, "Некорректное расписание: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	next := spec.Next(now)
+	if next.IsZero() {
+		http.Error(w, fmt.Sprintf("Расписание '%s' никогда не срабатывает", req.Cron), http.StatusBadRequest)
+		return
+	}
+
+	expression := strings.TrimSpace(req.Expression)
+	ast, err := h.newParser(userID, expression, false).WithVariables(nowVariable).Parse()
+	if err != nil {
+		http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, name := range FreeVariables(ast) {
+		if name != nowVariable {
+			http.Error(w, fmt.Sprintf("Значение переменной '%s' не задано: в расписании доступна только %s", name, nowVariable), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := validateMode(req.Mode); err != nil {
+		http.Error(w, "Некорректный режим: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateSpecialValues(req.SpecialValues); err != nil {
+		http.Error(w, "Некорректное значение special_values: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		http.Error(w, "Некорректные метки: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	role, err := h.auth.UserRole(userID)
+	if err != nil {
+		log.Printf("Ошибка получения роли пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	priority, err := capPriority(role, req.Priority)
+	if err != nil {
+		http.Error(w, "Некорректный приоритет: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sch := &database.Schedule{
+		UserID:     userID,
+		Cron:       strings.TrimSpace(req.Cron),
+		Expression: expression,
+		Status:     database.ScheduleActive,
+		NextRun:    next,
+		ExpressionOptions: database.ExpressionOptions{NoCache: req.NoCache, Mode: req.Mode, SpecialValues: req.SpecialValues,
+			Priority: priority, Labels: req.Labels},
+	}
+	id, err := h.db.CreateSchedule(sch)
+	if err == nil {
+		sch, err = h.db.GetSchedule(id, userID)
+	}
+	if err != nil || sch == nil {
+		log.Printf("Ошибка создания расписания для пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при сохранении расписания", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sch)
+}
+
+func (h *HTTPHandlers) listSchedules(w http.ResponseWriter, userID int64) {
+	schedules, err := h.db.GetSchedules(userID)
+	if err != nil {
+		log.Printf("Ошибка получения расписаний пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении расписаний", http.StatusInternalServerError)
+		return
+	}
+	if schedules == nil {
+		schedules = []database.Schedule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"schedules": schedules})
+}
+
+func (h *HTTPHandlers) getSchedule(w http.ResponseWriter, userID, id int64) {
+	sch, err := h.db.GetSchedule(id, userID)
+	if err != nil {
+		log.Printf("Ошибка получения расписания ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении расписания", http.StatusInternalServerError)
+		return
+	}
+	if sch == nil {
+		http.Error(w, fmt.Sprintf("Расписание ID %d не найдено", id), http.StatusNotFound)
+		return
+	}
+	runs, err := h.db.GetScheduleRuns(id, maxScheduleRuns)
+	if err != nil {
+		log.Printf("Ошибка получения истории расписания ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера при получении расписания", http.StatusInternalServerError)
+		return
+	}
+	if runs == nil {
+		runs = []database.Expression{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ScheduleResponse{Schedule: *sch, Runs: runs})
+}
+
+func (h *HTTPHandlers) setScheduleStatus(w http.ResponseWriter, userID, id int64, status string) {
+	sch, err := h.db.GetSchedule(id, userID)
+	if err != nil {
+		log.Printf("Ошибка получения расписания ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if sch == nil {
+		http.Error(w, fmt.Sprintf("Расписание ID %d не найдено", id), http.StatusNotFound)
+		return
+	}
+	spec, err := ParseCron(sch.Cron)
+	if err != nil {
+		log.Printf("Некорректное сохраненное расписание ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	// Возобновленное расписание срабатывает в следующий момент после текущего, без пропущенных за паузу.
+	next := spec.Next(time.Now())
+	if _, err := h.db.SetScheduleStatus(id, userID, status, next); err != nil {
+		log.Printf("Ошибка изменения статуса расписания ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if sch, err = h.db.GetSchedule(id, userID); err != nil || sch == nil {
+		http.Error(w, fmt.Sprintf("Расписание ID %d не найдено", id), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sch)
+}
+
+// FireSchedules создает выражения для расписаний, время которых наступило к now. Срабатывания,
+// пропущенные, пока оркестратор был остановлен, объединяются в одно, а следующее время
+// считается от now.
+func (s *Scheduler) FireSchedules(now time.Time) {
+	due, err := s.dbStore.DueSchedules(now)
+	if err != nil {
+		log.Printf("Scheduler: %v", err)
+		return
+	}
+	for _, sch := range due {
+		spec, err := ParseCron(sch.Cron)
+		if err != nil {
+			log.Printf("Scheduler: Некорректное расписание ID %d: %v", sch.ID, err)
+			continue
+		}
+		expr := scheduleRun(&sch, userFunctions(s.dbStore, sch.UserID))
+		exprID, err := s.dbStore.FireSchedule(&sch, spec.Next(now), expr)
+		if err != nil {
+			log.Printf("Scheduler: %v", err)
+			continue
+		}
+		if exprID == 0 {
+			continue // Срабатывание уже обработано или расписание приостановлено
+		}
+		log.Printf("Scheduler: Расписание ID %d создало выражение ID %d", sch.ID, exprID)
+		if err := s.ScheduleTasks(exprID, expr.Expression, expr.ExpressionOptions); err != nil {
+			log.Printf("Scheduler: %v", err)
+		}
+	}
+}
+
+// scheduleRun строит выражение срабатывания расписания: now заменяется временем срабатывания.
+// Если выражение больше не разбирается (например, удалена функция пользователя), оно
+// сохраняется как есть и ошибку покажет планировщик.
+func scheduleRun(sch *database.Schedule, functions map[string]UserFunction) *database.Expression {
+	expr := &database.Expression{
+		UserID:            sch.UserID,
+		Expression:        sch.Expression,
+		ExpressionOptions: sch.ExpressionOptions,
+	}
+	ast, err := NewParser(sch.Expression).WithFunctions(functions).WithVariables(nowVariable).Parse()
+	if err != nil {
+		return expr
+	}
+	values := map[string]float64{nowVariable: float64(sch.NextRun.Unix())}
+	bindings, _ := json.Marshal(values)
+	sub := Substitute(ast, values)
+	// Формат 'g' записал бы время как 1.7731443e+09, а экспоненту парсер не разбирает.
+	expr.Expression = sub.Format(PrintOptions{NumberFormat: 'f', Precision: -1})
+	expr.Canonical = CanonicalKey(sub)
+	expr.Bindings = string(bindings)
+	return expr
+}
+
+// WatchSchedules раз в interval запускает наступившие расписания. Запускается в отдельной горутине.
+func (s *Scheduler) WatchSchedules(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.FireSchedules(now)
+	}
+}
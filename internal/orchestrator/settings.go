@@ -0,0 +1,165 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxOperationTimeMs ограничивает время операции: агент получает его в int32 и ждет столько перед ответом.
+const maxOperationTimeMs = 3600 * 1000
+
+// maxSettingHistory - сколько последних изменений настройки возвращает GET.
+const maxSettingHistory = 50
+
+// OperationTimesUpdate - изменение времени операций: незаданные поля сохраняют текущее значение.
+type OperationTimesUpdate struct {
+	Addition       *int `json:"addition_ms,omitempty"`
+	Subtraction    *int `json:"subtraction_ms,omitempty"`
+	Multiplication *int `json:"multiplication_ms,omitempty"`
+	Division       *int `json:"division_ms,omitempty"`
+	Power          *int `json:"power_ms,omitempty"`
+	Function       *int `json:"function_ms,omitempty"`
+}
+
+// operationTimeError - недопустимое значение в изменении времени операций (ошибка клиента).
+type operationTimeError struct {
+	field string
+	value int
+}
+
+func (e *operationTimeError) Error() string {
+	return fmt.Sprintf("%s должно быть от 0 до %d, получено %d", e.field, maxOperationTimeMs, e.value)
+}
+
+// apply возвращает копию times с примененным изменением.
+func (u *OperationTimesUpdate) apply(times OperationTimes) (*OperationTimes, error) {
+	fields := []struct {
+		name  string
+		value *int
+		dst   *int
+	}{
+		{"addition_ms", u.Addition, &times.Addition},
+		{"subtraction_ms", u.Subtraction, &times.Subtraction},
+		{"multiplication_ms", u.Multiplication, &times.Multiplication},
+		{"division_ms", u.Division, &times.Division},
+		{"power_ms", u.Power, &times.Power},
+		{"function_ms", u.Function, &times.Function},
+	}
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		if *f.value < 0 || *f.value > maxOperationTimeMs {
+			return nil, &operationTimeError{field: f.name, value: *f.value}
+		}
+		*f.dst = *f.value
+	}
+	return &times, nil
+}
+
+// loadOperationTimes дополняет время из переменных окружения TIME_*_MS сохраненным через API:
+// сохраненное значение переживает перезапуск и важнее окружения.
+func (s *Scheduler) loadOperationTimes(defaults *OperationTimes) *OperationTimes {
+	value, err := s.dbStore.GetSetting(database.SettingOperationTimes)
+	if err != nil {
+		log.Printf("Scheduler: %v, используется время из окружения", err)
+		return defaults
+	}
+	if value == "" {
+		return defaults
+	}
+	times := *defaults
+	if err := json.Unmarshal([]byte(value), &times); err != nil {
+		log.Printf("Scheduler: Некорректное сохраненное время операций '%s': %v, используется время из окружения", value, err)
+		return defaults
+	}
+	return &times
+}
+
+// UpdateOperationTimes сохраняет новое время операций с записью в историю от имени userID и
+// применяет его одной заменой снимка: задачи, выданные после этого, получают новое время.
+// Недопустимое значение возвращается как *operationTimeError.
+func (s *Scheduler) UpdateOperationTimes(userID int64, update *OperationTimesUpdate) (*OperationTimes, error) {
+	s.settingMu.Lock()
+	defer s.settingMu.Unlock()
+
+	times, err := update.apply(*s.GetOperationTimes())
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(times)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации времени операций: %w", err)
+	}
+	if err := s.dbStore.SetSetting(database.SettingOperationTimes, string(value), userID); err != nil {
+		return nil, err
+	}
+	s.opTimes.Store(times)
+	return times, nil
+}
+
+// OperationTimesResponse - текущее время операций и последние его изменения.
+type OperationTimesResponse struct {
+	*OperationTimes
+	History []database.SettingChange `json:"history"`
+}
+
+// AdminSettingsHandler: GET /api/v1/admin/settings/operation-times - текущее время операций
+// и история изменений, PUT - изменить время (можно передать только часть полей).
+func (h *HTTPHandlers) AdminSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/settings"), "/"); name != "operation-times" {
+		http.Error(w, fmt.Sprintf("Неизвестная настройка '%s'", name), http.StatusNotFound)
+		return
+	}
+
+	var times *OperationTimes
+	switch r.Method {
+	case http.MethodGet:
+		times = h.scheduler.GetOperationTimes()
+	case http.MethodPut:
+		var update OperationTimesUpdate
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields() // Опечатка в имени поля иначе молча ничего не изменила бы
+		if err := decoder.Decode(&update); err != nil {
+			http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		times, err = h.scheduler.UpdateOperationTimes(adminID, &update)
+		var invalid *operationTimeError
+		if errors.As(err, &invalid) {
+			http.Error(w, "Некорректное время операций: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка изменения времени операций: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера при сохранении настройки", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Администратор %d изменил время операций: %+v", adminID, *times)
+	default:
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	history, err := h.db.GetSettingHistory(database.SettingOperationTimes, maxSettingHistory)
+	if err != nil {
+		log.Printf("Ошибка получения истории времени операций: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		history = []database.SettingChange{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OperationTimesResponse{OperationTimes: times, History: history})
+}
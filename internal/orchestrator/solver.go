@@ -0,0 +1,468 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	SolveBisection = "bisection"
+	SolveNewton    = "newton"
+)
+
+type SolveRequest struct {
+	Equation      string  `json:"equation"` // "x^2 = 2"; без '=' решается equation = 0
+	Var           string  `json:"var,omitempty"`
+	From          float64 `json:"from"` // Интервал, на концах которого функция меняет знак
+	To            float64 `json:"to"`
+	Method        string  `json:"method,omitempty"`         // bisection (по умолчанию) или newton
+	Tolerance     float64 `json:"tolerance,omitempty"`      // По умолчанию 1e-9
+	MaxIterations int     `json:"max_iterations,omitempty"` // По умолчанию 100
+	Lenient       bool    `json:"lenient,omitempty"`
+	NoCache       bool    `json:"no_cache,omitempty"`
+}
+
+// solverState - состояние решателя между вычислениями, хранится в jobs.params.
+// Каждое значение f(x) (и f'(x) для метода Ньютона) считается отдельным выражением задачи.
+type solverState struct {
+	Method        string           `json:"method"`
+	Var           string           `json:"var"`
+	F             string           `json:"f"`            // lhs - rhs
+	DF            string           `json:"df,omitempty"` // Производная f для метода Ньютона
+	ExpressionID  int64            `json:"expression_id"`
+	Tolerance     float64          `json:"tolerance"`
+	MaxIterations int              `json:"max_iterations"`
+	NoCache       bool             `json:"no_cache,omitempty"`
+	Lo            float64          `json:"lo"`
+	Hi            float64          `json:"hi"`
+	FLo           float64          `json:"f_lo"`
+	FHi           float64          `json:"f_hi"`
+	X             float64          `json:"x"`
+	FX            float64          `json:"f_x"`
+	DFX           float64          `json:"df_x"`
+	Iteration     int              `json:"iteration"`
+	Root          *float64         `json:"root,omitempty"`
+	Pending       map[int64]string `json:"pending"` // ID выражения -> что оно вычисляет: lo, hi, f или df
+}
+
+type SolveResponse struct {
+	*database.Job
+	ExpressionID int64      `json:"expression_id"` // Выражение-уравнение с историей итераций в steps
+	Method       string     `json:"method"`
+	Var          string     `json:"var"`
+	Iterations   int        `json:"iterations"`
+	Interval     [2]float64 `json:"interval"`
+	Root         *float64   `json:"root,omitempty"`
+}
+
+// solverEval - вычисление, которое решатель запрашивает у планировщика.
+type solverEval struct {
+	id      int64
+	expr    string
+	noCache bool
+}
+
+// startSolver создает выражение-уравнение и задачу решателя и запрашивает значения функции
+// на концах интервала. Своих задач у уравнения нет: до конца решения оно в статусе waiting,
+// как выражение, ожидающее результатов других выражений.
+func (s *Scheduler) startSolver(userID int64, equation string, state *solverState) (*database.Job, error) {
+	exprID, err := s.dbStore.CreateExpression(&database.Expression{UserID: userID, Expression: equation, Equation: true})
+	if err != nil {
+		return nil, err
+	}
+	state.ExpressionID = exprID
+	if err := s.dbStore.UpdateExpressionStatusResult(exprID, database.StatusWaiting, sql.NullFloat64{}, sql.NullString{}); err != nil {
+		return nil, err
+	}
+	job := &database.Job{UserID: userID, Kind: database.JobKindSolve, Expression: equation}
+	if job.ID, err = s.dbStore.CreateJob(job); err != nil {
+		return nil, err
+	}
+	if err := s.dbStore.UpdateJobStatusResult(job.ID, database.StatusInProgress, sql.NullString{}); err != nil {
+		return nil, err
+	}
+
+	s.jobMu.Lock()
+	evals, err := s.requestEvals(job, state, map[string]float64{"lo": state.Lo, "hi": state.Hi})
+	if err == nil {
+		err = s.saveSolverState(job, state)
+	}
+	s.jobMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	s.scheduleEvals(evals)
+	return job, nil
+}
+
+// solverStep обрабатывает завершение вычисления, запрошенного решателем, и запрашивает следующее.
+func (s *Scheduler) solverStep(jobID int64, expr *database.Expression) {
+	s.jobMu.Lock()
+	job, evals := s.advanceSolver(jobID, expr)
+	s.jobMu.Unlock()
+	if job != nil {
+		s.scheduleEvals(evals)
+	}
+}
+
+// scheduleEvals планирует вычисления решателя вне блокировки jobMu. Выражение без задач
+// (свернутое оптимизатором) завершается прямо в ScheduleTasks и через solverStep запрашивает
+// следующее вычисление: оно попадает в очередь, которую разбирает первый вызов, поэтому
+// итерации идут циклом, а не рекурсией глубиной в max_iterations.
+func (s *Scheduler) scheduleEvals(evals []solverEval) {
+	s.evalMu.Lock()
+	s.evalQueue = append(s.evalQueue, evals...)
+	if s.evalDraining {
+		s.evalMu.Unlock()
+		return
+	}
+	s.evalDraining = true
+	for len(s.evalQueue) > 0 {
+		e := s.evalQueue[0]
+		s.evalQueue = s.evalQueue[1:]
+		s.evalMu.Unlock()
+		if err := s.ScheduleTasks(e.id, e.expr, database.ExpressionOptions{NoCache: e.noCache}); err != nil {
+			log.Printf("Scheduler: Ошибка планирования вычисления решателя ID %d: %v", e.id, err)
+		}
+		s.evalMu.Lock()
+	}
+	s.evalDraining = false
+	s.evalMu.Unlock()
+}
+
+func (s *Scheduler) advanceSolver(jobID int64, expr *database.Expression) (*database.Job, []solverEval) {
+	job, err := s.dbStore.GetJobByID(jobID, 0)
+	if err != nil || job == nil || job.Status == database.StatusDone || job.Status == database.StatusError {
+		return nil, nil
+	}
+	state := &solverState{}
+	if err := json.Unmarshal([]byte(job.Params), state); err != nil {
+		log.Printf("Scheduler: Некорректное состояние решателя ID %d: %v", job.ID, err)
+		return nil, nil
+	}
+	role, ok := state.Pending[expr.ID]
+	if !ok {
+		return nil, nil
+	}
+	delete(state.Pending, expr.ID)
+
+	if expr.Status != database.StatusDone || !expr.Result.Valid {
+		s.finishSolver(job, state, fmt.Errorf("не удалось вычислить %s в точке %s: %s", role, expr.Bindings, expr.Steps.String))
+		return nil, nil
+	}
+	value := expr.Result.Float64
+	switch role {
+	case "lo":
+		state.FLo = value
+	case "hi":
+		state.FHi = value
+	case "f":
+		state.FX = value
+	case "df":
+		state.DFX = value
+	}
+	if len(state.Pending) > 0 {
+		s.saveSolverState(job, state)
+		return nil, nil
+	}
+
+	var next float64
+	if role == "lo" || role == "hi" {
+		switch {
+		case state.FLo == 0:
+			state.Root = &state.Lo
+		case state.FHi == 0:
+			state.Root = &state.Hi
+		case math.Signbit(state.FLo) == math.Signbit(state.FHi):
+			s.finishSolver(job, state, fmt.Errorf("функция не меняет знак на интервале [%g, %g]: f=%g и f=%g",
+				state.Lo, state.Hi, state.FLo, state.FHi))
+			return nil, nil
+		}
+		next = (state.Lo + state.Hi) / 2
+	} else {
+		state.Iteration++
+		if math.Signbit(state.FX) == math.Signbit(state.FLo) {
+			state.Lo, state.FLo = state.X, state.FX
+		} else {
+			state.Hi, state.FHi = state.X, state.FX
+		}
+		step := fmt.Sprintf("Iteration %d: %s=%g, f=%g, interval [%g, %g]",
+			state.Iteration, state.Var, state.X, state.FX, state.Lo, state.Hi)
+		s.appendSolverStep(state.ExpressionID, step)
+
+		if math.Abs(state.FX) <= state.Tolerance || (state.Hi-state.Lo)/2 <= state.Tolerance {
+			state.Root = &state.X
+		} else if state.Iteration >= state.MaxIterations {
+			s.finishSolver(job, state, fmt.Errorf("метод не сошелся за %d итераций", state.MaxIterations))
+			return nil, nil
+		}
+		next = (state.Lo + state.Hi) / 2
+		if state.Method == SolveNewton && state.DFX != 0 {
+			// Шаг Ньютона, выходящий за текущий интервал, заменяется делением пополам.
+			if x := state.X - state.FX/state.DFX; x > state.Lo && x < state.Hi {
+				next = x
+			}
+		}
+	}
+	if state.Root != nil {
+		s.finishSolver(job, state, nil)
+		return nil, nil
+	}
+
+	state.X = next
+	points := map[string]float64{"f": next}
+	if state.Method == SolveNewton {
+		points["df"] = next
+	}
+	evals, err := s.requestEvals(job, state, points)
+	if err == nil {
+		err = s.saveSolverState(job, state)
+	}
+	if err != nil {
+		s.finishSolver(job, state, err)
+		return nil, nil
+	}
+	return job, evals
+}
+
+// requestEvals создает выражения для вычисления f (или f' для роли df) в заданных точках.
+func (s *Scheduler) requestEvals(job *database.Job, state *solverState, points map[string]float64) ([]solverEval, error) {
+	var evals []solverEval
+	for _, role := range []string{"lo", "hi", "f", "df"} {
+		x, ok := points[role]
+		if !ok {
+			continue
+		}
+		source := state.F
+		if role == "df" {
+			source = state.DF
+		}
+		fn, err := NewParser(source).Parse()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка разбора функции решателя: %w", err)
+		}
+		point := map[string]float64{state.Var: x}
+		sub := Substitute(fn, point)
+		bindings, _ := json.Marshal(point)
+		child := &database.Expression{
+			UserID:            job.UserID,
+			Expression:        sub.Pretty(),
+			JobID:             job.ID,
+			Bindings:          string(bindings),
+			ExpressionOptions: database.ExpressionOptions{NoCache: state.NoCache},
+		}
+		id, err := s.dbStore.CreateExpression(child)
+		if err != nil {
+			return nil, err
+		}
+		state.Pending[id] = role
+		job.Total++
+		evals = append(evals, solverEval{id: id, expr: child.Expression, noCache: state.NoCache})
+	}
+	return evals, nil
+}
+
+func (s *Scheduler) saveSolverState(job *database.Job, state *solverState) error {
+	params, _ := json.Marshal(state)
+	job.Params = string(params)
+	return s.dbStore.UpdateJobParams(job.ID, job.Params, job.Total)
+}
+
+func (s *Scheduler) appendSolverStep(expressionID int64, step string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expr, err := s.dbStore.GetExpressionByIDInternal(expressionID)
+	if err != nil || expr == nil {
+		return
+	}
+	var steps []string
+	if expr.Steps.Valid {
+		json.Unmarshal([]byte(expr.Steps.String), &steps)
+	}
+	steps = append(steps, step)
+	s.dbStore.UpdateExpressionStatusResult(expr.ID, database.StatusWaiting, sql.NullFloat64{}, stepsJSON(steps))
+}
+
+// finishSolver завершает задачу решателя и выражение-уравнение корнем или ошибкой.
+func (s *Scheduler) finishSolver(job *database.Job, state *solverState, solveErr error) {
+	s.saveSolverState(job, state)
+	// После снятия блокировки: выражения, ссылающиеся на уравнение через $id, ждут его завершения.
+	defer s.expressionFinished(state.ExpressionID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expr, err := s.dbStore.GetExpressionByIDInternal(state.ExpressionID)
+	if err != nil || expr == nil {
+		return
+	}
+	var steps []string
+	if expr.Steps.Valid {
+		json.Unmarshal([]byte(expr.Steps.String), &steps)
+	}
+
+	if solveErr != nil {
+		steps = append(steps, "Error: "+solveErr.Error())
+		s.dbStore.UpdateExpressionStatusResult(expr.ID, database.StatusError, sql.NullFloat64{}, stepsJSON(steps))
+		s.dbStore.UpdateJobStatusResult(job.ID, database.StatusError, sql.NullString{})
+		log.Printf("Scheduler: Решатель ID %d завершился ошибкой: %v", job.ID, solveErr)
+		return
+	}
+
+	root := *state.Root
+	steps = append(steps, fmt.Sprintf("Root: %s=%g", state.Var, root))
+	s.dbStore.UpdateExpressionStatusResult(expr.ID, database.StatusDone, sql.NullFloat64{Float64: root, Valid: true}, stepsJSON(steps))
+	result, _ := json.Marshal(map[string]interface{}{"root": root, "iterations": state.Iteration})
+	s.dbStore.UpdateJobStatusResult(job.ID, database.StatusDone, sql.NullString{String: string(result), Valid: true})
+	log.Printf("Scheduler: Решатель ID %d нашел корень %s=%g за %d итераций", job.ID, state.Var, root, state.Iteration)
+}
+
+// SolveHandler: POST /api/v1/solve запускает решение уравнения, GET /api/v1/solve/{id} возвращает его состояние.
+func (h *HTTPHandlers) SolveHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserIDFromContext(r.Context())
+	if !ok {
+		log.Println("Ошибка: не удалось получить userID из контекста в SolveHandler")
+		http.Error(w, "Внутренняя ошибка сервера (контекст пользователя)", http.StatusInternalServerError)
+		return
+	}
+
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/solve"), "/")
+	switch {
+	case r.Method == http.MethodPost && idStr == "":
+		h.createSolve(w, r, userID)
+	case r.Method == http.MethodGet && idStr != "":
+		h.getSolve(w, userID, idStr)
+	default:
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *HTTPHandlers) createSolve(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req SolveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parser := h.newParser(userID, req.Equation, req.Lenient)
+	if req.Var != "" {
+		parser.WithVariables(req.Var)
+	}
+	lhs, rhs, err := parser.ParseEquation()
+	if err != nil {
+		http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := binary("-", lhs, rhs)
+
+	vars := FreeVariables(f)
+	switch {
+	case len(vars) == 0:
+		http.Error(w, "В уравнении нет неизвестной", http.StatusBadRequest)
+		return
+	case len(vars) > 1:
+		http.Error(w, fmt.Sprintf("Уравнение должно содержать одну неизвестную, найдены: %s", strings.Join(vars, ", ")), http.StatusBadRequest)
+		return
+	case req.Var != "" && req.Var != vars[0]:
+		http.Error(w, fmt.Sprintf("Переменная '%s' не входит в уравнение", req.Var), http.StatusBadRequest)
+		return
+	}
+
+	state := &solverState{
+		Method:        req.Method,
+		Var:           vars[0],
+		F:             f.Pretty(),
+		Tolerance:     req.Tolerance,
+		MaxIterations: req.MaxIterations,
+		NoCache:       req.NoCache,
+		Lo:            math.Min(req.From, req.To),
+		Hi:            math.Max(req.From, req.To),
+		Pending:       map[int64]string{},
+	}
+	if state.Method == "" {
+		state.Method = SolveBisection
+	}
+	if state.Method != SolveBisection && state.Method != SolveNewton {
+		http.Error(w, "Неизвестный метод: "+state.Method, http.StatusBadRequest)
+		return
+	}
+	if state.Tolerance <= 0 {
+		state.Tolerance = 1e-9
+	}
+	if state.MaxIterations <= 0 {
+		state.MaxIterations = 100
+	}
+	if state.MaxIterations > 1000 {
+		http.Error(w, "max_iterations не может быть больше 1000", http.StatusBadRequest)
+		return
+	}
+	if state.Lo == state.Hi {
+		http.Error(w, "Интервал поиска корня пуст", http.StatusBadRequest)
+		return
+	}
+	if state.Method == SolveNewton {
+		df, err := Derive(f, state.Var)
+		if err != nil {
+			http.Error(w, "Ошибка дифференцирования: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		state.DF = df.Pretty()
+	}
+
+	equation := lhs.Pretty() + "=" + rhs.Pretty()
+	job, err := h.scheduler.startSolver(userID, equation, state)
+	if err != nil {
+		log.Printf("Ошибка запуска решателя для пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при запуске решателя", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":            job.ID,
+		"expression_id": state.ExpressionID,
+		"equation":      equation,
+		"method":        state.Method,
+		"status":        database.StatusInProgress,
+	})
+}
+
+func (h *HTTPHandlers) getSolve(w http.ResponseWriter, userID int64, idStr string) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Неверный ID решателя: "+idStr, http.StatusBadRequest)
+		return
+	}
+	job, err := h.db.GetJobByID(id, userID)
+	if err != nil {
+		log.Printf("Ошибка получения решателя ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if job == nil || job.Kind != database.JobKindSolve {
+		http.Error(w, "Решатель не найден", http.StatusNotFound)
+		return
+	}
+
+	var state solverState
+	json.Unmarshal([]byte(job.Params), &state)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SolveResponse{
+		Job:          job,
+		ExpressionID: state.ExpressionID,
+		Method:       state.Method,
+		Var:          state.Var,
+		Iterations:   state.Iteration,
+		Interval:     [2]float64{state.Lo, state.Hi},
+		Root:         state.Root,
+	})
+}
@@ -0,0 +1,44 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"fmt"
+	"strconv"
+)
+
+// validateSpecialValues проверяет политику для бесконечностей и NaN, заданную клиентом.
+func validateSpecialValues(policy string) error {
+	switch policy {
+	case "", database.SpecialStrict, database.SpecialIEEE:
+		return nil
+	}
+	return fmt.Errorf("неизвестная политика '%s', ожидалось '%s' или '%s'", policy, database.SpecialStrict, database.SpecialIEEE)
+}
+
+// checkFinite применяет политику strict к значениям, известным еще до отправки задач агентам:
+// перевод в СИ может переполниться, а ссылка $id - указывать на Inf выражения с политикой ieee.
+func checkFinite(n *Node) error {
+	if n == nil {
+		return nil
+	}
+	var values []float64
+	switch {
+	case n.Value != nil:
+		values = []float64{*n.Value}
+	case n.Complex != nil:
+		values = []float64{real(*n.Complex), imag(*n.Complex)}
+	case n.Interval != nil:
+		values = []float64{n.Interval.Lo, n.Interval.Hi}
+	case n.Tensor != nil:
+		values = n.Tensor.Data
+	}
+	for _, v := range values {
+		if !isFinite(v) {
+			return fmt.Errorf("значение %s вне допустимого диапазона (политика '%s')", strconv.FormatFloat(v, 'g', -1, 64), database.SpecialStrict)
+		}
+	}
+	if err := checkFinite(n.Left); err != nil {
+		return err
+	}
+	return checkFinite(n.Right)
+}
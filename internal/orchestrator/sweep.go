@@ -0,0 +1,347 @@
+package orchestrator
+
+import (
+	"calculator/internal/database"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SweepRange задает значения переменной: либо диапазон from..to с шагом step (to включается),
+// либо явный список values.
+type SweepRange struct {
+	From   *float64  `json:"from,omitempty"`
+	To     *float64  `json:"to,omitempty"`
+	Step   *float64  `json:"step,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+type SweepRequest struct {
+	Expression string                `json:"expression"`
+	Vars       map[string]SweepRange `json:"vars"`
+	Lenient    bool                  `json:"lenient,omitempty"`
+	NoCache    bool                  `json:"no_cache,omitempty"`
+}
+
+// sweepParams сохраняется в Job.Params: порядок переменных задает столбцы сетки.
+type sweepParams struct {
+	Vars []string `json:"vars"`
+}
+
+// SweepPoint - точка сетки. Результат отдается теми же полями, что и в GET /expressions/<id>:
+// вектор, комплексное число или интервал приходят в result_tensor, result_complex, result_interval.
+type SweepPoint struct {
+	ExpressionID   int64              `json:"expression_id"`
+	Bindings       map[string]float64 `json:"bindings"`
+	Status         string             `json:"status"`
+	Result         database.Number    `json:"result"`
+	ResultTensor   json.RawMessage    `json:"result_tensor,omitempty"`
+	ResultComplex  *database.Complex  `json:"result_complex,omitempty"`
+	ResultInterval *database.Interval `json:"result_interval,omitempty"`
+	Unit           string             `json:"unit,omitempty"`
+}
+
+type SweepResponse struct {
+	*database.Job
+	Vars   []string     `json:"vars"`
+	Points []SweepPoint `json:"points,omitempty"` // Заполняется, когда все точки вычислены
+}
+
+// rangeValues разворачивает диапазон переменной в список значений. Число значений проверяется
+// до выделения памяти: диапазон 0..1e10 с шагом 1 иначе занял бы десятки гигабайт.
+func rangeValues(name string, r SweepRange, maxPoints int) ([]float64, error) {
+	if len(r.Values) > 0 {
+		if r.From != nil || r.To != nil || r.Step != nil {
+			return nil, fmt.Errorf("для переменной '%s' задан и список значений, и диапазон", name)
+		}
+		return r.Values, nil
+	}
+	if r.From == nil || r.To == nil || r.Step == nil {
+		return nil, fmt.Errorf("для переменной '%s' нужно задать values или from, to и step", name)
+	}
+	from, to, step := *r.From, *r.To, *r.Step
+	if step == 0 || math.IsNaN(step) || math.IsInf(step, 0) {
+		return nil, fmt.Errorf("недопустимый шаг %v для переменной '%s'", step, name)
+	}
+	if (to-from)/step < 0 {
+		return nil, fmt.Errorf("шаг %v для переменной '%s' не ведет от %v к %v", step, name, from, to)
+	}
+	// Небольшой допуск, чтобы конец диапазона не терялся из-за ошибок округления (0..1 с шагом 0.1).
+	n := math.Floor((to-from)/step+1e-9) + 1
+	if math.IsNaN(n) || math.IsInf(n, 0) || n > float64(maxPoints) {
+		return nil, fmt.Errorf("слишком много точек: больше %d", maxPoints)
+	}
+	values := make([]float64, int(n))
+	for i := range values {
+		values[i] = from + float64(i)*step
+	}
+	return values, nil
+}
+
+// expandSweep строит декартово произведение значений переменных. Порядок точек:
+// последняя переменная меняется быстрее всего.
+func expandSweep(vars []string, ranges map[string]SweepRange, maxPoints int) ([]map[string]float64, error) {
+	axes := make([][]float64, len(vars))
+	total := 1
+	for i, name := range vars {
+		values, err := rangeValues(name, ranges[name], maxPoints)
+		if err != nil {
+			return nil, err
+		}
+		axes[i] = values
+		total *= len(values)
+		if total > maxPoints {
+			return nil, fmt.Errorf("слишком много точек: больше %d", maxPoints)
+		}
+	}
+
+	points := make([]map[string]float64, 0, total)
+	idx := make([]int, len(vars))
+	for {
+		point := make(map[string]float64, len(vars))
+		for i, name := range vars {
+			point[name] = axes[i][idx[i]]
+		}
+		points = append(points, point)
+
+		i := len(idx) - 1
+		for ; i >= 0; i-- {
+			idx[i]++
+			if idx[i] < len(axes[i]) {
+				break
+			}
+			idx[i] = 0
+		}
+		if i < 0 {
+			return points, nil
+		}
+	}
+}
+
+// sweepProgress обновляет статус задачи перебора после завершения очередной точки.
+func (s *Scheduler) sweepProgress(job *database.Job) {
+	status := database.StatusInProgress
+	if job.Completed >= job.Total {
+		status = database.StatusDone
+	} else if job.Status == database.StatusInProgress {
+		return
+	}
+	if err := s.dbStore.UpdateJobStatusResult(job.ID, status, sql.NullString{}); err != nil {
+		log.Printf("Scheduler: %v", err)
+	}
+}
+
+// SweepHandler: POST /api/v1/sweep создает перебор, GET /api/v1/sweep/{id}[?format=csv] возвращает
+// прогресс и, когда все точки вычислены, сетку результатов.
+func (h *HTTPHandlers) SweepHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserIDFromContext(r.Context())
+	if !ok {
+		log.Println("Ошибка: не удалось получить userID из контекста в SweepHandler")
+		http.Error(w, "Внутренняя ошибка сервера (контекст пользователя)", http.StatusInternalServerError)
+		return
+	}
+
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/sweep"), "/")
+	switch {
+	case r.Method == http.MethodPost && idStr == "":
+		h.createSweep(w, r, userID)
+	case r.Method == http.MethodGet && idStr != "":
+		h.getSweep(w, r, userID, idStr)
+	default:
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *HTTPHandlers) createSweep(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req SweepRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Ошибка декодирования JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Vars) == 0 {
+		http.Error(w, "Не заданы переменные перебора", http.StatusBadRequest)
+		return
+	}
+
+	vars := make([]string, 0, len(req.Vars))
+	for name := range req.Vars {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+
+	ast, err := h.newParser(userID, req.Expression, req.Lenient).WithVariables(vars...).Parse()
+	if err != nil {
+		http.Error(w, "Ошибка парсинга: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, name := range FreeVariables(ast) {
+		if _, ok := req.Vars[name]; !ok {
+			http.Error(w, fmt.Sprintf("Не заданы значения переменной '%s'", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	points, err := expandSweep(vars, req.Vars, readTimeEnv("SWEEP_MAX_POINTS", 10000))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params, _ := json.Marshal(sweepParams{Vars: vars})
+	job := &database.Job{
+		UserID:     userID,
+		Kind:       database.JobKindSweep,
+		Expression: ast.Pretty(),
+		Params:     string(params),
+		Total:      len(points),
+	}
+	jobID, err := h.db.CreateJob(job)
+	if err != nil {
+		log.Printf("Ошибка создания перебора для пользователя %d: %v", userID, err)
+		http.Error(w, "Внутренняя ошибка сервера при сохранении перебора", http.StatusInternalServerError)
+		return
+	}
+
+	// Сначала сохраняем все точки, а планируем потом: иначе задача могла бы завершиться
+	// раньше, чем созданы ее последние выражения.
+	opts := database.ExpressionOptions{NoCache: req.NoCache}
+	children := make([]*database.Expression, len(points))
+	for i, point := range points {
+		bindings, _ := json.Marshal(point)
+		sub := Substitute(ast, point)
+		children[i] = &database.Expression{
+			UserID:            userID,
+			Expression:        sub.Pretty(),
+			Canonical:         CanonicalKey(sub),
+			JobID:             jobID,
+			Bindings:          string(bindings),
+			ExpressionOptions: opts,
+		}
+		if children[i].ID, err = h.db.CreateExpression(children[i]); err != nil {
+			log.Printf("Ошибка создания точки перебора ID %d: %v", jobID, err)
+			h.db.UpdateJobStatusResult(jobID, database.StatusError, sql.NullString{})
+			http.Error(w, "Внутренняя ошибка сервера при сохранении перебора", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	go func() {
+		for _, child := range children {
+			if err := h.scheduler.ScheduleTasks(child.ID, child.Expression, opts); err != nil {
+				log.Printf("Асинхронная ошибка планирования точки перебора ID %d: %v", child.ID, err)
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     jobID,
+		"total":  len(points),
+		"vars":   vars,
+		"status": database.StatusPending,
+	})
+}
+
+func (h *HTTPHandlers) getSweep(w http.ResponseWriter, r *http.Request, userID int64, idStr string) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Неверный ID перебора: "+idStr, http.StatusBadRequest)
+		return
+	}
+	job, err := h.db.GetJobByID(id, userID)
+	if err != nil {
+		log.Printf("Ошибка получения перебора ID %d: %v", id, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if job == nil || job.Kind != database.JobKindSweep {
+		http.Error(w, "Перебор не найден", http.StatusNotFound)
+		return
+	}
+
+	var params sweepParams
+	json.Unmarshal([]byte(job.Params), &params)
+	resp := SweepResponse{Job: job, Vars: params.Vars}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "Неизвестный формат: "+format, http.StatusBadRequest)
+		return
+	}
+	if job.Status != database.StatusDone {
+		if format == "csv" {
+			http.Error(w, fmt.Sprintf("Перебор еще выполняется: %d из %d", job.Completed, job.Total), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	expressions, err := h.db.GetJobExpressions(job.ID)
+	if err != nil {
+		log.Printf("Ошибка получения точек перебора ID %d: %v", job.ID, err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	resp.Points = make([]SweepPoint, len(expressions))
+	for i, expr := range expressions {
+		p := SweepPoint{
+			ExpressionID: expr.ID, Status: expr.Status, Result: expr.Result,
+			ResultTensor: expr.ResultTensor, ResultComplex: expr.ResultComplex,
+			ResultInterval: expr.ResultInterval, Unit: expr.Unit,
+		}
+		json.Unmarshal([]byte(expr.Bindings), &p.Bindings)
+		resp.Points[i] = p
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"sweep_%d.csv\"", job.ID))
+		writeSweepCSV(w, resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Ошибка записи JSON ответа для перебора ID %d: %v", job.ID, err)
+	}
+}
+
+// writeSweepCSV выводит сетку: столбцы переменных, затем result, unit и status.
+func writeSweepCSV(w http.ResponseWriter, resp SweepResponse) {
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{}, resp.Vars...), "result", "unit", "status"))
+	for _, p := range resp.Points {
+		row := make([]string, 0, len(resp.Vars)+3)
+		for _, name := range resp.Vars {
+			row = append(row, formatNumber(p.Bindings[name]))
+		}
+		row = append(row, sweepResultCell(p), p.Unit, p.Status)
+		cw.Write(row)
+	}
+	cw.Flush()
+}
+
+// sweepResultCell записывает результат точки одной ячейкой: вектор или матрица - JSON,
+// комплексное число - 3+4i, интервал - [lo, hi].
+func sweepResultCell(p SweepPoint) string {
+	switch {
+	case p.ResultTensor != nil:
+		return string(p.ResultTensor)
+	case p.ResultComplex != nil:
+		return formatComplex(complex(p.ResultComplex.Re, p.ResultComplex.Im), DefaultPrintOptions)
+	case p.ResultInterval != nil:
+		return fmt.Sprintf("[%s, %s]", formatNumber(p.ResultInterval.Lo), formatNumber(p.ResultInterval.Hi))
+	case p.Result.Valid:
+		return formatNumber(p.Result.Float64)
+	}
+	return ""
+}
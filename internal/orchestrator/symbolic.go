@@ -0,0 +1,272 @@
+package orchestrator
+
+import (
+	"fmt"
+	"math"
+)
+
+func num(v float64) *Node {
+	return &Node{Value: &v}
+}
+
+func binary(op string, left, right *Node) *Node {
+	return &Node{Op: op, Left: left, Right: right}
+}
+
+func call(fn string, arg *Node) *Node {
+	return &Node{Op: fn, Left: arg}
+}
+
+// dependsOn сообщает, входит ли переменная в поддерево.
+func dependsOn(n *Node, v string) bool {
+	if n == nil {
+		return false
+	}
+	if n.Var != "" {
+		return n.Var == v
+	}
+	return dependsOn(n.Left, v) || dependsOn(n.Right, v)
+}
+
+// Derive возвращает упрощенную производную выражения по переменной v.
+func Derive(n *Node, v string) (*Node, error) {
+	if hasTensor(n) {
+		return nil, fmt.Errorf("производная выражений с векторами и матрицами не поддерживается")
+	}
+	if hasUnits(n) {
+		return nil, fmt.Errorf("производная выражений с единицами измерения не поддерживается")
+	}
+	d, err := derive(n, v)
+	if err != nil {
+		return nil, err
+	}
+	return Simplify(d), nil
+}
+
+func derive(n *Node, v string) (*Node, error) {
+	if n == nil {
+		return nil, fmt.Errorf("пустое выражение")
+	}
+	if n.Value != nil || n.Complex != nil {
+		return num(0), nil
+	}
+	if n.Var != "" {
+		if n.Var == v {
+			return num(1), nil
+		}
+		return num(0), nil
+	}
+	if !dependsOn(n, v) {
+		return num(0), nil
+	}
+
+	du, err := derive(n.Left, v)
+	if err != nil {
+		return nil, err
+	}
+	u := n.Left
+
+	if n.IsFunction() {
+		var outer *Node
+		switch n.Op {
+		case "sin":
+			outer = call("cos", u)
+		case "cos":
+			outer = binary("*", num(-1), call("sin", u))
+		case "tan":
+			outer = binary("/", num(1), binary("^", call("cos", u), num(2)))
+		case "exp":
+			outer = call("exp", u)
+		case "ln":
+			outer = binary("/", num(1), u)
+		case "sqrt":
+			outer = binary("/", num(1), binary("*", num(2), call("sqrt", u)))
+		case "abs":
+			outer = binary("/", u, call("abs", u))
+		default:
+			return nil, fmt.Errorf("производная функции '%s' не поддерживается", n.Op)
+		}
+		return binary("*", outer, du), nil // Правило цепочки
+	}
+
+	dw, err := derive(n.Right, v)
+	if err != nil {
+		return nil, err
+	}
+	w := n.Right
+
+	switch n.Op {
+	case "+", "-":
+		return binary(n.Op, du, dw), nil
+	case "*":
+		return binary("+", binary("*", du, w), binary("*", u, dw)), nil
+	case "/":
+		return binary("/",
+			binary("-", binary("*", du, w), binary("*", u, dw)),
+			binary("^", w, num(2)),
+		), nil
+	case "^":
+		if !dependsOn(w, v) {
+			// (u^c)' = c*u^(c-1)*u'
+			return binary("*", binary("*", w, binary("^", u, binary("-", w, num(1)))), du), nil
+		}
+		// (u^w)' = u^w * (w'*ln(u) + w*u'/u)
+		return binary("*", binary("^", u, w),
+			binary("+", binary("*", dw, call("ln", u)), binary("/", binary("*", w, du), u)),
+		), nil
+	}
+	return nil, fmt.Errorf("производная операции '%s' не поддерживается", n.Op)
+}
+
+// Simplify сворачивает константы и убирает тождественные операции до неподвижной точки.
+// Исходное дерево не изменяется.
+func Simplify(n *Node) *Node {
+	for {
+		out := simplify(n)
+		if out.String() == n.String() {
+			return out
+		}
+		n = out
+	}
+}
+
+func simplify(n *Node) *Node {
+	if n == nil || n.IsLeaf() {
+		return n
+	}
+	left := simplify(n.Left)
+	right := simplify(n.Right)
+	n = &Node{Op: n.Op, Left: left, Right: right, Unit: n.Unit}
+
+	if n.IsFunction() {
+		if left.Value != nil {
+			if v, ok := evalFunction(n.Op, *left.Value); ok {
+				return num(v)
+			}
+		}
+		return n
+	}
+
+	if left.Value != nil && right.Value != nil {
+		if v, ok := evalSymbolic(n.Op, *left.Value, *right.Value); ok {
+			return num(v)
+		}
+	}
+
+	switch n.Op {
+	case "+":
+		if isConst(left, 0) {
+			return right
+		}
+		if isConst(right, 0) {
+			return left
+		}
+	case "-":
+		if isConst(right, 0) {
+			return left
+		}
+		if isConst(left, 0) {
+			return binary("*", num(-1), right)
+		}
+		if left.String() == right.String() {
+			return num(0)
+		}
+	case "*":
+		if isConst(left, 0) || isConst(right, 0) {
+			return num(0)
+		}
+		if isConst(left, 1) {
+			return right
+		}
+		if isConst(right, 1) {
+			return left
+		}
+		// Константу выносим влево и объединяем с константой вложенного произведения.
+		if right.Value != nil && left.Value == nil {
+			return binary("*", right, left)
+		}
+		if left.Value != nil && right.Op == "*" && right.Left.Value != nil {
+			return binary("*", num(*left.Value**right.Left.Value), right.Right)
+		}
+	case "/":
+		if isConst(left, 0) {
+			return num(0)
+		}
+		if isConst(right, 1) {
+			return left
+		}
+	case "^":
+		if isConst(right, 0) {
+			return num(1)
+		}
+		if isConst(right, 1) {
+			return left
+		}
+		if isConst(left, 1) {
+			return num(1)
+		}
+	}
+	return n
+}
+
+// evalSymbolic вычисляет бинарную операцию над константами при упрощении.
+// Неопределенные результаты (деление на ноль и т.п.) оставляются как есть.
+func evalSymbolic(op string, a, b float64) (float64, bool) {
+	if op == "^" {
+		v := math.Pow(a, b)
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	}
+	return evalLocal(op, a, b)
+}
+
+func evalFunction(fn string, a float64) (float64, bool) {
+	var v float64
+	switch fn {
+	case "sin":
+		v = math.Sin(a)
+	case "cos":
+		v = math.Cos(a)
+	case "tan":
+		v = math.Tan(a)
+	case "exp":
+		v = math.Exp(a)
+	case "ln":
+		v = math.Log(a)
+	case "sqrt":
+		v = math.Sqrt(a)
+	case "abs":
+		v = math.Abs(a)
+	case "median":
+		v = a
+	default:
+		return 0, false
+	}
+	return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// Substitute подставляет значения переменных и возвращает новое дерево.
+func Substitute(n *Node, values map[string]float64) *Node {
+	if n == nil {
+		return nil
+	}
+	if n.Value != nil {
+		return &Node{Value: num(*n.Value).Value, Unit: n.Unit}
+	}
+	if n.Tensor != nil {
+		return &Node{Tensor: n.Tensor}
+	}
+	if n.Complex != nil {
+		c := *n.Complex
+		return &Node{Complex: &c}
+	}
+	if n.Interval != nil {
+		return &Node{Interval: n.Interval}
+	}
+	if n.Var != "" {
+		if v, ok := values[n.Var]; ok {
+			return num(v)
+		}
+		return &Node{Var: n.Var}
+	}
+	return &Node{Op: n.Op, Left: Substitute(n.Left, values), Right: Substitute(n.Right, values), Unit: n.Unit}
+}
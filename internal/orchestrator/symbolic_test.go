@@ -0,0 +1,60 @@
+package orchestrator
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDerive(t *testing.T) {
+	tests := []struct{ input, want string }{
+		{"x^2", "2*x"},
+		{"3*x+5", "3"},
+		{"y*x", "y"},
+		{"sin(x)", "cos(x)"},
+		{"x^2*sin(x)", "2*x*sin(x)+x^2*cos(x)"},
+		{"ln(x)", "1/x"},
+		{"exp(2*x)", "2*exp(2*x)"},
+		{"1/x", "-1/x^2"},
+		{"7", "0"},
+	}
+	for _, tc := range tests {
+		ast, err := NewParser(tc.input).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", tc.input, err)
+		}
+		d, err := Derive(ast, "x")
+		if err != nil {
+			t.Fatalf("Derive(%q) returned error: %v", tc.input, err)
+		}
+		if got := d.Pretty(); got != tc.want {
+			t.Errorf("Derive(%q) = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+// Производная должна совпадать с разностной аппроксимацией.
+func TestDeriveNumerically(t *testing.T) {
+	inputs := []string{"x^2*sin(x)", "sqrt(x)/(1+x)", "x^x", "tan(x)*exp(-x)", "cos(x^3)-ln(x)"}
+	const x0, h = 1.3, 1e-6
+	for _, input := range inputs {
+		ast, err := NewParser(input).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error: %v", input, err)
+		}
+		d, err := Derive(ast, "x")
+		if err != nil {
+			t.Fatalf("Derive(%q) returned error: %v", input, err)
+		}
+		at := func(n *Node, x float64) float64 {
+			v := Simplify(Substitute(n, map[string]float64{"x": x}))
+			if v.Value == nil {
+				t.Fatalf("%q did not evaluate to a number: %s", input, v.Pretty())
+			}
+			return *v.Value
+		}
+		want := (at(ast, x0+h) - at(ast, x0-h)) / (2 * h)
+		if got := at(d, x0); math.Abs(got-want) > 1e-5 {
+			t.Errorf("d/dx %q at %v = %v, numeric %v", input, x0, got, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package orchestrator
+
+import (
+	"calculator/internal/units"
+	"fmt"
+	"math"
+)
+
+// Dimension проверяет согласованность единиц измерения в дереве и возвращает размерность
+// результата. Переменные, векторы, комплексные числа и интервалы считаются безразмерными.
+func Dimension(n *Node) (units.Dim, error) {
+	var none units.Dim
+	if n == nil || n.Tensor != nil || n.Complex != nil || n.Interval != nil || n.Var != "" {
+		return none, nil
+	}
+	if n.Value != nil {
+		if n.Unit == "" {
+			return none, nil
+		}
+		u, err := units.Parse(n.Unit)
+		return u.Dim, err
+	}
+
+	left, err := Dimension(n.Left)
+	if err != nil {
+		return none, err
+	}
+	right, err := Dimension(n.Right)
+	if err != nil {
+		return none, err
+	}
+
+	switch n.Op {
+	case "+", "-", "merge":
+		if left != right {
+			return none, fmt.Errorf("несовместимые единицы в операции '%s': %s и %s", n.Op, dimName(left), dimName(right))
+		}
+		return left, nil
+	case "*", "dot":
+		return left.Mul(right), nil
+	case "/":
+		return left.Div(right), nil
+	case "^":
+		if !right.IsDimensionless() {
+			return none, fmt.Errorf("показатель степени должен быть безразмерным, получено %s", dimName(right))
+		}
+		if left.IsDimensionless() {
+			return none, nil
+		}
+		if n.Right.Value == nil || *n.Right.Value != math.Trunc(*n.Right.Value) {
+			return none, fmt.Errorf("величину с единицами (%s) можно возводить только в целую постоянную степень", dimName(left))
+		}
+		return left.Pow(int(*n.Right.Value)), nil
+	case "in":
+		target, err := units.Parse(n.Unit)
+		if err != nil {
+			return none, err
+		}
+		if target.Dim != left {
+			return none, fmt.Errorf("нельзя перевести %s в %s", dimName(left), n.Unit)
+		}
+		return left, nil
+	case "sqrt":
+		d, ok := left.Root(2)
+		if !ok {
+			return none, fmt.Errorf("корень из величины с единицами %s не имеет смысла", dimName(left))
+		}
+		return d, nil
+	case "abs", "median":
+		return left, nil
+	}
+	// Остальные функции (sin, exp, ln, ...) определены только для безразмерных чисел.
+	if !left.IsDimensionless() {
+		return none, fmt.Errorf("функция '%s' принимает безразмерный аргумент, получено %s", n.Op, dimName(left))
+	}
+	return none, nil
+}
+
+func dimName(d units.Dim) string {
+	if d.IsDimensionless() {
+		return "безразмерная величина"
+	}
+	return d.String()
+}
+
+// hasUnits сообщает, есть ли в дереве величины с единицами измерения.
+func hasUnits(n *Node) bool {
+	if n == nil {
+		return false
+	}
+	return n.Unit != "" || hasUnits(n.Left) || hasUnits(n.Right)
+}
+
+// toSI переводит все величины в единицы СИ, после чего дерево считается обычными задачами.
+// Перевод "x in km/h" становится делением на множитель целевой единицы.
+// Возвращает новое дерево и единицу, в которой будет получен результат.
+func toSI(n *Node) (*Node, string, error) {
+	dim, err := Dimension(n)
+	if err != nil {
+		return nil, "", err
+	}
+	unit := dim.String()
+	if n.Op == "in" {
+		unit = n.Unit
+	}
+	return convertSI(n), unit, nil
+}
+
+func convertSI(n *Node) *Node {
+	if n == nil {
+		return nil
+	}
+	if n.Value != nil && n.Unit != "" {
+		u, _ := units.Parse(n.Unit) // Единицы уже проверены Dimension
+		v := *n.Value * u.Factor
+		return &Node{Value: &v, Unit: u.Dim.String()}
+	}
+	if n.Op == "in" {
+		target, _ := units.Parse(n.Unit)
+		if target.Factor == 1 {
+			return convertSI(n.Left)
+		}
+		return binary("/", convertSI(n.Left), num(target.Factor))
+	}
+	if n.IsLeaf() {
+		return n
+	}
+	return &Node{Op: n.Op, Left: convertSI(n.Left), Right: convertSI(n.Right)}
+}
+
+// siUnit возвращает единицу результата узла в СИ или пустую строку для безразмерного.
+func siUnit(n *Node) string {
+	dim, err := Dimension(n)
+	if err != nil {
+		return ""
+	}
+	return dim.String()
+}
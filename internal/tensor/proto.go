@@ -0,0 +1,28 @@
+package tensor
+
+import (
+	pb "calculator/internal/grpc/calculator"
+)
+
+// FromProto преобразует тензор из gRPC-сообщения; nil означает, что аргумент - число.
+func FromProto(t *pb.Tensor) (*Tensor, error) {
+	if t == nil {
+		return nil, nil
+	}
+	shape := make([]int, len(t.Shape))
+	for i, d := range t.Shape {
+		shape[i] = int(d)
+	}
+	return New(shape, t.Data)
+}
+
+func (t *Tensor) ToProto() *pb.Tensor {
+	if t == nil {
+		return nil
+	}
+	shape := make([]int32, len(t.Shape))
+	for i, d := range t.Shape {
+		shape[i] = int32(d)
+	}
+	return &pb.Tensor{Shape: shape, Data: t.Data}
+}
@@ -0,0 +1,284 @@
+package tensor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tensor - вектор или матрица. Элементы хранятся построчно; скаляр - тензор с пустой формой.
+type Tensor struct {
+	Shape []int
+	Data  []float64
+}
+
+// MaxRank - максимальная размерность: поддерживаются только векторы и матрицы.
+const MaxRank = 2
+
+func New(shape []int, data []float64) (*Tensor, error) {
+	if len(shape) > MaxRank {
+		return nil, fmt.Errorf("поддерживаются только векторы и матрицы, размерность %d", len(shape))
+	}
+	size := 1
+	for _, d := range shape {
+		if d <= 0 {
+			return nil, fmt.Errorf("недопустимая форма %v", shape)
+		}
+		size *= d
+	}
+	if size != len(data) {
+		return nil, fmt.Errorf("форма %v не соответствует %d элементам", shape, len(data))
+	}
+	return &Tensor{Shape: shape, Data: data}, nil
+}
+
+func Scalar(v float64) *Tensor {
+	return &Tensor{Data: []float64{v}}
+}
+
+func (t *Tensor) Rank() int {
+	return len(t.Shape)
+}
+
+func (t *Tensor) IsScalar() bool {
+	return len(t.Shape) == 0
+}
+
+func (t *Tensor) sameShape(o *Tensor) bool {
+	if len(t.Shape) != len(o.Shape) {
+		return false
+	}
+	for i := range t.Shape {
+		if t.Shape[i] != o.Shape[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String выводит тензор вложенными списками, как в выражении: [1,2,3], [[1,2],[3,4]].
+// Запись - это и JSON тензора, поэтому бесконечности и NaN выводятся строками: [1,"+Inf"].
+func (t *Tensor) String() string {
+	var b strings.Builder
+	var write func(dim, offset int) int
+	write = func(dim, offset int) int {
+		if dim == len(t.Shape) {
+			v := strconv.FormatFloat(t.Data[offset], 'g', -1, 64)
+			if math.IsInf(t.Data[offset], 0) || math.IsNaN(t.Data[offset]) {
+				v = strconv.Quote(v)
+			}
+			b.WriteString(v)
+			return offset + 1
+		}
+		b.WriteByte('[')
+		for i := 0; i < t.Shape[dim]; i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			offset = write(dim+1, offset)
+		}
+		b.WriteByte(']')
+		return offset
+	}
+	write(0, 0)
+	return b.String()
+}
+
+func (t *Tensor) MarshalJSON() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalJSON принимает число или прямоугольный вложенный список чисел.
+func (t *Tensor) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	parsed, err := FromNested(v)
+	if err != nil {
+		return err
+	}
+	*t = *parsed
+	return nil
+}
+
+// FromNested строит тензор из числа или вложенных []interface{} (результат json.Unmarshal).
+// Форма определяется по первым элементам, остальные списки должны ей соответствовать.
+func FromNested(v interface{}) (*Tensor, error) {
+	var shape []int
+	for cur := v; ; {
+		list, ok := cur.([]interface{})
+		if !ok {
+			break
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("пустой список в тензоре")
+		}
+		shape = append(shape, len(list))
+		cur = list[0]
+	}
+
+	var data []float64
+	var walk func(v interface{}, dim int) error
+	walk = func(v interface{}, dim int) error {
+		if dim == len(shape) {
+			x, ok := v.(float64)
+			if s, isString := v.(string); isString {
+				x, ok = special(s)
+			}
+			if !ok {
+				return fmt.Errorf("непрямоугольный тензор или элемент не число")
+			}
+			data = append(data, x)
+			return nil
+		}
+		list, ok := v.([]interface{})
+		if !ok || len(list) != shape[dim] {
+			return fmt.Errorf("непрямоугольный тензор")
+		}
+		for _, e := range list {
+			if err := walk(e, dim+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(v, 0); err != nil {
+		return nil, err
+	}
+	return New(shape, data)
+}
+
+// special разбирает записи "+Inf", "-Inf" и "NaN", которыми String выводит элементы вне JSON.
+func special(s string) (float64, bool) {
+	x, err := strconv.ParseFloat(s, 64)
+	return x, err == nil && (math.IsInf(x, 0) || math.IsNaN(x))
+}
+
+// Parse разбирает JSON-представление тензора; пустая строка означает отсутствие тензора.
+func Parse(s string) (*Tensor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t := &Tensor{}
+	if err := t.UnmarshalJSON([]byte(s)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ScalarFunc вычисляет операцию над двумя числами (у функций второй аргумент не используется).
+type ScalarFunc func(arg1, arg2 float64, op string) (float64, error)
+
+// Apply выполняет операцию над тензорами. "dot" - скалярное произведение векторов,
+// "*" для матрицы слева или справа от матрицы/вектора - матричное произведение,
+// "merge" - упорядоченный вектор из элементов обоих аргументов, "median" - медиана элементов,
+// остальные операции и функции (b == nil) применяются поэлементно; скаляр
+// распространяется на все элементы другого операнда.
+func Apply(op string, a, b *Tensor, f ScalarFunc) (*Tensor, error) {
+	if op == "median" {
+		return median(a), nil
+	}
+	if b == nil {
+		out := &Tensor{Shape: a.Shape, Data: make([]float64, len(a.Data))}
+		for i, x := range a.Data {
+			v, err := f(x, 0, op)
+			if err != nil {
+				return nil, err
+			}
+			out.Data[i] = v
+		}
+		return out, nil
+	}
+
+	switch {
+	case op == "dot":
+		if a.Rank() != 1 || !a.sameShape(b) {
+			return nil, fmt.Errorf("dot: ожидались векторы одной длины, получены формы %v и %v", a.Shape, b.Shape)
+		}
+		sum := 0.0
+		for i := range a.Data {
+			sum += a.Data[i] * b.Data[i]
+		}
+		return Scalar(sum), nil
+	case op == "*" && (a.Rank() == 2 && b.Rank() >= 1 || a.Rank() >= 1 && b.Rank() == 2):
+		return matMul(a, b)
+	case op == "merge":
+		data := append(append(make([]float64, 0, len(a.Data)+len(b.Data)), a.Data...), b.Data...)
+		sort.Float64s(data)
+		return &Tensor{Shape: []int{len(data)}, Data: data}, nil
+	}
+
+	var shape []int
+	switch {
+	case a.sameShape(b):
+		shape = a.Shape
+	case a.IsScalar():
+		shape = b.Shape
+	case b.IsScalar():
+		shape = a.Shape
+	default:
+		return nil, fmt.Errorf("операция '%s': несовместимые формы %v и %v", op, a.Shape, b.Shape)
+	}
+	size := max(len(a.Data), len(b.Data))
+	out := &Tensor{Shape: shape, Data: make([]float64, size)}
+	for i := range out.Data {
+		v, err := f(a.Data[i%len(a.Data)], b.Data[i%len(b.Data)], op)
+		if err != nil {
+			return nil, err
+		}
+		out.Data[i] = v
+	}
+	return out, nil
+}
+
+// matMul перемножает матрицы; вектор слева считается строкой, справа - столбцом.
+func matMul(a, b *Tensor) (*Tensor, error) {
+	rows, inner := 1, a.Shape[0]
+	if a.Rank() == 2 {
+		rows, inner = a.Shape[0], a.Shape[1]
+	}
+	cols := 1
+	if b.Rank() == 2 {
+		cols = b.Shape[1]
+	}
+	if b.Shape[0] != inner {
+		return nil, fmt.Errorf("матричное произведение: несовместимые формы %v и %v", a.Shape, b.Shape)
+	}
+
+	data := make([]float64, rows*cols)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			sum := 0.0
+			for k := 0; k < inner; k++ {
+				sum += a.Data[i*inner+k] * b.Data[k*cols+j]
+			}
+			data[i*cols+j] = sum
+		}
+	}
+
+	var shape []int
+	switch {
+	case a.Rank() == 2 && b.Rank() == 2:
+		shape = []int{rows, cols}
+	case a.Rank() == 2:
+		shape = []int{rows}
+	default:
+		shape = []int{cols}
+	}
+	return &Tensor{Shape: shape, Data: data}, nil
+}
+
+// median возвращает медиану элементов; при четном их числе - среднее двух средних.
+func median(t *Tensor) *Tensor {
+	data := append([]float64(nil), t.Data...)
+	sort.Float64s(data)
+	mid := len(data) / 2
+	if len(data)%2 == 0 {
+		return Scalar((data[mid-1] + data[mid]) / 2)
+	}
+	return Scalar(data[mid])
+}
@@ -0,0 +1,92 @@
+package tensor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func scalarOp(a, b float64, op string) (float64, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, fmt.Errorf("деление на ноль")
+		}
+		return a / b, nil
+	}
+	return 0, fmt.Errorf("неизвестная операция: %s", op)
+}
+
+func mustParse(t *testing.T, s string) *Tensor {
+	t.Helper()
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q) error: %v", s, err)
+	}
+	return v
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      string
+		a, b    string
+		want    string
+		wantErr bool
+	}{
+		{"ScaleVector", "*", "[1,2,3]", "2", "[2,4,6]", false},
+		{"ElementwiseAdd", "+", "[1,2]", "[10,20]", "[11,22]", false},
+		{"Dot", "dot", "[1,2]", "[3,4]", "11", false},
+		{"MatMul", "*", "[[1,2],[3,4]]", "[[5,6],[7,8]]", "[[19,22],[43,50]]", false},
+		{"MatVec", "*", "[[1,2],[3,4]]", "[1,1]", "[3,7]", false},
+		{"ShapeMismatch", "+", "[1,2]", "[1,2,3]", "", true},
+		{"MatMulMismatch", "*", "[[1,2,3]]", "[[1,2]]", "", true},
+		{"ElementError", "/", "[1,2]", "[1,0]", "", true},
+		{"MergeScalars", "merge", "3", "1", "[1,3]", false},
+		{"MergeVectors", "merge", "[1,5]", "[2,3,4]", "[1,2,3,4,5]", false},
+		{"MedianOdd", "median", "[5,1,4]", "0", "4", false},
+		{"MedianEven", "median", "[5,1,4,2]", "0", "3", false},
+	}
+	for _, tc := range tests {
+		got, err := Apply(tc.op, mustParse(t, tc.a), mustParse(t, tc.b), scalarOp)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && got.String() != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTensorJSON(t *testing.T) {
+	var v Tensor
+	if err := json.Unmarshal([]byte("[[1, 2.5], [-3, 4]]"), &v); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if v.Rank() != 2 || v.Shape[0] != 2 || v.Shape[1] != 2 {
+		t.Fatalf("unexpected shape %v", v.Shape)
+	}
+	data, _ := json.Marshal(&v)
+	if string(data) != "[[1,2.5],[-3,4]]" {
+		t.Errorf("Marshal = %s", data)
+	}
+	// Бесконечности и NaN записываются строками, чтобы JSON оставался корректным.
+	special := &Tensor{Shape: []int{3}, Data: []float64{math.Inf(1), math.Inf(-1), math.NaN()}}
+	if got := special.String(); got != `["+Inf","-Inf","NaN"]` {
+		t.Errorf("String() = %s", got)
+	}
+	if back := mustParse(t, special.String()); !math.IsInf(back.Data[0], 1) || !math.IsInf(back.Data[1], -1) || !math.IsNaN(back.Data[2]) {
+		t.Errorf("Parse(%s) = %v", special, back.Data)
+	}
+	for _, bad := range []string{"[[1,2],[3]]", "[]", "[[[1]]]", `["a"]`, `["1"]`} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%s): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Базовые величины СИ в порядке, в котором они выводятся в строке единицы.
+const (
+	Mass = iota
+	Length
+	Time
+	Current
+	Temperature
+	Amount
+	Luminosity
+	baseCount
+)
+
+var baseSymbols = [baseCount]string{"kg", "m", "s", "A", "K", "mol", "cd"}
+
+// Dim - размерность: показатели степеней базовых величин. Нулевое значение - безразмерная величина.
+type Dim [baseCount]int
+
+// Unit - единица измерения: множитель перевода в СИ и размерность.
+type Unit struct {
+	Factor float64
+	Dim    Dim
+}
+
+func base(i int) Dim {
+	var d Dim
+	d[i] = 1
+	return d
+}
+
+// known - поддерживаемые единицы. Составные записываются через '*', '/' и '^': km/h, m/s^2.
+// Единицы со сдвигом нуля (градусы Цельсия и Фаренгейта) не поддерживаются.
+var known = map[string]Unit{
+	// Длина
+	"m":    {1, base(Length)},
+	"km":   {1000, base(Length)},
+	"cm":   {0.01, base(Length)},
+	"mm":   {0.001, base(Length)},
+	"um":   {1e-6, base(Length)},
+	"nm":   {1e-9, base(Length)},
+	"mi":   {1609.344, base(Length)},
+	"yd":   {0.9144, base(Length)},
+	"ft":   {0.3048, base(Length)},
+	"inch": {0.0254, base(Length)}, // "in" занято под перевод единиц
+	"nmi":  {1852, base(Length)},
+	// Масса
+	"kg": {1, base(Mass)},
+	"g":  {0.001, base(Mass)},
+	"mg": {1e-6, base(Mass)},
+	"lb": {0.45359237, base(Mass)},
+	"oz": {0.028349523125, base(Mass)},
+	// Время
+	"s":   {1, base(Time)},
+	"ms":  {0.001, base(Time)},
+	"min": {60, base(Time)},
+	"h":   {3600, base(Time)},
+	"day": {86400, base(Time)},
+	// Остальные базовые величины
+	"A":   {1, base(Current)},
+	"K":   {1, base(Temperature)},
+	"mol": {1, base(Amount)},
+	"cd":  {1, base(Luminosity)},
+	// Производные единицы
+	"mph": {0.44704, Dim{Length: 1, Time: -1}},
+	"kn":  {1852.0 / 3600, Dim{Length: 1, Time: -1}},
+	"L":   {0.001, Dim{Length: 3}},
+	"Hz":  {1, Dim{Time: -1}},
+	"N":   {1, Dim{Mass: 1, Length: 1, Time: -2}},
+	"J":   {1, Dim{Mass: 1, Length: 2, Time: -2}},
+	"kJ":  {1000, Dim{Mass: 1, Length: 2, Time: -2}},
+	"kWh": {3.6e6, Dim{Mass: 1, Length: 2, Time: -2}},
+	"W":   {1, Dim{Mass: 1, Length: 2, Time: -3}},
+	"kW":  {1000, Dim{Mass: 1, Length: 2, Time: -3}},
+	"Pa":  {1, Dim{Mass: 1, Length: -1, Time: -2}},
+	"kPa": {1000, Dim{Mass: 1, Length: -1, Time: -2}},
+	"bar": {1e5, Dim{Mass: 1, Length: -1, Time: -2}},
+	"V":   {1, Dim{Mass: 1, Length: 2, Time: -3, Current: -1}},
+}
+
+// IsUnit сообщает, является ли имя известной единицей измерения.
+func IsUnit(name string) bool {
+	_, ok := known[name]
+	return ok
+}
+
+// Parse разбирает запись единицы: имена известных единиц, соединенные '*' и '/',
+// с необязательной целой степенью: "km/h", "kg*m/s^2", "m^-1".
+func Parse(s string) (Unit, error) {
+	u := Unit{Factor: 1}
+	s = strings.ReplaceAll(s, " ", "")
+	if s == "" {
+		return u, fmt.Errorf("пустая единица измерения")
+	}
+	sign := 1
+	for s != "" {
+		end := strings.IndexAny(s, "*/")
+		if end < 0 {
+			end = len(s)
+		}
+		part := s[:end]
+		name, power := part, 1
+		if i := strings.IndexByte(part, '^'); i >= 0 {
+			p, err := strconv.Atoi(part[i+1:])
+			if err != nil {
+				return u, fmt.Errorf("некорректная степень в единице '%s'", part)
+			}
+			name, power = part[:i], p
+		}
+		k, ok := known[name]
+		if !ok {
+			return u, fmt.Errorf("неизвестная единица измерения '%s'", name)
+		}
+		k = k.Pow(sign * power)
+		u = Unit{Factor: u.Factor * k.Factor, Dim: u.Dim.Mul(k.Dim)}
+
+		s = s[end:]
+		if s != "" {
+			op := s[0]
+			sign = 1
+			if op == '/' {
+				sign = -1
+			}
+			s = s[1:]
+			if s == "" {
+				return u, fmt.Errorf("ожидалась единица после '%c'", op)
+			}
+		}
+	}
+	return u, nil
+}
+
+func (u Unit) Pow(p int) Unit {
+	f := 1.0
+	for i := 0; i < abs(p); i++ {
+		f *= u.Factor
+	}
+	if p < 0 {
+		f = 1 / f
+	}
+	return Unit{Factor: f, Dim: u.Dim.Pow(p)}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func (d Dim) Mul(o Dim) Dim {
+	for i := range d {
+		d[i] += o[i]
+	}
+	return d
+}
+
+func (d Dim) Div(o Dim) Dim {
+	for i := range d {
+		d[i] -= o[i]
+	}
+	return d
+}
+
+func (d Dim) Pow(p int) Dim {
+	for i := range d {
+		d[i] *= p
+	}
+	return d
+}
+
+// Root извлекает корень степени p; ok == false, если показатели на p не делятся.
+func (d Dim) Root(p int) (Dim, bool) {
+	for i := range d {
+		if d[i]%p != 0 {
+			return d, false
+		}
+		d[i] /= p
+	}
+	return d, true
+}
+
+func (d Dim) IsDimensionless() bool {
+	return d == Dim{}
+}
+
+// String выводит размерность в базовых единицах СИ: "m/s^2", "kg*m^2/s^3".
+// Результат снова разбирается Parse; у безразмерной величины - пустая строка.
+func (d Dim) String() string {
+	var num, den []string
+	for i, p := range d {
+		switch {
+		case p == 1:
+			num = append(num, baseSymbols[i])
+		case p > 1:
+			num = append(num, fmt.Sprintf("%s^%d", baseSymbols[i], p))
+		case p == -1:
+			den = append(den, baseSymbols[i])
+		case p < -1:
+			den = append(den, fmt.Sprintf("%s^%d", baseSymbols[i], -p))
+		}
+	}
+	switch {
+	case len(num) == 0 && len(den) == 0:
+		return ""
+	case len(den) == 0:
+		return strings.Join(num, "*")
+	case len(num) == 0:
+		// Без числителя отрицательные степени записываются явно: 1/s -> s^-1.
+		for i, p := range d {
+			if p < 0 {
+				num = append(num, fmt.Sprintf("%s^%d", baseSymbols[i], p))
+			}
+		}
+		return strings.Join(num, "*")
+	}
+	return strings.Join(num, "*") + "/" + strings.Join(den, "/")
+}
@@ -0,0 +1,47 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		unit   string
+		factor float64
+		dim    string
+	}{
+		{"km", 1000, "m"},
+		{"km/h", 1000.0 / 3600, "m/s"},
+		{"m/s^2", 1, "m/s^2"},
+		{"kg*m/s^2", 1, "kg*m/s^2"},
+		{"N", 1, "kg*m/s^2"},
+		{"mph", 0.44704, "m/s"},
+		{"Hz", 1, "s^-1"},
+		{"cm^2", 1e-4, "m^2"},
+		{"m/m", 1, ""},
+	}
+	for _, tc := range tests {
+		u, err := Parse(tc.unit)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tc.unit, err)
+			continue
+		}
+		if math.Abs(u.Factor-tc.factor) > 1e-12*tc.factor || u.Dim.String() != tc.dim {
+			t.Errorf("Parse(%q) = %v %q, want %v %q", tc.unit, u.Factor, u.Dim.String(), tc.factor, tc.dim)
+		}
+		if tc.dim == "" {
+			continue
+		}
+		// Строка размерности снова разбирается в ту же размерность.
+		if back, err := Parse(tc.dim); err != nil || back.Dim != u.Dim || back.Factor != 1 {
+			t.Errorf("Parse(%q) = %v, %v; want dimension of %q", tc.dim, back, err, tc.unit)
+		}
+	}
+
+	for _, bad := range []string{"", "parsec", "m/", "m^x"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q): expected error", bad)
+		}
+	}
+}